@@ -0,0 +1,31 @@
+package activemq
+
+import (
+	"context"
+	"time"
+
+	"github.com/core-go/mq"
+	"github.com/go-stomp/stomp/v3"
+)
+
+// Delivery acks or nacks the STOMP message. It is used when AckOnConsume is false and the ack mode is not auto.
+type Delivery struct {
+	Conn    *stomp.Conn
+	Message *stomp.Message
+}
+
+func NewDelivery(conn *stomp.Conn, msg *stomp.Message) *Delivery {
+	return &Delivery{Conn: conn, Message: msg}
+}
+func (d *Delivery) Ack(ctx context.Context) error {
+	return d.Conn.Ack(d.Message)
+}
+func (d *Delivery) Nack(ctx context.Context) error {
+	return d.Conn.Nack(d.Message)
+}
+func (d *Delivery) Requeue(ctx context.Context, delay time.Duration) error {
+	if delay > 0 {
+		return mq.ErrRequeueNotSupported
+	}
+	return d.Conn.Nack(d.Message)
+}
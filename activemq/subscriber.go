@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/core-go/mq"
 	"github.com/go-stomp/stomp/v3"
 	"github.com/go-stomp/stomp/v3/frame"
 )
@@ -13,7 +14,9 @@ type Subscriber struct {
 	Subscription *stomp.Subscription
 	AckMode      stomp.AckMode
 	LogError     func(ctx context.Context, msg string)
+	HandleError  func(context.Context, []byte, map[string]string)
 	AckOnConsume bool
+	canceler     mq.Canceler
 }
 
 func NewSubscriber(client *stomp.Conn, destinationName string, subscriptionName string, ackMode stomp.AckMode, logError func(ctx context.Context, msg string), ackOnConsume bool) (*Subscriber, error) {
@@ -34,21 +37,37 @@ func NewSubscriberByConfig(c Config, ackMode stomp.AckMode, logError func(ctx co
 	}
 	return NewSubscriber(client, c.DestinationName, c.SubscriptionName, ackMode, logError, ackOnConsume)
 }
+
+// SubscribeMessage reads the messages until ctx is done, Close is called or the subscription is closed
 func (c *Subscriber) SubscribeMessage(ctx context.Context, handle func(context.Context, *stomp.Message)) {
-	for msg := range c.Subscription.C {
+	handle = mq.Recover(handle, c.LogError)
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	for {
+		msg, ok := mq.Receive(ctx2, c.Subscription.C)
+		if !ok {
+			return
+		}
 		if msg.Err != nil {
 			c.LogError(ctx, "Error when subscribe: "+msg.Err.Error())
 		} else {
 			if c.AckOnConsume && c.AckMode != stomp.AckAuto {
 				c.Conn.Ack(msg)
 			}
-			handle(ctx, msg)
+			handle(c.withDelivery(ctx, msg), msg)
 		}
 
 	}
 }
 func (c *Subscriber) Subscribe(ctx context.Context, handle func(context.Context, []byte, map[string]string)) {
-	for msg := range c.Subscription.C {
+	handle = mq.RecoverWithMap(handle, c.LogError, c.HandleError)
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	for {
+		msg, ok := mq.Receive(ctx2, c.Subscription.C)
+		if !ok {
+			return
+		}
 		if msg.Err != nil {
 			c.LogError(ctx, "Error when subscribe: "+msg.Err.Error())
 		} else {
@@ -56,23 +75,45 @@ func (c *Subscriber) Subscribe(ctx context.Context, handle func(context.Context,
 				c.Conn.Ack(msg)
 			}
 			attributes := HeaderToMap(msg.Header)
-			handle(ctx, msg.Body, attributes)
+			handle(c.withDelivery(ctx, msg), msg.Body, attributes)
 		}
 
 	}
 }
 func (c *Subscriber) SubscribeBody(ctx context.Context, handle func(context.Context, []byte)) {
-	for msg := range c.Subscription.C {
+	handle = mq.Recover(handle, c.LogError)
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	for {
+		msg, ok := mq.Receive(ctx2, c.Subscription.C)
+		if !ok {
+			return
+		}
 		if msg.Err != nil {
 			c.LogError(ctx, "Error when subscribe: "+msg.Err.Error())
 		} else {
 			if c.AckOnConsume && c.AckMode != stomp.AckAuto {
 				c.Conn.Ack(msg)
 			}
-			handle(ctx, msg.Body)
+			handle(c.withDelivery(ctx, msg), msg.Body)
 		}
 	}
 }
+
+// Close stops the subscribing loops, unsubscribes and disconnects
+func (c *Subscriber) Close() error {
+	c.canceler.Cancel()
+	if err := c.Subscription.Unsubscribe(); err != nil && c.LogError != nil {
+		c.LogError(context.Background(), "Error when unsubscribe: "+err.Error())
+	}
+	return c.Conn.Disconnect()
+}
+func (c *Subscriber) withDelivery(ctx context.Context, msg *stomp.Message) context.Context {
+	if c.AckOnConsume || c.AckMode == stomp.AckAuto {
+		return ctx
+	}
+	return mq.WithDelivery(ctx, NewDelivery(c.Conn, msg))
+}
 func HeaderToMap(header *frame.Header) map[string]string {
 	attributes := make(map[string]string, 0)
 	for i := 0; i < header.Len(); i++ {
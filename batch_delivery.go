@@ -0,0 +1,122 @@
+package mq
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RawMessage is a message of a batch, which is received by a batch consumer, such as kafka Reader.ReadBatch, before it is unmarshalled
+type RawMessage struct {
+	Data       []byte
+	Attributes map[string]string
+	Delivery   Delivery
+}
+
+const (
+	offsetPending int32 = iota
+	offsetAcked
+	offsetNacked
+)
+
+// OffsetDelivery records the acknowledgement of a message of a batch. The batch consumers commit the offsets by OffsetTracker, after the batch is handled.
+// Requeue is the same as Nack, because the offset of a partition cannot be requeued.
+type OffsetDelivery struct {
+	Partition string
+	Offset    int64
+	state     int32
+}
+
+func NewOffsetDelivery(partition string, offset int64) *OffsetDelivery {
+	return &OffsetDelivery{Partition: partition, Offset: offset}
+}
+func (d *OffsetDelivery) Ack(ctx context.Context) error {
+	atomic.StoreInt32(&d.state, offsetAcked)
+	return nil
+}
+func (d *OffsetDelivery) Nack(ctx context.Context) error {
+	atomic.StoreInt32(&d.state, offsetNacked)
+	return nil
+}
+func (d *OffsetDelivery) Requeue(ctx context.Context, delay time.Duration) error {
+	atomic.StoreInt32(&d.state, offsetNacked)
+	return nil
+}
+func (d *OffsetDelivery) Acked() bool {
+	return atomic.LoadInt32(&d.state) == offsetAcked
+}
+
+// OffsetTracker finds the highest contiguous acked offset of each partition of the batches, which can be committed.
+// When a message is not acked, the partition is blocked at the offset of that message, so the later offsets are not committed,
+// until the message is received again, after the consumer restarts or the partitions are rebalanced.
+// The batch consumers stop when a message is not acked, because Kafka does not redeliver it in a live session.
+type OffsetTracker struct {
+	mu      sync.Mutex
+	blocked map[string]int64
+}
+
+func NewOffsetTracker() *OffsetTracker {
+	return &OffsetTracker{blocked: make(map[string]int64)}
+}
+
+// Received clears the block of the partition, if the message of the blocked offset is received again
+func (t *OffsetTracker) Received(partition string, offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if blocked, ok := t.blocked[partition]; ok && offset <= blocked {
+		delete(t.blocked, partition)
+	}
+}
+
+// Commits returns the indices of the deliveries which should be committed, one for each partition
+func (t *OffsetTracker) Commits(deliveries []*OffsetDelivery) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.blocked == nil {
+		t.blocked = make(map[string]int64)
+	}
+	indices := make([]int, len(deliveries))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return deliveries[indices[i]].Offset < deliveries[indices[j]].Offset
+	})
+	var partitions []string
+	groups := make(map[string][]int)
+	for _, i := range indices {
+		p := deliveries[i].Partition
+		if _, ok := groups[p]; !ok {
+			partitions = append(partitions, p)
+		}
+		groups[p] = append(groups[p], i)
+	}
+	result := make([]int, 0, len(partitions))
+	for _, p := range partitions {
+		if _, ok := t.blocked[p]; ok {
+			continue
+		}
+		group := groups[p]
+		k := lastAcked(len(group), func(i int) *OffsetDelivery { return deliveries[group[i]] })
+		if k >= 0 {
+			result = append(result, group[k])
+		}
+		if k+1 < len(group) {
+			t.blocked[p] = deliveries[group[k+1]].Offset
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+// lastAcked returns the index of the last acked delivery before the first delivery which is not acked, or -1.
+// The deliveries are of one partition, sorted by offset, so the offset of the result is the highest contiguous acked offset, which can be committed.
+func lastAcked(n int, delivery func(int) *OffsetDelivery) int {
+	i := 0
+	for i < n && delivery(i).Acked() {
+		i++
+	}
+	return i - 1
+}
@@ -0,0 +1,102 @@
+package mq
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// newBatch returns the deliveries of a batch, and acks the deliveries of acked, by index
+func newBatch(offsets map[string][]int64, order []string, acked ...int) []*OffsetDelivery {
+	var deliveries []*OffsetDelivery
+	for _, p := range order {
+		for _, offset := range offsets[p] {
+			deliveries = append(deliveries, NewOffsetDelivery(p, offset))
+		}
+	}
+	for _, i := range acked {
+		deliveries[i].Ack(context.Background())
+	}
+	return deliveries
+}
+
+func commitOffsets(deliveries []*OffsetDelivery, indices []int) map[string]int64 {
+	commits := make(map[string]int64)
+	for _, i := range indices {
+		commits[deliveries[i].Partition] = deliveries[i].Offset
+	}
+	return commits
+}
+
+func TestOffsetTrackerCommits(t *testing.T) {
+	offsets := map[string][]int64{"a": {10, 11, 12}, "b": {20, 21}}
+	order := []string{"a", "b"}
+	tests := []struct {
+		name    string
+		acked   []int
+		commits map[string]int64
+	}{
+		{name: "all acked", acked: []int{0, 1, 2, 3, 4}, commits: map[string]int64{"a": 12, "b": 21}},
+		{name: "out of order acks", acked: []int{2, 0, 1, 4, 3}, commits: map[string]int64{"a": 12, "b": 21}},
+		{name: "first unacked", acked: []int{1, 2, 3, 4}, commits: map[string]int64{"b": 21}},
+		{name: "middle unacked", acked: []int{0, 2, 3}, commits: map[string]int64{"a": 10, "b": 20}},
+		{name: "none acked", commits: map[string]int64{}},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			tracker := NewOffsetTracker()
+			deliveries := newBatch(offsets, order, c.acked...)
+			if commits := commitOffsets(deliveries, tracker.Commits(deliveries)); !reflect.DeepEqual(commits, c.commits) {
+				t.Errorf("Commits() = %v, want %v", commits, c.commits)
+			}
+		})
+	}
+}
+
+// TestOffsetTrackerCommitsUnsorted checks that the deliveries are committed by offset, not by their order in the batch
+func TestOffsetTrackerCommitsUnsorted(t *testing.T) {
+	tracker := NewOffsetTracker()
+	deliveries := []*OffsetDelivery{NewOffsetDelivery("a", 12), NewOffsetDelivery("a", 10), NewOffsetDelivery("a", 11)}
+	deliveries[1].Ack(context.Background())
+	deliveries[2].Ack(context.Background())
+	indices := tracker.Commits(deliveries)
+	if !reflect.DeepEqual(indices, []int{2}) {
+		t.Errorf("Commits() = %v, want [2], the offset 11 before the unacked offset 12", indices)
+	}
+}
+
+func TestOffsetTrackerBlocked(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewOffsetTracker()
+	first := []*OffsetDelivery{NewOffsetDelivery("a", 10), NewOffsetDelivery("a", 11), NewOffsetDelivery("b", 20)}
+	first[0].Ack(ctx)
+	first[2].Ack(ctx)
+	if commits := commitOffsets(first, tracker.Commits(first)); !reflect.DeepEqual(commits, map[string]int64{"a": 10, "b": 20}) {
+		t.Fatalf("Commits() = %v", commits)
+	}
+
+	// the partition a is blocked at 11, so the later offsets are not committed, even if they are acked
+	second := []*OffsetDelivery{NewOffsetDelivery("a", 12), NewOffsetDelivery("b", 21)}
+	second[0].Ack(ctx)
+	second[1].Ack(ctx)
+	if commits := commitOffsets(second, tracker.Commits(second)); !reflect.DeepEqual(commits, map[string]int64{"b": 21}) {
+		t.Errorf("Commits() = %v, want only b while a is blocked", commits)
+	}
+
+	// a later offset does not unblock the partition
+	tracker.Received("a", 13)
+	third := []*OffsetDelivery{NewOffsetDelivery("a", 13)}
+	third[0].Ack(ctx)
+	if indices := tracker.Commits(third); len(indices) != 0 {
+		t.Errorf("Commits() = %v, want none before the blocked offset is received again", indices)
+	}
+
+	// the blocked offset is received again, after the consumer restarts
+	tracker.Received("a", 11)
+	redelivered := []*OffsetDelivery{NewOffsetDelivery("a", 11), NewOffsetDelivery("a", 12)}
+	redelivered[0].Ack(ctx)
+	redelivered[1].Ack(ctx)
+	if commits := commitOffsets(redelivered, tracker.Commits(redelivered)); !reflect.DeepEqual(commits, map[string]int64{"a": 12}) {
+		t.Errorf("Commits() = %v, want a at 12 after the blocked offset is received again", commits)
+	}
+}
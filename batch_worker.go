@@ -3,7 +3,9 @@ package mq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"runtime/debug"
 	"strconv"
 	"sync"
 	"time"
@@ -11,6 +13,9 @@ import (
 
 const TimeFormat = "15:04:05.000"
 
+// DefaultBatchRetryCountName is the default attribute of the retry count of BatchWorker. Pass it to NewRetryTopics or NewDelayedRetry when they are used with BatchWorker.
+const DefaultBatchRetryCountName = "retryCount"
+
 type BatchConfig struct {
 	RetryCountName string `yaml:"retry_count_name" mapstructure:"retry_count_name" json:"retryCountName,omitempty" gorm:"column:retrycountname" bson:"retryCountName,omitempty" dynamodbav:"retryCountName,omitempty" firestore:"retryCountName,omitempty"`
 	LimitRetry     int    `yaml:"limit_retry" mapstructure:"limit_retry" json:"limitRetry,omitempty" gorm:"column:limitretry" bson:"limitRetry,omitempty" dynamodbav:"limitRetry,omitempty" firestore:"limitRetry,omitempty"`
@@ -18,23 +23,39 @@ type BatchConfig struct {
 	Key            string `yaml:"key" mapstructure:"key" json:"key,omitempty" gorm:"column:key" bson:"key,omitempty" dynamodbav:"key,omitempty" firestore:"key,omitempty"`
 	Timeout        int64  `yaml:"timeout" mapstructure:"timeout" json:"timeout,omitempty" gorm:"column:timeout" bson:"timeout,omitempty" dynamodbav:"timeout,omitempty" firestore:"timeout,omitempty"`
 	BatchSize      int    `yaml:"batch_size" mapstructure:"batch_size" json:"batchSize,omitempty" gorm:"column:batchsize" bson:"batchSize,omitempty" dynamodbav:"batchSize,omitempty" firestore:"batchSize,omitempty"`
+	MaxInFlight    int    `yaml:"max_in_flight" mapstructure:"max_in_flight" json:"maxInFlight,omitempty" gorm:"column:maxinflight" bson:"maxInFlight,omitempty" dynamodbav:"maxInFlight,omitempty" firestore:"maxInFlight,omitempty"`
+	Validation     string `yaml:"validation" mapstructure:"validation" json:"validation,omitempty" gorm:"column:validation" bson:"validation,omitempty" dynamodbav:"validation,omitempty" firestore:"validation,omitempty"`
+	ContentType    string `yaml:"content_type" mapstructure:"content_type" json:"contentType,omitempty" gorm:"column:contenttype" bson:"contentType,omitempty" dynamodbav:"contentType,omitempty" firestore:"contentType,omitempty"`
 }
 
+// BatchWorker fills the next batch while the previous batches are written. MaxInFlight is the number of batches written at the same time, Handle blocks when the limit is reached.
+// If MaxInFlight <= 0, one batch is written at a time, by the caller of Handle or by the timer.
 type BatchWorker[T any] struct {
 	batchSize          int
 	timeout            int64
 	Unmarshal          func(data []byte, v any) error
+	ContentType        string
+	Codecs             Codecs
 	handle             func(ctx context.Context, data []Message[T]) ([]Message[T], error)
 	Validate           func(context.Context, *T) ([]ErrorMessage, error)
 	Reject             func(context.Context, *T, []ErrorMessage, []byte, map[string]string)
+	ValidationMode     string
+	Quarantine         func(context.Context, []byte, map[string]string) error
 	HandleError        func(context.Context, []byte, map[string]string)
 	Retry              func(context.Context, []byte, map[string]string) error
 	LimitRetry         int
 	RetryCountName     string
 	Goroutine          bool
+	MaxInFlight        int
 	messages           []Message[T]
 	latestExecutedTime time.Time
 	mux                sync.Mutex
+	stopped            bool
+	stop               chan struct{}
+	stopOnce           sync.Once
+	wg                 sync.WaitGroup
+	slots              chan struct{}
+	slotsOnce          sync.Once
 	Key                string
 	LogError           func(context.Context, string)
 	LogInfo            func(context.Context, string)
@@ -49,7 +70,11 @@ func NewBatchWorkerByConfig[T any](
 	handleError func(context.Context, []byte, map[string]string),
 	retry func(context.Context, []byte, map[string]string) error,
 	logs ...func(context.Context, string)) *BatchWorker[T] {
-	return NewBatchWorker[T](c.BatchSize, c.Timeout, nil, handle, validate, reject, handleError, retry, c.LimitRetry, c.RetryCountName, c.Goroutines, c.Key, logs...)
+	w := NewBatchWorker[T](c.BatchSize, c.Timeout, nil, handle, validate, reject, handleError, retry, c.LimitRetry, c.RetryCountName, c.Goroutines, c.Key, logs...)
+	w.MaxInFlight = c.MaxInFlight
+	w.ValidationMode = c.Validation
+	w.ContentType = c.ContentType
+	return w
 }
 func NewBatchWorkerByConfigAndUnmarshal[T any](
 	c BatchConfig,
@@ -60,7 +85,41 @@ func NewBatchWorkerByConfigAndUnmarshal[T any](
 	handleError func(context.Context, []byte, map[string]string),
 	retry func(context.Context, []byte, map[string]string) error,
 	logs ...func(context.Context, string)) *BatchWorker[T] {
-	return NewBatchWorker[T](c.BatchSize, c.Timeout, unmarshal, handle, validate, reject, handleError, retry, c.LimitRetry, c.RetryCountName, c.Goroutines, c.Key, logs...)
+	w := NewBatchWorker[T](c.BatchSize, c.Timeout, unmarshal, handle, validate, reject, handleError, retry, c.LimitRetry, c.RetryCountName, c.Goroutines, c.Key, logs...)
+	w.MaxInFlight = c.MaxInFlight
+	w.ValidationMode = c.Validation
+	w.ContentType = c.ContentType
+	return w
+}
+
+// NewBatchWorkerByConfigE returns an error if the validation mode is invalid, see CheckValidation
+func NewBatchWorkerByConfigE[T any](
+	c BatchConfig,
+	handle func(context.Context, []Message[T]) ([]Message[T], error),
+	validate func(context.Context, *T) ([]ErrorMessage, error),
+	reject func(context.Context, *T, []ErrorMessage, []byte, map[string]string),
+	handleError func(context.Context, []byte, map[string]string),
+	retry func(context.Context, []byte, map[string]string) error,
+	quarantine func(context.Context, []byte, map[string]string) error,
+	logs ...func(context.Context, string)) (*BatchWorker[T], error) {
+	return NewBatchWorkerByConfigAndUnmarshalE[T](c, nil, handle, validate, reject, handleError, retry, quarantine, logs...)
+}
+func NewBatchWorkerByConfigAndUnmarshalE[T any](
+	c BatchConfig,
+	unmarshal func(data []byte, v any) error,
+	handle func(context.Context, []Message[T]) ([]Message[T], error),
+	validate func(context.Context, *T) ([]ErrorMessage, error),
+	reject func(context.Context, *T, []ErrorMessage, []byte, map[string]string),
+	handleError func(context.Context, []byte, map[string]string),
+	retry func(context.Context, []byte, map[string]string) error,
+	quarantine func(context.Context, []byte, map[string]string) error,
+	logs ...func(context.Context, string)) (*BatchWorker[T], error) {
+	if err := CheckValidation(c.Validation, quarantine); err != nil {
+		return nil, err
+	}
+	w := NewBatchWorkerByConfigAndUnmarshal[T](c, unmarshal, handle, validate, reject, handleError, retry, logs...)
+	w.Quarantine = quarantine
+	return w, nil
 }
 func NewBatchWorker[T any](
 	batchSize int, timeout int64,
@@ -76,7 +135,7 @@ func NewBatchWorker[T any](
 	key string,
 	logs ...func(context.Context, string)) *BatchWorker[T] {
 	if len(retryCountName) == 0 {
-		retryCountName = "retryCount"
+		retryCountName = DefaultBatchRetryCountName
 	}
 	if unmarshal == nil {
 		unmarshal = json.Unmarshal
@@ -94,6 +153,7 @@ func NewBatchWorker[T any](
 		RetryCountName: retryCountName,
 		Goroutine:      goroutine,
 		Key:            key,
+		stop:           make(chan struct{}),
 	}
 	if len(logs) > 0 {
 		w.LogError = logs[0]
@@ -107,6 +167,14 @@ func NewBatchWorker[T any](
 	return w
 }
 
+// RegisterCodec registers the codec of a content type for this batch worker only
+func (w *BatchWorker[T]) RegisterCodec(contentType string, marshal func(v any) ([]byte, error), unmarshal func(data []byte, v any) error) {
+	if w.Codecs == nil {
+		w.Codecs = make(Codecs)
+	}
+	w.Codecs.Register(contentType, marshal, unmarshal)
+}
+
 func (w *BatchWorker[T]) Handle(ctx context.Context, data []byte, attrs map[string]string) {
 	if data == nil {
 		return
@@ -120,43 +188,79 @@ func (w *BatchWorker[T]) Handle(ctx context.Context, data []byte, attrs map[stri
 		}
 	}
 	var v T
-	er1 := json.Unmarshal(data, &v)
+	er1 := w.Codecs.Unmarshal(data, &v, ResolveContentType(ctx, attrs, w.ContentType), w.Unmarshal)
 	if er1 != nil {
 		if w.LogError != nil {
 			w.LogError(ctx, fmt.Sprintf("cannot unmarshal item: %s . Error: %s", GetLog(data, attrs), er1.Error()))
 		}
+		Ack(ctx, w.LogError)
 		return
 	}
-	if w.Validate != nil {
-		errs, err := w.Validate(ctx, &v)
-		if err != nil {
-			if w.LogError != nil {
-				w.LogError(ctx, "Error when validate data: "+err.Error())
-			}
-			return
-		}
-		if len(errs) > 0 {
-			w.Reject(ctx, &v, errs, data, attrs)
-			return
-		}
+	if !Validate[T](ctx, &v, data, attrs, w.Validate, w.Reject, w.Quarantine, w.ValidationMode, w.LogError) {
+		return
 	}
 	w.mux.Lock()
-	msg := Message[T]{Data: data, Attributes: attrs, Value: v}
+	if w.stopped {
+		w.mux.Unlock()
+		if w.LogError != nil {
+			w.LogError(ctx, fmt.Sprintf("Batch worker is stopped. Cannot handle message: %s", GetLog(data, attrs)))
+		}
+		Nack(ctx, w.LogError)
+		return
+	}
+	msg := Message[T]{Data: data, Attributes: attrs, Value: v, Delivery: GetDelivery(ctx)}
 	w.messages = append(w.messages, msg)
+	var batch []Message[T]
 	if w.ready(ctx) {
-		w.execute(ctx)
+		batch = w.take()
 	}
 	w.mux.Unlock()
+	w.dispatch(ctx, batch)
+}
+
+// HandleBatch handles a batch of a batch consumer, such as kafka Reader.ReadBatch, directly without buffering.
+// It returns when every message of the batch is acked or nacked, so the consumer can commit the offsets after that. The batch worker does not need to Run.
+func (w *BatchWorker[T]) HandleBatch(ctx context.Context, batch []RawMessage) {
+	if w.LogInfo != nil {
+		w.LogInfo(ctx, fmt.Sprintf("Received batch: %d", len(batch)))
+	}
+	messages := make([]Message[T], 0, len(batch))
+	for _, m := range batch {
+		if m.Data == nil {
+			AckDelivery(ctx, m.Delivery, w.LogError)
+			continue
+		}
+		ctx2 := ctx
+		if m.Delivery != nil {
+			ctx2 = WithDelivery(ctx, m.Delivery)
+		}
+		var v T
+		er1 := w.Codecs.Unmarshal(m.Data, &v, ResolveContentType(ctx2, m.Attributes, w.ContentType), w.Unmarshal)
+		if er1 != nil {
+			if w.LogError != nil {
+				w.LogError(ctx2, fmt.Sprintf("cannot unmarshal item: %s . Error: %s", GetLog(m.Data, m.Attributes), er1.Error()))
+			}
+			Ack(ctx2, w.LogError)
+			continue
+		}
+		if !Validate[T](ctx2, &v, m.Data, m.Attributes, w.Validate, w.Reject, w.Quarantine, w.ValidationMode, w.LogError) {
+			continue
+		}
+		messages = append(messages, Message[T]{Data: m.Data, Attributes: m.Attributes, Value: v, Delivery: m.Delivery})
+	}
+	w.execute(ctx, messages)
 }
 func (w *BatchWorker[T]) CallByTimer(ctx context.Context) {
 	w.mux.Lock()
 	if w.LogDebug != nil {
 		w.LogDebug(ctx, "Call by timer")
 	}
+	var batch []Message[T]
 	if w.ready(ctx) {
-		w.execute(ctx)
+		batch = w.take()
 	}
 	w.mux.Unlock()
+	w.dispatch(ctx, batch)
 }
 func (w *BatchWorker[T]) ready(ctx context.Context) bool {
 	isReady := false
@@ -175,25 +279,141 @@ func (w *BatchWorker[T]) ready(ctx context.Context) bool {
 	return isReady
 }
 
-func (w *BatchWorker[T]) execute(ctx context.Context) {
-	lenMessages := len(w.messages)
-	if lenMessages == 0 {
-		w.reset(ctx)
+// take swaps the filled buffer with a new one, so that the next batch can be filled while this batch is written
+func (w *BatchWorker[T]) take() []Message[T] {
+	batch := w.messages
+	w.messages = make([]Message[T], 0, len(batch))
+	w.latestExecutedTime = time.Now()
+	return batch
+}
+
+// dispatch waits for a free slot, which is the backpressure to the consumer, then writes the batch.
+// If there is no free slot and the consumer is in ctx (see GetPauser), the consumer is paused while waiting.
+func (w *BatchWorker[T]) dispatch(ctx context.Context, batch []Message[T]) {
+	if len(batch) == 0 {
 		return
 	}
+	slots := w.getSlots()
+	select {
+	case slots <- struct{}{}:
+	default:
+		pauser := GetPauser(ctx)
+		if pauser != nil {
+			w.pause(ctx, pauser)
+			slots <- struct{}{}
+			w.resume(ctx, pauser)
+		} else {
+			slots <- struct{}{}
+		}
+	}
+	if w.MaxInFlight <= 0 {
+		defer func() { <-slots }()
+		w.execute(ctx, batch)
+		return
+	}
+	go func() {
+		defer func() { <-slots }()
+		w.execute(ctx, batch)
+	}()
+}
+func (w *BatchWorker[T]) pause(ctx context.Context, pauser Pauser) {
+	if err := pauser.Pause(); err != nil && w.LogError != nil {
+		w.LogError(ctx, "Error when pause: "+err.Error())
+	}
+}
+func (w *BatchWorker[T]) resume(ctx context.Context, pauser Pauser) {
+	if err := pauser.Resume(); err != nil && w.LogError != nil {
+		w.LogError(ctx, "Error when resume: "+err.Error())
+	}
+}
+func (w *BatchWorker[T]) getSlots() chan struct{} {
+	w.slotsOnce.Do(func() {
+		n := w.MaxInFlight
+		if n <= 0 {
+			n = 1
+		}
+		w.slots = make(chan struct{}, n)
+	})
+	return w.slots
+}
+
+// waitInFlight waits until all batches in flight are written, or ctx is done
+func (w *BatchWorker[T]) waitInFlight(ctx context.Context) {
+	slots := w.getSlots()
+	n := cap(slots)
+	acquired := 0
+	defer func() {
+		for i := 0; i < acquired; i++ {
+			<-slots
+		}
+	}()
+	for acquired < n {
+		select {
+		case slots <- struct{}{}:
+			acquired++
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-	errList, err := w.handle(ctx, w.messages)
+// flush writes the pending messages. If ctx is done before a slot is free, the pending messages are nacked to be redelivered.
+func (w *BatchWorker[T]) flush(ctx context.Context, stop bool) {
+	w.mux.Lock()
+	if stop {
+		w.stopped = true
+	}
+	batch := w.take()
+	w.mux.Unlock()
+	if len(batch) > 0 {
+		slots := w.getSlots()
+		select {
+		case slots <- struct{}{}:
+			w.execute(ctx, batch)
+			<-slots
+		case <-ctx.Done():
+			for i := range batch {
+				NackDelivery(ctx, batch[i].Delivery, w.LogError)
+			}
+		}
+	}
+	w.waitInFlight(ctx)
+}
+
+func (w *BatchWorker[T]) execute(ctx context.Context, messages []Message[T]) {
+	if len(messages) == 0 {
+		return
+	}
+	defer func() {
+		// the messages can be acked already, so they are not nacked, and the broker redelivers the others after the timeout
+		if r := recover(); r != nil {
+			logPanic(ctx, r, debug.Stack(), "", w.LogError)
+		}
+	}()
+	var errList []Message[T]
+	err := Safe(func() (er1 error) {
+		errList, er1 = w.handle(ctx, messages)
+		return er1
+	})
 
 	if err != nil && w.LogError != nil {
 		w.LogError(ctx, "Error of batch handling: "+err.Error())
 	}
+	if err != nil && len(errList) == 0 {
+		for i := range messages {
+			NackDelivery(ctx, messages[i].Delivery, w.LogError)
+		}
+		return
+	}
+	AckMessages(ctx, messages, errList, w.LogError)
 	if len(errList) > 0 {
 		if w.Retry == nil {
-			if w.LogError != nil {
-				l := len(errList)
-				for i := 0; i < l; i++ {
+			l := len(errList)
+			for i := 0; i < l; i++ {
+				if w.LogError != nil {
 					w.LogError(ctx, fmt.Sprintf("Error message: %s.", GetLog(errList[i].Data, errList[i].Attributes)))
 				}
+				NackDelivery(ctx, errList[i].Delivery, w.LogError)
 			}
 		} else {
 			l := len(errList)
@@ -201,9 +421,10 @@ func (w *BatchWorker[T]) execute(ctx context.Context) {
 				retryCount := 0
 				if errList[i].Attributes == nil {
 					errList[i].Attributes = make(map[string]string)
-				} else {
+				} else if count, ok := errList[i].Attributes[w.RetryCountName]; ok {
+					// the message of the first failure has no retry count, and an invalid retry count is counted as 1
 					var er4 error
-					retryCount, er4 = strconv.Atoi(errList[i].Attributes[w.RetryCountName])
+					retryCount, er4 = strconv.Atoi(count)
 					if er4 != nil {
 						retryCount = 1
 					}
@@ -213,22 +434,36 @@ func (w *BatchWorker[T]) execute(ctx context.Context) {
 					if w.LogInfo != nil {
 						w.LogInfo(ctx, fmt.Sprintf("Retry: %d . Retry limitation: %d . Message: %s.", retryCount-1, w.LimitRetry, GetLog(errList[i].Data, errList[i].Attributes)))
 					}
-					if w.HandleError != nil {
+					if w.HandleError != nil && Handled(WithFailure(ctx, err), func(ctx context.Context) {
 						w.HandleError(ctx, errList[i].Data, errList[i].Attributes)
+					}) {
+						AckDelivery(ctx, errList[i].Delivery, w.LogError)
+					} else {
+						NackDelivery(ctx, errList[i].Delivery, w.LogError)
 					}
 					continue
 				} else if w.LogInfo != nil {
 					w.LogInfo(ctx, fmt.Sprintf("Retry: %d . Message: %s", retryCount-1, GetLog(errList[i].Data, errList[i].Attributes)))
 				}
 				errList[i].Attributes[w.RetryCountName] = strconv.Itoa(retryCount)
-				er3 := w.Retry(ctx, errList[i].Data, errList[i].Attributes)
-				if er3 != nil && w.LogError != nil {
-					w.LogError(ctx, fmt.Sprintf("Cannot retry %s . Error: %s", GetLog(errList[i].Data, errList[i].Attributes), er3.Error()))
+				if len(errList[i].Attributes[DLQFirstFailureName]) == 0 {
+					errList[i].Attributes[DLQFirstFailureName] = time.Now().UTC().Format(time.RFC3339Nano)
+				}
+				er3 := w.Retry(WithDelivery(ctx, errList[i].Delivery), errList[i].Data, errList[i].Attributes)
+				if errors.Is(er3, ErrSettled) {
+					continue
+				}
+				if er3 != nil {
+					if w.LogError != nil {
+						w.LogError(ctx, fmt.Sprintf("Cannot retry %s . Error: %s", GetLog(errList[i].Data, errList[i].Attributes), er3.Error()))
+					}
+					NackDelivery(ctx, errList[i].Delivery, w.LogError)
+				} else {
+					AckDelivery(ctx, errList[i].Delivery, w.LogError)
 				}
 			}
 		}
 	}
-	w.reset(ctx)
 }
 
 func (w *BatchWorker[T]) reset(ctx context.Context) {
@@ -239,12 +474,54 @@ func (w *BatchWorker[T]) reset(ctx context.Context) {
 func (w *BatchWorker[T]) Run(ctx context.Context) {
 	w.reset(ctx)
 	ticker := time.NewTicker(time.Duration(w.timeout) * time.Millisecond)
+	w.wg.Add(1)
 	go func() {
+		defer w.wg.Done()
+		defer ticker.Stop()
 		for {
 			select {
+			case <-ctx.Done():
+				return
+			case <-w.stop:
+				return
 			case <-ticker.C:
 				w.CallByTimer(ctx)
 			}
 		}
 	}()
 }
+
+// Drain flushes the pending messages through handle and waits for the batches in flight, including the retry and error handling, and waits until it is done or ctx is done
+func (w *BatchWorker[T]) Drain(ctx context.Context) error {
+	return w.wait(ctx, func() {
+		w.flush(ctx, false)
+	})
+}
+
+// Stop stops the timer and stops accepting messages, then flushes the pending messages and waits for the in-flight execution.
+// It returns ctx.Err() if ctx is done before that. The messages received after Stop are nacked, to be redelivered.
+func (w *BatchWorker[T]) Stop(ctx context.Context) error {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	return w.wait(ctx, func() {
+		w.wg.Wait()
+		w.flush(ctx, true)
+	})
+}
+
+// wait runs f, which stops at ctx done, and does not return before f returns, so nothing is written after Drain or Stop returns
+func (w *BatchWorker[T]) wait(ctx context.Context, f func()) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		<-done
+		return ctx.Err()
+	}
+}
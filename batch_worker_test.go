@@ -0,0 +1,39 @@
+package mq
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// BenchmarkBatchWorker compares writing one batch at a time (MaxInFlight 0, the behavior before batches in flight) with writing several batches at the same time, when the write of a batch takes 1ms.
+func BenchmarkBatchWorker(b *testing.B) {
+	for _, maxInFlight := range []int{0, 2, 4, 8} {
+		b.Run("MaxInFlight"+strconv.Itoa(maxInFlight), func(b *testing.B) {
+			benchmarkBatchWorker(b, maxInFlight, time.Millisecond)
+		})
+	}
+}
+func benchmarkBatchWorker(b *testing.B, maxInFlight int, latency time.Duration) {
+	handle := func(ctx context.Context, messages []Message[int]) ([]Message[int], error) {
+		time.Sleep(latency)
+		return nil, nil
+	}
+	w := NewBatchWorker[int](100, 1000, nil, handle, nil, nil, nil, nil, 0, "", false, "")
+	w.MaxInFlight = maxInFlight
+	ctx := context.Background()
+	w.Run(ctx)
+	data := []byte("1")
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		w.Handle(ctx, data, nil)
+	}
+	if err := w.Stop(ctx); err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
@@ -0,0 +1,71 @@
+package mq
+
+import (
+	"context"
+	"sync"
+)
+
+// Canceler is used by the consumers to stop the consumer loops in Close. The zero value is ready to use.
+// Each loop runs with the context of WithCancel, and calls done when it returns. Cancel cancels the contexts, and waits until all loops return,
+// so that the connection can be closed safely after that. Cancel must not be called by the handle callback of the consumer, because it waits for the loop of that callback.
+type Canceler struct {
+	mu      sync.Mutex
+	closed  bool
+	next    int
+	cancels map[int]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func (c *Canceler) WithCancel(ctx context.Context) (context.Context, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctx2, cancel := context.WithCancel(ctx)
+	if c.closed {
+		cancel()
+		return ctx2, cancel
+	}
+	if c.cancels == nil {
+		c.cancels = make(map[int]context.CancelFunc)
+	}
+	id := c.next
+	c.next++
+	c.cancels[id] = cancel
+	c.wg.Add(1)
+	var once sync.Once
+	return ctx2, func() {
+		once.Do(func() {
+			cancel()
+			c.mu.Lock()
+			delete(c.cancels, id)
+			c.mu.Unlock()
+			c.wg.Done()
+		})
+	}
+}
+
+// Cancel stops the running loops and the loops which start later
+func (c *Canceler) Cancel() {
+	c.mu.Lock()
+	c.closed = true
+	for _, cancel := range c.cancels {
+		cancel()
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+}
+func (c *Canceler) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Receive returns the next message of ch, or false if ctx is done or ch is closed
+func Receive[M any](ctx context.Context, ch <-chan M) (M, bool) {
+	select {
+	case <-ctx.Done():
+		var m M
+		return m, false
+	case m, ok := <-ch:
+		return m, ok
+	}
+}
@@ -0,0 +1,295 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is returned by the functions of an open circuit breaker. It is not retryable: Handler and RetryHandler pause the consumer until the breaker allows a call (see WaitCircuit),
+// instead of retrying the message or passing it to HandleError.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// openError is ErrCircuitOpen, with the breaker which returns it, so that the handlers can wait for the breaker
+type openError struct {
+	breaker *CircuitBreaker
+}
+
+func (e *openError) Error() string {
+	return ErrCircuitOpen.Error()
+}
+func (e *openError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitBreaker stops calling the downstream, such as Write or a publisher, after FailureThreshold consecutive failures.
+// When it is open, the calls fail with ErrCircuitOpen. After OpenTimeout, it is half-open: one call is allowed, which closes the breaker if it succeeds, or opens it again if it fails.
+// IsFailure decides the errors which count as failures. If it is nil, the permanent errors do not count, because they are the errors of the messages, not of the downstream.
+// Handle pauses the consumer while the breaker is open. CircuitBreaker is a health checker, which reports the state of the breaker.
+type CircuitBreaker struct {
+	Service          string
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	IsFailure        func(error) bool
+	LogError         func(context.Context, string)
+	LogInfo          func(context.Context, string)
+	mu               sync.Mutex
+	state            string
+	failures         int
+	openedAt         time.Time
+	trial            bool
+	changed          chan struct{}
+}
+
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration, logs ...func(context.Context, string)) *CircuitBreaker {
+	return NewCircuitBreakerWithName("circuit_breaker", failureThreshold, openTimeout, logs...)
+}
+func NewCircuitBreakerWithName(name string, failureThreshold int, openTimeout time.Duration, logs ...func(context.Context, string)) *CircuitBreaker {
+	if failureThreshold <= 0 {
+		failureThreshold = 5
+	}
+	if openTimeout <= 0 {
+		openTimeout = 30 * time.Second
+	}
+	b := &CircuitBreaker{Service: name, FailureThreshold: failureThreshold, OpenTimeout: openTimeout, state: CircuitClosed, changed: make(chan struct{})}
+	if len(logs) >= 1 {
+		b.LogError = logs[0]
+	}
+	if len(logs) >= 2 {
+		b.LogInfo = logs[1]
+	}
+	return b
+}
+
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.getState()
+}
+
+// Allow returns nil if a call is allowed, or ErrCircuitOpen. If it returns nil, the result of the call must be passed to Done.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.getState() {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.OpenTimeout {
+			return &openError{breaker: b}
+		}
+		b.setState(CircuitHalfOpen)
+		b.trial = true
+		return nil
+	case CircuitHalfOpen:
+		if b.trial {
+			return &openError{breaker: b}
+		}
+		b.trial = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Done records the result of an allowed call
+func (b *CircuitBreaker) Done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	isFailure := b.IsFailure
+	if isFailure == nil {
+		isFailure = IsRetryable
+	}
+	failed := isFailure(err)
+	switch b.getState() {
+	case CircuitHalfOpen:
+		b.trial = false
+		if failed {
+			b.open()
+		} else {
+			b.failures = 0
+			b.setState(CircuitClosed)
+		}
+	case CircuitClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+// Execute calls f if the breaker allows it, and records the result
+func (b *CircuitBreaker) Execute(f func() error) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+	err := Safe(f)
+	b.Done(err)
+	return err
+}
+
+// Write wraps a function such as Write of Handler and RetryHandler, or a publisher of DLQ and Retry
+func (b *CircuitBreaker) Write(write func(context.Context, []byte, map[string]string) error) func(context.Context, []byte, map[string]string) error {
+	return func(ctx context.Context, data []byte, attrs map[string]string) error {
+		return b.Execute(func() error {
+			return write(ctx, data, attrs)
+		})
+	}
+}
+
+// Wait waits until the breaker allows a call, or ctx is done
+func (b *CircuitBreaker) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		var wait time.Duration
+		switch b.getState() {
+		case CircuitOpen:
+			if wait = b.OpenTimeout - time.Since(b.openedAt); wait < 0 {
+				wait = 0
+			}
+		case CircuitHalfOpen:
+			if b.trial {
+				wait = -1
+			}
+		}
+		changed := b.changed
+		b.mu.Unlock()
+		if wait == 0 || (wait < 0 && changed == nil) {
+			return ctx.Err()
+		}
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		case <-expired:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// Handle wraps the handle callback of a consumer, to pause the consumer while the breaker is open, instead of failing the messages.
+// If the consumer is in ctx (see GetPauser), it is paused until the breaker allows a call; the other consumers stop fetching while the handle callback waits.
+// If ctx is done while waiting, the message is nacked.
+func (b *CircuitBreaker) Handle(handle func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string) {
+	return func(ctx context.Context, data []byte, attrs map[string]string) {
+		if err := b.pause(ctx); err != nil {
+			Nack(ctx, b.LogError)
+			return
+		}
+		handle(ctx, data, attrs)
+	}
+}
+
+// pause pauses the consumer in ctx (see GetPauser) until the breaker allows a call, and returns ctx.Err() if ctx is done before that
+func (b *CircuitBreaker) pause(ctx context.Context) error {
+	var pauser Pauser
+	if b.State() != CircuitClosed {
+		if pauser = GetPauser(ctx); pauser != nil {
+			if err := pauser.Pause(); err != nil && b.LogError != nil {
+				b.LogError(ctx, "Error when pause: "+err.Error())
+			}
+		}
+	}
+	err := b.Wait(ctx)
+	if pauser != nil {
+		if er2 := pauser.Resume(); er2 != nil && b.LogError != nil {
+			b.LogError(ctx, "Error when resume: "+er2.Error())
+		}
+	}
+	return err
+}
+
+// WaitCircuit calls write, and while write fails with ErrCircuitOpen of a CircuitBreaker, pauses the consumer in ctx until the breaker allows a call, then calls write again.
+// It returns the error of write, which is still ErrCircuitOpen if ctx is done while waiting, or if the error is not returned by a CircuitBreaker.
+func WaitCircuit(ctx context.Context, write func() error) error {
+	err := write()
+	for {
+		var open *openError
+		if !errors.As(err, &open) || open.breaker == nil {
+			return err
+		}
+		if er1 := open.breaker.pause(ctx); er1 != nil {
+			return err
+		}
+		err = write()
+	}
+}
+
+func (b *CircuitBreaker) Name() string {
+	return b.Service
+}
+
+// Check returns the state and the consecutive failures, with ErrCircuitOpen if the breaker is open or half-open
+func (b *CircuitBreaker) Check(ctx context.Context) (map[string]interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.getState()
+	res := map[string]interface{}{"state": state, "failures": b.failures}
+	if state != CircuitClosed {
+		res["opened_at"] = b.openedAt
+		return res, ErrCircuitOpen
+	}
+	return res, nil
+}
+func (b *CircuitBreaker) Build(ctx context.Context, data map[string]interface{}, err error) map[string]interface{} {
+	if err == nil {
+		return data
+	}
+	if data == nil {
+		data = make(map[string]interface{}, 0)
+	}
+	data["error"] = err.Error()
+	return data
+}
+
+// getState returns the state, and the zero value of CircuitBreaker is closed
+func (b *CircuitBreaker) getState() string {
+	if len(b.state) == 0 {
+		return CircuitClosed
+	}
+	return b.state
+}
+func (b *CircuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.setState(CircuitOpen)
+}
+func (b *CircuitBreaker) setState(state string) {
+	previous := b.getState()
+	b.state = state
+	if b.changed != nil {
+		close(b.changed)
+	}
+	b.changed = make(chan struct{})
+	if previous != state && b.LogInfo != nil {
+		b.LogInfo(context.Background(), fmt.Sprintf("Circuit breaker %s: %s -> %s", b.Service, previous, state))
+	}
+}
+
+// CircuitWrite wraps a write function such as func(context.Context, *T) error, so it is called by the breaker
+func CircuitWrite[T any](b *CircuitBreaker, write func(context.Context, T) error) func(context.Context, T) error {
+	return func(ctx context.Context, item T) error {
+		return b.Execute(func() error {
+			return write(ctx, item)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testPauser struct {
+	pauses  int
+	resumes int
+	gate    Gate
+}
+
+func (p *testPauser) Pause() error {
+	p.pauses++
+	return p.gate.Pause()
+}
+func (p *testPauser) Resume() error {
+	p.resumes++
+	return p.gate.Resume()
+}
+func (p *testPauser) Paused() bool {
+	return p.gate.Paused()
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	failure := errors.New("database is down")
+	b := NewCircuitBreaker(2, 30*time.Millisecond)
+	fail := func() error { return failure }
+	succeed := func() error { return nil }
+	steps := []struct {
+		name  string
+		f     func() error
+		sleep time.Duration
+		err   error
+		state string
+	}{
+		{name: "first failure", f: fail, err: failure, state: CircuitClosed},
+		{name: "success resets the failures", f: succeed, state: CircuitClosed},
+		{name: "failure after success", f: fail, err: failure, state: CircuitClosed},
+		{name: "permanent error resets the failures", f: func() error { return Permanent(failure) }, err: failure, state: CircuitClosed},
+		{name: "failure after permanent error", f: fail, err: failure, state: CircuitClosed},
+		{name: "threshold opens", f: fail, err: failure, state: CircuitOpen},
+		{name: "open rejects", f: succeed, err: ErrCircuitOpen, state: CircuitOpen},
+		{name: "half-open trial fails", f: fail, sleep: 40 * time.Millisecond, err: failure, state: CircuitOpen},
+		{name: "open again rejects", f: succeed, err: ErrCircuitOpen, state: CircuitOpen},
+		{name: "half-open trial succeeds", f: succeed, sleep: 40 * time.Millisecond, state: CircuitClosed},
+	}
+	for _, s := range steps {
+		time.Sleep(s.sleep)
+		called := false
+		err := b.Execute(func() error {
+			called = true
+			return s.f()
+		})
+		if s.err == nil && err != nil || s.err != nil && !errors.Is(err, s.err) {
+			t.Fatalf("%s: err = %v, want %v", s.name, err, s.err)
+		}
+		if called == errors.Is(s.err, ErrCircuitOpen) {
+			t.Fatalf("%s: called = %v", s.name, called)
+		}
+		if state := b.State(); state != s.state {
+			t.Fatalf("%s: state = %s, want %s", s.name, state, s.state)
+		}
+	}
+}
+
+// TestCircuitBreakerHalfOpen checks that only one trial call is allowed while the breaker is half-open
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	b := NewCircuitBreaker(1, 10*time.Millisecond)
+	b.Execute(func() error { return errors.New("failure") })
+	time.Sleep(20 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() = %v, want the trial call", err)
+	}
+	if state := b.State(); state != CircuitHalfOpen {
+		t.Errorf("state = %s, want %s", state, CircuitHalfOpen)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Allow() = %v, want %v during the trial call", err, ErrCircuitOpen)
+	}
+	if _, err := b.Check(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Check() = %v, want %v", err, ErrCircuitOpen)
+	}
+	b.Done(nil)
+	if _, err := b.Check(context.Background()); err != nil {
+		t.Errorf("Check() = %v, want nil after the trial call succeeds", err)
+	}
+}
+
+func TestWaitCircuit(t *testing.T) {
+	b := NewCircuitBreaker(1, 30*time.Millisecond)
+	b.Execute(func() error { return errors.New("failure") })
+	p := &testPauser{}
+	calls := 0
+	start := time.Now()
+	err := WaitCircuit(WithPauser(context.Background(), p), func() error {
+		return b.Execute(func() error {
+			calls++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("WaitCircuit() = %v, want nil", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("WaitCircuit returns after %s, want after the open timeout", elapsed)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1 after the breaker allows it", calls)
+	}
+	if p.pauses != 1 || p.resumes != 1 || p.Paused() {
+		t.Errorf("pauses = %d, resumes = %d, paused = %v, want the consumer paused and resumed once", p.pauses, p.resumes, p.Paused())
+	}
+	if state := b.State(); state != CircuitClosed {
+		t.Errorf("state = %s, want %s", state, CircuitClosed)
+	}
+}
+
+func TestWaitCircuitContextDone(t *testing.T) {
+	b := NewCircuitBreaker(1, time.Hour)
+	b.Execute(func() error { return errors.New("failure") })
+	p := &testPauser{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := WaitCircuit(WithPauser(ctx, p), func() error {
+		return b.Execute(func() error { return nil })
+	})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("WaitCircuit() = %v, want %v when ctx is done", err, ErrCircuitOpen)
+	}
+	if p.pauses != 1 || p.resumes != 1 || p.Paused() {
+		t.Errorf("pauses = %d, resumes = %d, paused = %v, want the consumer resumed when ctx is done", p.pauses, p.resumes, p.Paused())
+	}
+	if err = WaitCircuit(ctx, func() error { return errors.New("other") }); errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("WaitCircuit() = %v, want the error of write", err)
+	}
+}
+
+// TestCircuitBreakerHandle checks that the message is nacked and not handled, when ctx is done while the breaker is open
+func TestCircuitBreakerHandle(t *testing.T) {
+	b := NewCircuitBreaker(1, time.Hour)
+	b.Execute(func() error { return errors.New("failure") })
+	handled := 0
+	handle := b.Handle(func(ctx context.Context, data []byte, attrs map[string]string) {
+		handled++
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	d := &testDelivery{}
+	handle(WithDelivery(ctx, d), []byte("1"), nil)
+	if handled != 0 || d.nacks != 1 {
+		t.Errorf("handled = %d, nacks = %d, want 0, 1", handled, d.nacks)
+	}
+}
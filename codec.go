@@ -0,0 +1,106 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+const (
+	ContentTypeName     = "content-type"
+	ContentTypeJson     = "application/json"
+	ContentTypeAvro     = "application/avro"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeMsgpack  = "application/msgpack"
+)
+
+type Codec struct {
+	Marshal   func(v any) ([]byte, error)
+	Unmarshal func(data []byte, v any) error
+}
+
+var (
+	codecs   = map[string]Codec{ContentTypeJson: {Marshal: json.Marshal, Unmarshal: json.Unmarshal}}
+	codecMux sync.RWMutex
+)
+
+// RegisterCodec registers the marshal/unmarshal functions for a content type, such as avro.Marshaller or protobuf.Marshaller
+func RegisterCodec(contentType string, marshal func(v any) ([]byte, error), unmarshal func(data []byte, v any) error) {
+	codecMux.Lock()
+	codecs[normalizeContentType(contentType)] = Codec{Marshal: marshal, Unmarshal: unmarshal}
+	codecMux.Unlock()
+}
+func GetCodec(contentType string) (Codec, bool) {
+	codecMux.RLock()
+	codec, ok := codecs[normalizeContentType(contentType)]
+	codecMux.RUnlock()
+	return codec, ok
+}
+func GetContentType(attrs map[string]string) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	if v, ok := attrs[ContentTypeName]; ok {
+		return v
+	}
+	for k, v := range attrs {
+		if strings.EqualFold(k, ContentTypeName) {
+			return v
+		}
+	}
+	return ""
+}
+
+// ResolveContentType returns the content-type attribute, or the content-type value of ctx, or contentType, which is the default content type of the handler
+func ResolveContentType(ctx context.Context, attrs map[string]string, contentType string) string {
+	if v := GetContentType(attrs); len(v) > 0 {
+		return v
+	}
+	if ctx != nil {
+		if v := GetString(ctx, ContentTypeName); len(v) > 0 {
+			return v
+		}
+	}
+	return contentType
+}
+
+// UnmarshalByContentType picks the codec from the content-type attribute, and uses unmarshal if there is no content-type or no codec registered for it
+func UnmarshalByContentType(data []byte, v any, attrs map[string]string, unmarshal func(data []byte, v any) error) error {
+	return Codecs(nil).Unmarshal(data, v, GetContentType(attrs), unmarshal)
+}
+
+// Codecs are the codecs of a handler, so that each topic can have its own avro or protobuf schema. The codecs registered by RegisterCodec are used for the content types which are not in Codecs.
+type Codecs map[string]Codec
+
+func (c Codecs) Register(contentType string, marshal func(v any) ([]byte, error), unmarshal func(data []byte, v any) error) {
+	c[normalizeContentType(contentType)] = Codec{Marshal: marshal, Unmarshal: unmarshal}
+}
+func (c Codecs) Get(contentType string) (Codec, bool) {
+	if codec, ok := c[normalizeContentType(contentType)]; ok {
+		return codec, ok
+	}
+	return GetCodec(contentType)
+}
+
+// Unmarshal uses the codec of contentType, and uses unmarshal if there is no content type or no codec for it
+func (c Codecs) Unmarshal(data []byte, v any, contentType string, unmarshal func(data []byte, v any) error) error {
+	if len(contentType) > 0 {
+		codec, ok := c.Get(contentType)
+		if ok && codec.Unmarshal != nil {
+			return codec.Unmarshal(data, v)
+		}
+	}
+	if unmarshal == nil {
+		return json.Unmarshal(data, v)
+	}
+	return unmarshal(data, v)
+}
+
+func normalizeContentType(contentType string) string {
+	i := strings.Index(contentType, ";")
+	if i >= 0 {
+		contentType = contentType[0:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
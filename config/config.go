@@ -2,11 +2,12 @@ package config
 
 import (
 	"fmt"
+	"github.com/core-go/mq"
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 	"log"
 	"os"
 	"reflect"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -111,10 +112,17 @@ func LoadConfigWithEnv(parentPath string, directory string, env string, c interf
 	if er3 != nil {
 		return er3
 	}
-	er4 := viper.Unmarshal(c)
+	er4 := viper.Unmarshal(c, viper.DecodeHook(DecodeHook))
 	return er4
 }
 
+// DecodeHook keeps the default hooks of viper (string to time.Duration, comma separated string to slice), and decodes mq.RetryConfig from a list such as [500ms, 2s, 10s]
+var DecodeHook = mapstructure.ComposeDecodeHookFunc(
+	mapstructure.StringToTimeDurationHookFunc(),
+	mapstructure.StringToSliceHookFunc(","),
+	mq.RetryConfigHook,
+)
+
 // BindEnvs function will bind ymal file to struc model
 func BindEnvs(conf interface{}, parts ...string) error {
 	ifv := reflect.Indirect(reflect.ValueOf(conf))
@@ -297,32 +305,16 @@ func fileExists(filename string) bool {
 }
 
 func MakeDurations(vs []int64) []time.Duration {
-	durations := make([]time.Duration, 0)
-	for _, v := range vs {
-		d := time.Duration(v) * time.Second
-		durations = append(durations, d)
-	}
-	return durations
+	return mq.MakeDurations(vs)
 }
 func MakeArray(v interface{}, prefix string, max int) []int64 {
-	var ar []int64
-	v2 := reflect.Indirect(reflect.ValueOf(v))
-	for i := 1; i <= max; i++ {
-		fn := prefix + strconv.Itoa(i)
-		v3 := v2.FieldByName(fn).Interface().(int64)
-		if v3 > 0 {
-			ar = append(ar, v3)
-		} else {
-			return ar
-		}
-	}
-	return ar
+	return mq.MakeArray(v, prefix, max)
 }
 func DurationsFromValue(v interface{}, prefix string, max int) []time.Duration {
-	arr := MakeArray(v, prefix, max)
-	return MakeDurations(arr)
+	return mq.DurationsFromValue(v, prefix, max)
 }
 
+// Retry is kept for the configs with more than 20 retries. mq.RetryConfig also accepts a list of durations or a policy block.
 type Retry struct {
 	Retry1  int64 `yaml:"1" mapstructure:"1" json:"retry1,omitempty" gorm:"column:retry1" bson:"retry1,omitempty" dynamodbav:"retry1,omitempty" firestore:"retry1,omitempty"`
 	Retry2  int64 `yaml:"2" mapstructure:"2" json:"retry2,omitempty" gorm:"column:retry2" bson:"retry2,omitempty" dynamodbav:"retry2,omitempty" firestore:"retry2,omitempty"`
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/core-go/mq"
+	"github.com/spf13/viper"
+)
+
+// TestDecodeHook reads the retry config from yaml by viper, the same as Load
+func TestDecodeHook(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		durations []time.Duration
+		err       bool
+	}{
+		{name: "list", yaml: "retry: [500ms, 2s, 10]", durations: []time.Duration{500 * time.Millisecond, 2 * time.Second, 10 * time.Second}},
+		{name: "comma separated", yaml: "retry: 500ms, 2s", durations: []time.Duration{500 * time.Millisecond, 2 * time.Second}},
+		{name: "policy", yaml: "retry:\n  initial: 1s\n  multiplier: 2\n  max_attempts: 3", durations: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+		{name: "legacy", yaml: "retry:\n  1: 1\n  2: 5", durations: []time.Duration{time.Second, 5 * time.Second}},
+		{name: "invalid", yaml: "retry: [500ms, two seconds]", err: true},
+		{name: "policy without initial", yaml: "retry:\n  multiplier: 2", err: true},
+		{name: "timeout", yaml: "timeout: 3s"},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			if err := v.ReadConfig(strings.NewReader(c.yaml)); err != nil {
+				t.Fatal(err)
+			}
+			var conf struct {
+				Retry   mq.RetryConfig `mapstructure:"retry"`
+				Timeout time.Duration  `mapstructure:"timeout"`
+			}
+			err := v.Unmarshal(&conf, viper.DecodeHook(DecodeHook))
+			var durations []time.Duration
+			if err == nil {
+				durations, err = conf.Retry.Durations()
+			}
+			if c.err {
+				if err == nil {
+					t.Errorf("err = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if len(durations) != len(c.durations) || len(durations) > 0 && !reflect.DeepEqual(durations, c.durations) {
+				t.Errorf("Durations() = %v, want %v", durations, c.durations)
+			}
+			if c.name == "timeout" && conf.Timeout != 3*time.Second {
+				t.Errorf("Timeout = %s, want 3s", conf.Timeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/core-go/mq"
+)
+
+// ConsumeBatch reads the messages until ctx is done, Close is called or the consumer returns an error, and passes them to handle in batches, such as BatchWorker.HandleBatch.
+// A batch has up to batchSize messages, and is passed to handle when it is full, or after timeout since its first message is polled.
+// After handle returns, the highest contiguous acked offset of each partition is committed, so the offsets are never committed before the messages are processed.
+// Kafka does not redeliver a message in a live session, so when a message is not acked, the error is logged and ConsumeBatch returns,
+// and the messages after the last committed offset are received again when the consumer restarts.
+// If AckOnConsume is true, the offsets are committed by the auto commit of the consumer.
+func (c *Consumer) ConsumeBatch(ctx context.Context, batchSize int, timeout time.Duration, handle func(context.Context, []mq.RawMessage)) {
+	handle = mq.Recover(handle, c.LogError)
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	defer c.close()
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	ctx = mq.WithPauser(ctx, c)
+
+	err := c.Consumer.SubscribeTopics(c.Topics, nil)
+	if err != nil {
+		if c.LogError != nil {
+			c.LogError(ctx, fmt.Sprintf("Consume Topic err: %v", err))
+		}
+		return
+	}
+	msgs := make([]*kafka.Message, 0, batchSize)
+	var deadline time.Time
+	run := true
+	for run == true && ctx2.Err() == nil {
+		ev := c.Consumer.Poll(pollTimeout)
+		switch e := ev.(type) {
+		case *kafka.Message:
+			if len(msgs) == 0 {
+				deadline = time.Now().Add(timeout)
+			}
+			msgs = append(msgs, e)
+		case kafka.PartitionEOF:
+			if c.LogInfo != nil {
+				c.LogInfo(ctx, fmt.Sprintf("Reached %v", e))
+			}
+		case kafka.Error:
+			if c.LogError != nil {
+				c.LogError(ctx, fmt.Sprintf("Error: %v", e))
+			}
+			run = false
+		default:
+		}
+		if len(msgs) > 0 && (len(msgs) >= batchSize || !time.Now().Before(deadline)) {
+			if !c.handleBatch(ctx, msgs, handle) {
+				return
+			}
+			msgs = make([]*kafka.Message, 0, batchSize)
+		}
+	}
+	if len(msgs) > 0 {
+		c.handleBatch(ctx, msgs, handle)
+	}
+}
+
+// handleBatch handles the batch, and commits the acked offsets. It returns false if a message is not acked, so the consuming loop should stop.
+func (c *Consumer) handleBatch(ctx context.Context, msgs []*kafka.Message, handle func(context.Context, []mq.RawMessage)) bool {
+	if c.LogInfo != nil {
+		c.LogInfo(ctx, fmt.Sprintf("Batch of %d messages", len(msgs)))
+	}
+	batch := make([]mq.RawMessage, len(msgs))
+	deliveries := make([]*mq.OffsetDelivery, len(msgs))
+	for i, msg := range msgs {
+		partition := Partition(msg)
+		offset := int64(msg.TopicPartition.Offset)
+		c.tracker.Received(partition, offset)
+		deliveries[i] = mq.NewOffsetDelivery(partition, offset)
+		batch[i] = mq.RawMessage{Data: msg.Value, Attributes: HeaderToMap(msg.Headers), Delivery: deliveries[i]}
+	}
+	handle(ctx, batch)
+	if c.AckOnConsume {
+		return true
+	}
+	for _, i := range c.tracker.Commits(deliveries) {
+		if _, err := c.Consumer.CommitMessage(msgs[i]); err != nil && c.LogError != nil {
+			c.LogError(ctx, "Error when commit: "+err.Error())
+		}
+	}
+	for i, d := range deliveries {
+		if !d.Acked() {
+			if c.LogError != nil {
+				c.LogError(ctx, fmt.Sprintf("Message at offset %d of %s is not acked. Stop consuming, so that it is received again when the consumer restarts", int64(msgs[i].TopicPartition.Offset), d.Partition))
+			}
+			return false
+		}
+	}
+	return true
+}
@@ -4,20 +4,32 @@ import (
 	"context"
 	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/core-go/mq"
 	"log"
 	"strings"
+	"sync"
 	"time"
 )
 
 type (
 	Consumer struct {
-		Consumer *kafka.Consumer
-		Topics   []string
-		LogError func(context.Context, string)
-		LogInfo  func(context.Context, string)
+		Consumer     *kafka.Consumer
+		Topics       []string
+		AckOnConsume bool
+		LogError     func(context.Context, string)
+		HandleError  func(context.Context, []byte, map[string]string)
+		LogInfo      func(context.Context, string)
+		canceler     mq.Canceler
+		closeOnce    sync.Once
+		closeErr     error
+		tracker      mq.OffsetTracker
+		gate         mq.Gate
 	}
 )
 
+// pollTimeout is in milliseconds, so that the consuming loops can stop when ctx is done
+const pollTimeout = 100
+
 func NewKafkaConsumerByConfig(c ConsumerConfig) (*kafka.Consumer, error) {
 	conf := kafka.ConfigMap{
 		"bootstrap.servers": strings.Join(c.Brokers, ","),
@@ -54,8 +66,11 @@ func NewKafkaConsumerByConfig(c ConsumerConfig) (*kafka.Consumer, error) {
 }
 
 func NewConsumerByConfig(c ConsumerConfig, logs ...func(context.Context, string)) (*Consumer, error) {
-	if c.Client.Retry != nil && c.Client.Retry.Retry1 > 0 {
-		durations := DurationsFromValue(*c.Client.Retry, "Retry", 9)
+	durations, err := c.Client.Retry.Durations()
+	if err != nil {
+		return nil, err
+	}
+	if len(durations) > 0 {
 		return NewConsumerByConfigAndRetryArray(c, durations)
 	} else {
 		consumer, err := NewKafkaConsumerByConfig(c)
@@ -64,8 +79,9 @@ func NewConsumerByConfig(c ConsumerConfig, logs ...func(context.Context, string)
 			return nil, err
 		}
 		cs := &Consumer{
-			Consumer: consumer,
-			Topics:   []string{c.Topic},
+			Consumer:     consumer,
+			Topics:       []string{c.Topic},
+			AckOnConsume: c.AckOnConsume,
 		}
 		if len(logs) >= 1 {
 			cs.LogError = logs[0]
@@ -136,13 +152,26 @@ func NewConsumerByConfigAndRetryArray(c ConsumerConfig, retries []time.Duration)
 		log.Println(fmt.Sprintf("Fail in creating new Consumer after %d retries", i))
 	}
 	return &Consumer{
-		Consumer: consumer,
-		Topics:   []string{c.Topic},
+		Consumer:     consumer,
+		Topics:       []string{c.Topic},
+		AckOnConsume: c.AckOnConsume,
 	}, nil
 }
 
+// Consume reads the messages until ctx is done, Close is called or the consumer returns an error, then closes the consumer.
+// If AckOnConsume is false, the offsets are committed by mq.OffsetCommitter: the acks only record the state of the messages,
+// and each partition is committed up to its highest contiguous acked offset, so the handler can ack out of order, such as by Goroutines or WorkerPool.
+// Kafka does not redeliver a message in a live session, so when a message is nacked, the error is logged and Consume returns,
+// and the messages after the last committed offset are received again when the consumer restarts.
 func (c *Consumer) Consume(ctx context.Context, handle func(context.Context, []byte, map[string]string)) {
-	defer c.Consumer.Close()
+	handle = mq.RecoverWithMap(handle, c.LogError, c.HandleError)
+	defer c.close()
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	ctx2, cancel := context.WithCancel(ctx2)
+	defer cancel()
+	committer := c.newCommitter(cancel)
+	ctx = mq.WithPauser(ctx, c)
 
 	err := c.Consumer.SubscribeTopics(c.Topics, nil)
 	if err != nil {
@@ -152,15 +181,15 @@ func (c *Consumer) Consume(ctx context.Context, handle func(context.Context, []b
 		return
 	}
 	run := true
-	for run == true {
-		ev := c.Consumer.Poll(0)
+	for run == true && ctx2.Err() == nil {
+		ev := c.Consumer.Poll(pollTimeout)
 		switch e := ev.(type) {
 		case *kafka.Message:
 			if c.LogInfo != nil {
 				c.LogInfo(ctx, fmt.Sprintf("Message on %s: %s", e.TopicPartition, string(e.Value)))
 			}
 			h := HeaderToMap(e.Headers)
-			handle(ctx, e.Value, h)
+			handle(c.withDelivery(ctx, committer, e), e.Value, h)
 		case kafka.PartitionEOF:
 			if c.LogInfo != nil {
 				c.LogInfo(ctx, fmt.Sprintf("Reached %v", e))
@@ -175,7 +204,14 @@ func (c *Consumer) Consume(ctx context.Context, handle func(context.Context, []b
 	}
 }
 func (c *Consumer) ConsumeValue(ctx context.Context, handle func(context.Context, []byte)) {
-	defer c.Consumer.Close()
+	handle = mq.Recover(handle, c.LogError)
+	defer c.close()
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	ctx2, cancel := context.WithCancel(ctx2)
+	defer cancel()
+	committer := c.newCommitter(cancel)
+	ctx = mq.WithPauser(ctx, c)
 
 	err := c.Consumer.SubscribeTopics(c.Topics, nil)
 	if err != nil {
@@ -185,14 +221,14 @@ func (c *Consumer) ConsumeValue(ctx context.Context, handle func(context.Context
 		return
 	}
 	run := true
-	for run == true {
-		ev := c.Consumer.Poll(0)
+	for run == true && ctx2.Err() == nil {
+		ev := c.Consumer.Poll(pollTimeout)
 		switch e := ev.(type) {
 		case *kafka.Message:
 			if c.LogInfo != nil {
 				c.LogInfo(ctx, fmt.Sprintf("Message on %s: %s", e.TopicPartition, string(e.Value)))
 			}
-			handle(ctx, e.Value)
+			handle(c.withDelivery(ctx, committer, e), e.Value)
 		case kafka.PartitionEOF:
 			if c.LogInfo != nil {
 				c.LogInfo(ctx, fmt.Sprintf("Reached %v", e))
@@ -207,7 +243,14 @@ func (c *Consumer) ConsumeValue(ctx context.Context, handle func(context.Context
 	}
 }
 func (c *Consumer) ConsumeMessage(ctx context.Context, handle func(context.Context, *kafka.Message)) {
-	defer c.Consumer.Close()
+	handle = mq.Recover(handle, c.LogError)
+	defer c.close()
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	ctx2, cancel := context.WithCancel(ctx2)
+	defer cancel()
+	committer := c.newCommitter(cancel)
+	ctx = mq.WithPauser(ctx, c)
 
 	err := c.Consumer.SubscribeTopics(c.Topics, nil)
 	if err != nil {
@@ -217,14 +260,14 @@ func (c *Consumer) ConsumeMessage(ctx context.Context, handle func(context.Conte
 		return
 	}
 	run := true
-	for run == true {
-		ev := c.Consumer.Poll(0)
+	for run == true && ctx2.Err() == nil {
+		ev := c.Consumer.Poll(pollTimeout)
 		switch e := ev.(type) {
 		case *kafka.Message:
 			if c.LogInfo != nil {
 				c.LogInfo(ctx, fmt.Sprintf("Message on %s: %s", e.TopicPartition, string(e.Value)))
 			}
-			handle(ctx, e)
+			handle(c.withDelivery(ctx, committer, e), e)
 		case kafka.PartitionEOF:
 			if c.LogInfo != nil {
 				c.LogInfo(ctx, fmt.Sprintf("Reached %v", e))
@@ -238,6 +281,67 @@ func (c *Consumer) ConsumeMessage(ctx context.Context, handle func(context.Conte
 		}
 	}
 }
+
+// Pause stops fetching the assigned partitions, and the consuming loops still poll, so that the consumer stays in the group. The partitions which are assigned after a rebalance are not paused.
+func (c *Consumer) Pause() error {
+	parts, err := c.Consumer.Assignment()
+	if err != nil {
+		return err
+	}
+	if err = c.Consumer.Pause(parts); err != nil {
+		return err
+	}
+	return c.gate.Pause()
+}
+func (c *Consumer) Resume() error {
+	parts, err := c.Consumer.Assignment()
+	if err != nil {
+		return err
+	}
+	if err = c.Consumer.Resume(parts); err != nil {
+		return err
+	}
+	return c.gate.Resume()
+}
+func (c *Consumer) Paused() bool {
+	return c.gate.Paused()
+}
+
+// Close stops the consuming loops, and closes the consumer
+func (c *Consumer) Close() error {
+	c.canceler.Cancel()
+	return c.close()
+}
+func (c *Consumer) close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.Consumer.Close()
+	})
+	return c.closeErr
+}
+
+// newCommitter returns the committer of a consuming loop, which logs the error and cancels the loop when a message is nacked
+func (c *Consumer) newCommitter(cancel context.CancelFunc) *mq.OffsetCommitter[*kafka.Message] {
+	return mq.NewOffsetCommitter(func(ctx context.Context, msgs []*kafka.Message) error {
+		offsets := make([]kafka.TopicPartition, len(msgs))
+		for i, msg := range msgs {
+			offsets[i] = msg.TopicPartition
+			offsets[i].Offset++
+		}
+		_, err := c.Consumer.CommitOffsets(offsets)
+		return err
+	}, func(ctx context.Context, msg *kafka.Message) {
+		if c.LogError != nil {
+			c.LogError(ctx, fmt.Sprintf("Message at offset %d of %s is nacked. Stop consuming, so that it is received again when the consumer restarts", int64(msg.TopicPartition.Offset), Partition(msg)))
+		}
+		cancel()
+	})
+}
+func (c *Consumer) withDelivery(ctx context.Context, committer *mq.OffsetCommitter[*kafka.Message], msg *kafka.Message) context.Context {
+	if c.AckOnConsume {
+		return ctx
+	}
+	return mq.WithDelivery(ctx, committer.Track(Partition(msg), int64(msg.TopicPartition.Offset), msg))
+}
 func HeaderToMap(headers []kafka.Header) map[string]string {
 	attributes := make(map[string]string, 0)
 	for _, v := range headers {
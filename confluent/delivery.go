@@ -0,0 +1,16 @@
+package kafka
+
+import (
+	"strconv"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Partition is the key of the partition of the message in mq.OffsetTracker and mq.OffsetCommitter
+func Partition(msg *kafka.Message) string {
+	var topic string
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+	return topic + ":" + strconv.Itoa(int(msg.TopicPartition.Partition))
+}
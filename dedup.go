@@ -0,0 +1,175 @@
+package mq
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DedupStore keeps the IDs of the processed messages. It can be MemoryDedupStore, sql DedupStore or redis DedupStore.
+type DedupStore interface {
+	Exists(ctx context.Context, id string) (bool, error)
+	Save(ctx context.Context, id string) error
+}
+
+type messageIDKey struct{}
+
+func WithMessageID(ctx context.Context, id string) context.Context {
+	if len(id) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, messageIDKey{}, id)
+}
+func GetMessageID(ctx context.Context) string {
+	id, ok := ctx.Value(messageIDKey{}).(string)
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// Dedup skips the messages which are already processed, because the brokers can redeliver a message (sqs visibility timeout, kafka rebalance, pubsub redelivery).
+// The ID of a message is the attribute Attribute, or the context value of Key, such as Handler.Key or pubsub Subscriber.ID, or the result of MessageID, such as sqs GetMessageId.
+// Handle checks the ID before the message is handled, and DedupWrite saves the ID only after the message is written successfully.
+// If Store returns an error, the message is handled, so that a message is never lost, but it can be processed more than once.
+type Dedup struct {
+	Store     DedupStore
+	Key       string
+	Attribute string
+	MessageID func(context.Context) string
+	LogError  func(context.Context, string)
+	LogInfo   func(context.Context, string)
+}
+
+func NewDedup(store DedupStore, key string, attribute string, logs ...func(context.Context, string)) *Dedup {
+	d := &Dedup{Store: store, Key: key, Attribute: attribute}
+	if len(logs) >= 1 {
+		d.LogError = logs[0]
+	}
+	if len(logs) >= 2 {
+		d.LogInfo = logs[1]
+	}
+	return d
+}
+
+// GetID returns the ID of the message, from the attribute first, then from the context value of Key, then from MessageID
+func (d *Dedup) GetID(ctx context.Context, attrs map[string]string) string {
+	if len(d.Attribute) > 0 && attrs != nil {
+		if id := attrs[d.Attribute]; len(id) > 0 {
+			return id
+		}
+	}
+	if len(d.Key) > 0 {
+		if id := GetString(ctx, d.Key); len(id) > 0 {
+			return id
+		}
+	}
+	if d.MessageID != nil {
+		return d.MessageID(ctx)
+	}
+	return ""
+}
+
+// Handle wraps the handle callback, such as Handler.HandleWithMap. The duplicated messages are acked and not handled.
+func (d *Dedup) Handle(handle func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string) {
+	return func(ctx context.Context, data []byte, attrs map[string]string) {
+		id := d.GetID(ctx, attrs)
+		if len(id) == 0 {
+			handle(ctx, data, attrs)
+			return
+		}
+		exist, err := d.Store.Exists(ctx, id)
+		if err != nil {
+			if d.LogError != nil {
+				d.LogError(ctx, fmt.Sprintf("Cannot check message id %s. Error: %s", id, err.Error()))
+			}
+		} else if exist {
+			if d.LogInfo != nil {
+				d.LogInfo(ctx, fmt.Sprintf("Skip duplicated message with id %s", id))
+			}
+			Ack(ctx, d.LogError)
+			return
+		}
+		handle(WithMessageID(ctx, id), data, attrs)
+	}
+}
+
+// Save marks the message of ctx as processed
+func (d *Dedup) Save(ctx context.Context) {
+	id := GetMessageID(ctx)
+	if len(id) == 0 {
+		return
+	}
+	if err := d.Store.Save(ctx, id); err != nil && d.LogError != nil {
+		d.LogError(ctx, fmt.Sprintf("Cannot save message id %s. Error: %s", id, err.Error()))
+	}
+}
+
+// DedupWrite wraps the write function of Handler or RetryHandler, to save the message ID after write succeeds
+func DedupWrite[T any](d *Dedup, write func(context.Context, *T) error) func(context.Context, *T) error {
+	return func(ctx context.Context, v *T) error {
+		err := write(ctx, v)
+		if err == nil {
+			d.Save(ctx)
+		}
+		return err
+	}
+}
+
+// MemoryDedupStore is a LRU of the message IDs, with ttl. If ttl is 0, the IDs do not expire, and are only evicted when the store is full.
+type MemoryDedupStore struct {
+	capacity int
+	ttl      time.Duration
+	mu       sync.Mutex
+	items    map[string]*list.Element
+	lru      *list.List
+}
+type dedupEntry struct {
+	id      string
+	expires time.Time
+}
+
+func NewMemoryDedupStore(capacity int, ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{capacity: capacity, ttl: ttl, items: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (s *MemoryDedupStore) Exists(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[id]
+	if !ok {
+		return false, nil
+	}
+	entry := e.Value.(*dedupEntry)
+	if s.ttl > 0 && time.Now().After(entry.expires) {
+		s.lru.Remove(e)
+		delete(s.items, id)
+		return false, nil
+	}
+	s.lru.MoveToFront(e)
+	return true, nil
+}
+func (s *MemoryDedupStore) Save(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires := time.Now().Add(s.ttl)
+	if e, ok := s.items[id]; ok {
+		e.Value.(*dedupEntry).expires = expires
+		s.lru.MoveToFront(e)
+		return nil
+	}
+	s.items[id] = s.lru.PushFront(&dedupEntry{id: id, expires: expires})
+	for s.capacity > 0 && s.lru.Len() > s.capacity {
+		e := s.lru.Back()
+		s.lru.Remove(e)
+		delete(s.items, e.Value.(*dedupEntry).id)
+	}
+	return nil
+}
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
@@ -0,0 +1,136 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryDedupStore(2, 20*time.Millisecond)
+	s.Save(ctx, "a")
+	if exist, _ := s.Exists(ctx, "a"); !exist {
+		t.Error("Exists(a) = false, want true before ttl")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if exist, _ := s.Exists(ctx, "a"); exist {
+		t.Error("Exists(a) = true, want false after ttl")
+	}
+	if n := s.Len(); n != 0 {
+		t.Errorf("Len() = %d, want 0, the expired id is removed", n)
+	}
+
+	s = NewMemoryDedupStore(2, 0)
+	s.Save(ctx, "a")
+	s.Save(ctx, "b")
+	s.Exists(ctx, "a")
+	s.Save(ctx, "c")
+	for id, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if exist, _ := s.Exists(ctx, id); exist != want {
+			t.Errorf("Exists(%s) = %v, want %v, the least recently used id is evicted", id, exist, want)
+		}
+	}
+}
+
+type failedDedupStore struct{}
+
+func (s failedDedupStore) Exists(ctx context.Context, id string) (bool, error) {
+	return false, errors.New("store is down")
+}
+func (s failedDedupStore) Save(ctx context.Context, id string) error {
+	return errors.New("store is down")
+}
+
+func TestDedupHandle(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore(10, 0)
+	store.Save(ctx, "1")
+	tests := []struct {
+		name    string
+		store   DedupStore
+		attrs   map[string]string
+		handled int
+		acks    int
+	}{
+		{name: "new", store: store, attrs: map[string]string{"id": "2"}, handled: 1},
+		{name: "duplicate", store: store, attrs: map[string]string{"id": "1"}, acks: 1},
+		{name: "no id", store: store, handled: 1},
+		{name: "store fails", store: failedDedupStore{}, attrs: map[string]string{"id": "1"}, handled: 1},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			d := NewDedup(c.store, "", "id")
+			handled := 0
+			handle := d.Handle(func(ctx context.Context, data []byte, attrs map[string]string) {
+				handled++
+			})
+			delivery := &testDelivery{}
+			handle(WithDelivery(ctx, delivery), []byte(`{}`), c.attrs)
+			if handled != c.handled {
+				t.Errorf("handled = %d, want %d", handled, c.handled)
+			}
+			if delivery.acks != c.acks || delivery.nacks != 0 {
+				t.Errorf("acks = %d, nacks = %d, want %d, 0", delivery.acks, delivery.nacks, c.acks)
+			}
+		})
+	}
+}
+
+func TestDedupGetID(t *testing.T) {
+	getter := func(ctx context.Context) string {
+		return "getter"
+	}
+	ctx := context.WithValue(context.Background(), "key", "key")
+	tests := []struct {
+		name      string
+		key       string
+		attribute string
+		messageID func(context.Context) string
+		attrs     map[string]string
+		id        string
+	}{
+		{name: "attribute", key: "key", attribute: "id", messageID: getter, attrs: map[string]string{"id": "attribute"}, id: "attribute"},
+		{name: "key", key: "key", attribute: "id", messageID: getter, id: "key"},
+		{name: "message id", attribute: "id", messageID: getter, attrs: map[string]string{}, id: "getter"},
+		{name: "none", attribute: "id"},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			d := NewDedup(NewMemoryDedupStore(10, 0), c.key, c.attribute)
+			d.MessageID = c.messageID
+			if id := d.GetID(ctx, c.attrs); id != c.id {
+				t.Errorf("GetID() = %q, want %q", id, c.id)
+			}
+		})
+	}
+}
+
+// TestDedupWrite checks that the id is saved only after the message is written, so a failed message is handled again on redelivery
+func TestDedupWrite(t *testing.T) {
+	store := NewMemoryDedupStore(10, 0)
+	d := NewDedup(store, "", "")
+	d.MessageID = func(ctx context.Context) string {
+		return "1"
+	}
+	var failure error = errors.New("write fails")
+	written := 0
+	write := DedupWrite[testUser](d, func(ctx context.Context, u *testUser) error {
+		written++
+		return failure
+	})
+	handle := d.Handle(func(ctx context.Context, data []byte, attrs map[string]string) {
+		write(ctx, &testUser{})
+	})
+	handle(context.Background(), nil, nil)
+	failure = nil
+	handle(context.Background(), nil, nil)
+	handle(context.Background(), nil, nil)
+	if written != 2 {
+		t.Errorf("written = %d, want 2, the failed message is written again, then the duplicate is skipped", written)
+	}
+	if exist, _ := store.Exists(context.Background(), "1"); !exist {
+		t.Error("the id is not saved after write")
+	}
+}
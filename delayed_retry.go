@@ -0,0 +1,123 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// NotBeforeName is the attribute of the time in unix milliseconds, before which the message must not be handled. It is used by the brokers which cannot delay a message, such as kafka.
+	NotBeforeName = "not-before"
+	// RetryDelayName is the attribute of the last retry delay in milliseconds, which is the previous delay of the retry policy
+	RetryDelayName = "retry-delay"
+)
+
+// ErrSettled is returned by a retry function which acks, nacks or requeues the message by itself, such as DelayedRetry.Requeue,
+// so that RetryHandler and BatchWorker neither ack nor nack the message after the retry
+var ErrSettled = errors.New("message is settled by retry")
+
+// DelayedRetry resends the failed messages with a delay, which is computed by Policy from the retry count of the message.
+// The delay is done by the broker: Send publishes the message with the delay, such as the DelaySeconds of sqs (standard queues only, a FIFO queue returns sqs.ErrFIFODelay), a rabbitmq queue with TTL and a dead letter exchange,
+// or a kafka retry topic with NotBeforeName. Retry and Requeue are used as the retry function of RetryHandler and BatchWorker.
+// When Policy has no delay for the retry count, the previous delay is used, and LimitRetry of the handler stops the retries.
+type DelayedRetry struct {
+	Policy         RetryPolicy
+	RetryCountName string
+	Send           func(ctx context.Context, data []byte, attributes map[string]string, delay time.Duration) error
+	LogInfo        func(context.Context, string)
+}
+
+func NewDelayedRetry(policy RetryPolicy, send func(context.Context, []byte, map[string]string, time.Duration) error, retryCountName string, logs ...func(context.Context, string)) *DelayedRetry {
+	if len(retryCountName) == 0 {
+		retryCountName = DefaultRetryCountName
+	}
+	r := &DelayedRetry{Policy: policy, RetryCountName: retryCountName, Send: send}
+	if len(logs) >= 1 {
+		r.LogInfo = logs[0]
+	}
+	return r
+}
+
+// Delay returns the delay of the retry count of the attributes
+func (r *DelayedRetry) Delay(attrs map[string]string) time.Duration {
+	retryCount, _ := strconv.Atoi(attrs[r.RetryCountName])
+	if retryCount < 1 {
+		retryCount = 1
+	}
+	var previous time.Duration
+	if ms, err := strconv.ParseInt(attrs[RetryDelayName], 10, 64); err == nil && ms > 0 {
+		previous = time.Duration(ms) * time.Millisecond
+	}
+	if r.Policy == nil {
+		return previous
+	}
+	delay, ok := r.Policy.Delay(retryCount, previous)
+	if !ok {
+		return previous
+	}
+	return delay
+}
+
+// Retry sends the message by Send, with the delay of the retry count
+func (r *DelayedRetry) Retry(ctx context.Context, data []byte, attrs map[string]string) error {
+	if attrs == nil {
+		attrs = make(map[string]string)
+	}
+	delay := r.Delay(attrs)
+	attrs[RetryDelayName] = strconv.FormatInt(delay.Milliseconds(), 10)
+	if r.LogInfo != nil {
+		r.LogInfo(ctx, fmt.Sprintf("Retry %s after %s", attrs[r.RetryCountName], delay.String()))
+	}
+	return r.Send(ctx, data, attrs, delay)
+}
+
+// Requeue does not send the message, but requeues the delivery of ctx with the delay of the retry count, such as the Nack of Pub/Sub after the delay,
+// then returns ErrSettled. The retry count must be set by the consumer on redelivery, because the attributes of a requeued message cannot be changed.
+func (r *DelayedRetry) Requeue(ctx context.Context, data []byte, attrs map[string]string) error {
+	delivery := GetDelivery(ctx)
+	if delivery == nil {
+		return ErrRequeueNotSupported
+	}
+	delay := r.Delay(attrs)
+	if r.LogInfo != nil {
+		r.LogInfo(ctx, fmt.Sprintf("Requeue %s after %s", attrs[r.RetryCountName], delay.String()))
+	}
+	if err := delivery.Requeue(ctx, delay); err != nil {
+		return err
+	}
+	return ErrSettled
+}
+
+// NotBefore is the attribute value of NotBeforeName for the delay
+func NotBefore(delay time.Duration) string {
+	return strconv.FormatInt(time.Now().Add(delay).UnixMilli(), 10)
+}
+
+// GetNotBefore returns the time of NotBeforeName of the attributes, or false if there is no valid time
+func GetNotBefore(attrs map[string]string) (time.Time, bool) {
+	ms, err := strconv.ParseInt(attrs[NotBeforeName], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
+
+// WaitNotBefore pauses the consumer until the time of NotBeforeName of the message, by blocking the handle callback.
+// It is used by the consumers of the kafka retry topics, which handle the messages of a partition serially, so the later messages wait too.
+// If ctx is done while waiting, the message is nacked and not handled.
+func WaitNotBefore(logError func(context.Context, string)) Middleware {
+	return func(handle func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string) {
+		return func(ctx context.Context, data []byte, attrs map[string]string) {
+			if t, ok := GetNotBefore(attrs); ok {
+				if err := Sleep(ctx, time.Until(t)); err != nil {
+					Nack(ctx, logError)
+					return
+				}
+			}
+			handle(ctx, data, attrs)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+var ErrRequeueNotSupported = errors.New("requeue is not supported")
+
+// Delivery acknowledges a received message. The consumers put it into the context of the handle callback when AckOnConsume is false,
+// so that Handler, RetryHandler and BatchWorker acknowledge the message only after it is written, or routed to HandleError.
+// Nack tells the broker that the message is not processed, so that it is redelivered. Requeue redelivers the message after delay, if the broker supports it.
+type Delivery interface {
+	Ack(ctx context.Context) error
+	Nack(ctx context.Context) error
+	Requeue(ctx context.Context, delay time.Duration) error
+}
+
+type deliveryKey struct{}
+
+func WithDelivery(ctx context.Context, delivery Delivery) context.Context {
+	if delivery == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, deliveryKey{}, delivery)
+}
+func GetDelivery(ctx context.Context) Delivery {
+	delivery, ok := ctx.Value(deliveryKey{}).(Delivery)
+	if !ok {
+		return nil
+	}
+	return delivery
+}
+
+// Ack acknowledges the delivery of ctx, if any
+func Ack(ctx context.Context, logError func(context.Context, string)) {
+	AckDelivery(ctx, GetDelivery(ctx), logError)
+}
+
+// Nack negatively acknowledges the delivery of ctx, if any, so that the broker can redeliver the message
+func Nack(ctx context.Context, logError func(context.Context, string)) {
+	NackDelivery(ctx, GetDelivery(ctx), logError)
+}
+func AckDelivery(ctx context.Context, delivery Delivery, logError func(context.Context, string)) {
+	if delivery == nil {
+		return
+	}
+	if err := delivery.Ack(ctx); err != nil && logError != nil {
+		logError(ctx, "Cannot ack message: "+err.Error())
+	}
+}
+func NackDelivery(ctx context.Context, delivery Delivery, logError func(context.Context, string)) {
+	if delivery == nil {
+		return
+	}
+	if err := delivery.Nack(ctx); err != nil && logError != nil {
+		logError(ctx, "Cannot nack message: "+err.Error())
+	}
+}
+
+// settlement is the delivery of ctx while HandleError or Reject runs. It records the nack, without passing it to the delivery of the message.
+type settlement struct {
+	nacked int32
+}
+
+func (s *settlement) Ack(ctx context.Context) error {
+	return nil
+}
+func (s *settlement) Nack(ctx context.Context) error {
+	atomic.StoreInt32(&s.nacked, 1)
+	return nil
+}
+func (s *settlement) Requeue(ctx context.Context, delay time.Duration) error {
+	atomic.StoreInt32(&s.nacked, 1)
+	return nil
+}
+
+// Handled calls f, such as HandleError or Reject, and returns false if f nacks the message, such as DLQ nacks it when it cannot be published.
+// The ack and nack of f are not passed to the delivery, so the caller acks or nacks the message once, by the result.
+func Handled(ctx context.Context, f func(context.Context)) bool {
+	s := &settlement{}
+	f(WithDelivery(ctx, s))
+	return atomic.LoadInt32(&s.nacked) == 0
+}
+
+// AckMessages acks the messages of a batch, except the failed messages, which are acked or nacked by the retry and error handling
+func AckMessages[T any](ctx context.Context, messages []Message[T], failMessages []Message[T], logError func(context.Context, string)) {
+	for _, msg := range messages {
+		if msg.Delivery != nil && !containsDelivery(failMessages, msg.Delivery) {
+			AckDelivery(ctx, msg.Delivery, logError)
+		}
+	}
+}
+func containsDelivery[T any](messages []Message[T], delivery Delivery) bool {
+	for _, msg := range messages {
+		if sameDelivery(msg.Delivery, delivery) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameDelivery compares the deliveries by identity, without panic if the type of the delivery is not comparable
+func sameDelivery(a Delivery, b Delivery) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) {
+		return false
+	}
+	if t.Comparable() {
+		return a == b
+	}
+	switch t.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	default:
+		return false
+	}
+}
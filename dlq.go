@@ -0,0 +1,188 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DLQReasonName       = "dlq-reason"
+	DLQRetryCountName   = "dlq-retry-count"
+	DLQSourceName       = "dlq-source"
+	DLQFirstFailureName = "dlq-first-failure"
+	DLQErrorsName       = "dlq-errors"
+	DLQPrefix           = "dlq-"
+
+	ReasonValidation = "validation failed"
+	ReasonFailed     = "failed to handle"
+)
+
+type failureKey struct{}
+
+// WithFailure puts the error of writing into ctx, so that HandleError can use it as the reason
+func WithFailure(ctx context.Context, err error) context.Context {
+	if err == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, failureKey{}, err)
+}
+func GetFailure(ctx context.Context) error {
+	err, ok := ctx.Value(failureKey{}).(error)
+	if !ok {
+		return nil
+	}
+	return err
+}
+
+// DLQ publishes the original message to a dead letter queue by send, which can be kafka Writer.Write, rabbitmq Publisher.Publish, sqs Sender.Send, pubsub Publisher.Publish or nats Publisher.Publish.
+// It can be used as Reject and HandleError of Handler, RetryHandler and BatchWorker.
+type DLQ[T any] struct {
+	Send           func(context.Context, []byte, map[string]string) error
+	Source         string
+	RetryCountName string
+	LogError       func(context.Context, string)
+}
+
+func NewDLQ[T any](send func(context.Context, []byte, map[string]string) error, source string, retryCountName string, logError ...func(context.Context, string)) *DLQ[T] {
+	q := &DLQ[T]{Send: send, Source: source, RetryCountName: retryCountName}
+	if len(logError) >= 1 {
+		q.LogError = logError[0]
+	}
+	return q
+}
+
+func (q *DLQ[T]) Reject(ctx context.Context, res T, errs []ErrorMessage, data []byte) {
+	q.RejectWithMap(ctx, res, errs, data, nil)
+}
+func (q *DLQ[T]) RejectWithMap(ctx context.Context, res T, errs []ErrorMessage, data []byte, attrs map[string]string) {
+	q.publish(ctx, data, attrs, ReasonValidation, errs)
+}
+func (q *DLQ[T]) HandleError(ctx context.Context, data []byte) {
+	q.HandleErrorWithMap(ctx, data, nil)
+}
+func (q *DLQ[T]) HandleErrorWithMap(ctx context.Context, data []byte, attrs map[string]string) {
+	reason := ReasonFailed
+	if err := GetFailure(ctx); err != nil {
+		reason = err.Error()
+	}
+	q.publish(ctx, data, attrs, reason, nil)
+}
+
+// publish nacks the message if it cannot be sent to the DLQ, so that Handler, RetryHandler and BatchWorker do not ack it after HandleError or Reject
+func (q *DLQ[T]) publish(ctx context.Context, data []byte, attrs map[string]string, reason string, errs []ErrorMessage) error {
+	if data == nil {
+		return nil
+	}
+	headers := BuildDLQAttributes(attrs, reason, q.Source, q.RetryCountName, errs)
+	err := q.Send(ctx, data, headers)
+	if err != nil {
+		if q.LogError != nil {
+			q.LogError(ctx, fmt.Sprintf("Cannot send message to DLQ: %s Reason: %s Error: %s", GetLog(data, headers), reason, err.Error()))
+		}
+		Nack(ctx, q.LogError)
+	}
+	return err
+}
+
+// BuildDLQAttributes copies attrs, and adds the reason, retry count, source, first failure time and the validation errors
+func BuildDLQAttributes(attrs map[string]string, reason string, source string, retryCountName string, errs []ErrorMessage) map[string]string {
+	headers := make(map[string]string, len(attrs)+5)
+	for k, v := range attrs {
+		headers[k] = v
+	}
+	headers[DLQReasonName] = reason
+	retryCount := "0"
+	if len(retryCountName) > 0 && len(attrs[retryCountName]) > 0 {
+		retryCount = attrs[retryCountName]
+	}
+	headers[DLQRetryCountName] = retryCount
+	if len(source) > 0 {
+		headers[DLQSourceName] = source
+	}
+	if len(headers[DLQFirstFailureName]) == 0 {
+		headers[DLQFirstFailureName] = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	if len(errs) > 0 {
+		if bs, err := json.Marshal(errs); err == nil {
+			headers[DLQErrorsName] = string(bs)
+		}
+	}
+	return headers
+}
+
+// GetDLQErrors returns the validation errors of a DLQ message
+func GetDLQErrors(attrs map[string]string) ([]ErrorMessage, error) {
+	s := attrs[DLQErrorsName]
+	if len(s) == 0 {
+		return nil, nil
+	}
+	var errs []ErrorMessage
+	err := json.Unmarshal([]byte(s), &errs)
+	return errs, err
+}
+
+// Replayer reads the messages of the DLQ, by the handle callback of any consumer, and re-submits the selected messages to the original destination (the dlq-source attribute),
+// or to Destination if there is no dlq-source. The DLQ attributes and the retry count are removed. The messages which are not selected are neither acked nor nacked.
+type Replayer struct {
+	Send           func(ctx context.Context, destination string, data []byte, attrs map[string]string) error
+	Select         func(ctx context.Context, data []byte, attrs map[string]string) bool
+	Destination    string
+	RetryCountName string
+	LogError       func(context.Context, string)
+	LogInfo        func(context.Context, string)
+}
+
+func NewReplayer(send func(context.Context, string, []byte, map[string]string) error, selectMessage func(context.Context, []byte, map[string]string) bool, destination string, retryCountName string, logs ...func(context.Context, string)) *Replayer {
+	r := &Replayer{Send: send, Select: selectMessage, Destination: destination, RetryCountName: retryCountName}
+	if len(logs) >= 1 {
+		r.LogError = logs[0]
+	}
+	if len(logs) >= 2 {
+		r.LogInfo = logs[1]
+	}
+	return r
+}
+
+// NewReplayerBySender is used when the original destination is fixed, such as kafka Writer or sqs Sender
+func NewReplayerBySender(send func(context.Context, []byte, map[string]string) error, selectMessage func(context.Context, []byte, map[string]string) bool, retryCountName string, logs ...func(context.Context, string)) *Replayer {
+	send2 := func(ctx context.Context, destination string, data []byte, attrs map[string]string) error {
+		return send(ctx, data, attrs)
+	}
+	return NewReplayer(send2, selectMessage, "", retryCountName, logs...)
+}
+
+func (r *Replayer) Handle(ctx context.Context, data []byte, attrs map[string]string) {
+	if r.Select != nil && !r.Select(ctx, data, attrs) {
+		return
+	}
+	if err := r.Replay(ctx, data, attrs); err != nil {
+		if r.LogError != nil {
+			r.LogError(ctx, fmt.Sprintf("Cannot replay message: %s Error: %s", GetLog(data, attrs), err.Error()))
+		}
+		Nack(ctx, r.LogError)
+		return
+	}
+	if r.LogInfo != nil {
+		r.LogInfo(ctx, fmt.Sprintf("Replayed message: %s", GetLog(data, attrs)))
+	}
+	Ack(ctx, r.LogError)
+}
+
+// Replay re-submits a DLQ message to the original destination
+func (r *Replayer) Replay(ctx context.Context, data []byte, attrs map[string]string) error {
+	destination := attrs[DLQSourceName]
+	if len(destination) == 0 {
+		destination = r.Destination
+	}
+	headers := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		if strings.HasPrefix(k, DLQPrefix) || (len(r.RetryCountName) > 0 && k == r.RetryCountName) {
+			continue
+		}
+		headers[k] = v
+	}
+	return r.Send(ctx, destination, data, headers)
+}
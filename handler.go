@@ -3,26 +3,41 @@ package mq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 )
 
+// If Goroutines is true, Concurrency limits the goroutines of writing. If Ordered is true, the messages with the same key (the context value of Key) are written serially.
 type HandlerConfig struct {
-	Retry      *RetryConfig `yaml:"retry" mapstructure:"retry" json:"retry,omitempty" gorm:"column:retry" bson:"retry,omitempty" dynamodbav:"retry,omitempty" firestore:"retry,omitempty"`
-	Goroutines bool         `yaml:"goroutines" mapstructure:"goroutines" json:"goroutines,omitempty" gorm:"column:goroutines" bson:"goroutines,omitempty" dynamodbav:"goroutines,omitempty" firestore:"goroutines,omitempty"`
-	Key        string       `yaml:"key" mapstructure:"key" json:"key,omitempty" gorm:"column:key" bson:"key,omitempty" dynamodbav:"key,omitempty" firestore:"key,omitempty"`
+	Retry       *RetryConfig `yaml:"retry" mapstructure:"retry" json:"retry,omitempty" gorm:"column:retry" bson:"retry,omitempty" dynamodbav:"retry,omitempty" firestore:"retry,omitempty"`
+	Goroutines  bool         `yaml:"goroutines" mapstructure:"goroutines" json:"goroutines,omitempty" gorm:"column:goroutines" bson:"goroutines,omitempty" dynamodbav:"goroutines,omitempty" firestore:"goroutines,omitempty"`
+	Key         string       `yaml:"key" mapstructure:"key" json:"key,omitempty" gorm:"column:key" bson:"key,omitempty" dynamodbav:"key,omitempty" firestore:"key,omitempty"`
+	Concurrency int          `yaml:"concurrency" mapstructure:"concurrency" json:"concurrency,omitempty" gorm:"column:concurrency" bson:"concurrency,omitempty" dynamodbav:"concurrency,omitempty" firestore:"concurrency,omitempty"`
+	Ordered     bool         `yaml:"ordered" mapstructure:"ordered" json:"ordered,omitempty" gorm:"column:ordered" bson:"ordered,omitempty" dynamodbav:"ordered,omitempty" firestore:"ordered,omitempty"`
+	Validation  string       `yaml:"validation" mapstructure:"validation" json:"validation,omitempty" gorm:"column:validation" bson:"validation,omitempty" dynamodbav:"validation,omitempty" firestore:"validation,omitempty"`
+	ContentType string       `yaml:"content_type" mapstructure:"content_type" json:"contentType,omitempty" gorm:"column:contenttype" bson:"contentType,omitempty" dynamodbav:"contentType,omitempty" firestore:"contentType,omitempty"`
 }
+
+// ContentType is the content type of the messages without content-type attribute. Codecs are used before the codecs registered by RegisterCodec.
 type Handler[T any] struct {
-	Unmarshal   func(data []byte, v any) error
-	Write       func(ctx context.Context, model *T) error
-	Validate    func(context.Context, *T) ([]ErrorMessage, error)
-	Reject      func(context.Context, *T, []ErrorMessage, []byte)
-	HandleError func(context.Context, []byte)
-	Retries     []time.Duration
-	Goroutines  bool
-	Key         string
-	LogError    func(context.Context, string)
-	LogInfo     func(context.Context, string)
+	Unmarshal      func(data []byte, v any) error
+	ContentType    string
+	Codecs         Codecs
+	Write          func(ctx context.Context, model *T) error
+	Validate       func(context.Context, *T) ([]ErrorMessage, error)
+	Reject         func(context.Context, *T, []ErrorMessage, []byte)
+	ValidationMode string
+	Quarantine     func(context.Context, []byte, map[string]string) error
+	HandleError    func(context.Context, []byte)
+	Retries        []time.Duration
+	RetryPolicy    RetryPolicy
+	IsRetryable    func(error) bool
+	Goroutines     bool
+	Pool           *WorkerPool
+	Key            string
+	LogError       func(context.Context, string)
+	LogInfo        func(context.Context, string)
 }
 
 func NewHandlerByConfig[T any](c HandlerConfig,
@@ -33,6 +48,8 @@ func NewHandlerByConfig[T any](c HandlerConfig,
 	logs ...func(context.Context, string)) *Handler[T] {
 	return NewHandlerByConfigAndUnmarshal[T](c, nil, write, validate, reject, handleError, logs...)
 }
+
+// NewHandlerByConfigAndUnmarshal panics if the retry config is invalid. Use NewHandlerByConfigAndUnmarshalE to get the error.
 func NewHandlerByConfigAndUnmarshal[T any](c HandlerConfig,
 	unmarshal func(data []byte, v any) error,
 	write func(context.Context, *T) error,
@@ -40,12 +57,61 @@ func NewHandlerByConfigAndUnmarshal[T any](c HandlerConfig,
 	reject func(context.Context, *T, []ErrorMessage, []byte),
 	handleError func(context.Context, []byte),
 	logs ...func(context.Context, string)) *Handler[T] {
-	if c.Retry == nil {
-		return NewHandlerWithKey[T](unmarshal, write, validate, reject, handleError, nil, c.Goroutines, c.Key, logs...)
-	} else {
-		retries := DurationsFromValue(c.Retry, "Retry", 20)
-		return NewHandlerWithKey[T](unmarshal, write, validate, reject, handleError, retries, c.Goroutines, c.Key, logs...)
+	h, err := newHandlerByConfig[T](c, unmarshal, write, validate, reject, handleError, logs...)
+	if err != nil {
+		panic(err)
 	}
+	return h
+}
+
+// NewHandlerByConfigE returns an error if the retry config or the validation mode is invalid, see CheckValidation
+func NewHandlerByConfigE[T any](c HandlerConfig,
+	write func(context.Context, *T) error,
+	validate func(context.Context, *T) ([]ErrorMessage, error),
+	reject func(context.Context, *T, []ErrorMessage, []byte),
+	handleError func(context.Context, []byte),
+	quarantine func(context.Context, []byte, map[string]string) error,
+	logs ...func(context.Context, string)) (*Handler[T], error) {
+	return NewHandlerByConfigAndUnmarshalE[T](c, nil, write, validate, reject, handleError, quarantine, logs...)
+}
+func NewHandlerByConfigAndUnmarshalE[T any](c HandlerConfig,
+	unmarshal func(data []byte, v any) error,
+	write func(context.Context, *T) error,
+	validate func(context.Context, *T) ([]ErrorMessage, error),
+	reject func(context.Context, *T, []ErrorMessage, []byte),
+	handleError func(context.Context, []byte),
+	quarantine func(context.Context, []byte, map[string]string) error,
+	logs ...func(context.Context, string)) (*Handler[T], error) {
+	if err := CheckValidation(c.Validation, quarantine); err != nil {
+		return nil, err
+	}
+	h, err := newHandlerByConfig[T](c, unmarshal, write, validate, reject, handleError, logs...)
+	if err != nil {
+		return nil, err
+	}
+	h.Quarantine = quarantine
+	return h, nil
+}
+func newHandlerByConfig[T any](c HandlerConfig,
+	unmarshal func(data []byte, v any) error,
+	write func(context.Context, *T) error,
+	validate func(context.Context, *T) ([]ErrorMessage, error),
+	reject func(context.Context, *T, []ErrorMessage, []byte),
+	handleError func(context.Context, []byte),
+	logs ...func(context.Context, string)) (*Handler[T], error) {
+	var retries []time.Duration
+	if c.Retry != nil {
+		var err error
+		retries, err = c.Retry.Durations()
+		if err != nil {
+			return nil, err
+		}
+	}
+	h := NewHandlerWithKey[T](unmarshal, write, validate, reject, handleError, retries, c.Goroutines, c.Key, logs...)
+	h.Pool = NewWorkerPoolByConfig(c.Goroutines, c.Concurrency, c.Ordered)
+	h.ValidationMode = c.Validation
+	h.ContentType = c.ContentType
+	return h, nil
 }
 func NewHandlerWithKey[T any](
 	unmarshal func(data []byte, v any) error,
@@ -77,7 +143,26 @@ func NewHandlerWithKey[T any](
 	return c
 }
 
+// Stop waits until the messages submitted to Pool are written, and stops the workers of Pool. Call it after the consumer is closed.
+func (c *Handler[T]) Stop(ctx context.Context) error {
+	if c.Pool == nil {
+		return nil
+	}
+	return c.Pool.Stop(ctx)
+}
+
+// RegisterCodec registers the codec of a content type for this handler only
+func (c *Handler[T]) RegisterCodec(contentType string, marshal func(v any) ([]byte, error), unmarshal func(data []byte, v any) error) {
+	if c.Codecs == nil {
+		c.Codecs = make(Codecs)
+	}
+	c.Codecs.Register(contentType, marshal, unmarshal)
+}
+
 func (c *Handler[T]) Handle(ctx context.Context, data []byte) {
+	c.HandleWithMap(ctx, data, nil)
+}
+func (c *Handler[T]) HandleWithMap(ctx context.Context, data []byte, attrs map[string]string) {
 	if data == nil {
 		return
 	}
@@ -90,82 +175,112 @@ func (c *Handler[T]) Handle(ctx context.Context, data []byte) {
 		}
 	}
 	var v T
-	er1 := json.Unmarshal(data, &v)
+	er1 := c.Codecs.Unmarshal(data, &v, ResolveContentType(ctx, attrs, c.ContentType), c.Unmarshal)
 	if er1 != nil {
 		if c.LogError != nil {
 			c.LogError(ctx, fmt.Sprintf("cannot unmarshal item: %s. Error: %s", data, er1.Error()))
 		}
+		Ack(ctx, c.LogError)
 		return
 	}
-	if c.Validate != nil {
-		errs, err := c.Validate(ctx, &v)
-		if err != nil {
-			if c.LogError != nil {
-				c.LogError(ctx, "Error when validate data: "+err.Error())
-			}
-			return
-		}
-		if len(errs) > 0 {
-			c.Reject(ctx, &v, errs, data)
-		}
+	if !Validate[T](ctx, &v, data, attrs, c.Validate, c.reject, c.Quarantine, c.ValidationMode, c.LogError) {
+		return
 	}
 	if c.Goroutines {
-		go c.write(ctx, data, &v)
+		if c.Pool != nil {
+			c.Pool.Submit(GetString(ctx, c.Key), func() {
+				c.write(ctx, data, &v)
+			})
+		} else {
+			go c.write(ctx, data, &v)
+		}
 	} else {
 		c.write(ctx, data, &v)
 	}
 }
 
 func (c *Handler[T]) write(ctx context.Context, data []byte, item *T) error {
-	er3 := c.Write(ctx, item)
+	defer NackOnPanic(ctx, data, nil, c.LogError)
+	er3 := c.safe(ctx, item)
 	if er3 == nil {
+		Ack(ctx, c.LogError)
 		return er3
 	}
-	if c.Retries != nil && len(c.Retries) > 0 {
+	policy := c.RetryPolicy
+	if policy == nil && len(c.Retries) > 0 {
+		policy = Durations(c.Retries)
+	}
+	isRetryable := c.IsRetryable
+	if isRetryable == nil {
+		isRetryable = IsRetryable
+	}
+	if policy != nil && isRetryable(er3) {
 		i := 0
-		err := Retry(ctx, c.Retries, func() (err error) {
+		err := RetryWithPolicy(ctx, policy, func() (err error) {
 			i = i + 1
-			er2 := c.Write(ctx, item)
+			er2 := c.safe(ctx, item)
 			if er2 == nil {
 				if c.LogError != nil {
 					c.LogError(ctx, fmt.Sprintf("Write successfully after %d retries %s", i, data))
 				}
 			}
 			return er2
-		}, c.LogError)
-		if err != nil && c.LogError != nil {
-			c.LogError(ctx, fmt.Sprintf("Failed to write after %d retries: %s. Error: %s.", len(c.Retries), data, err.Error()))
-			if c.HandleError != nil {
-				c.HandleError(ctx, data)
+		}, isRetryable, c.LogError)
+		if err != nil {
+			if c.LogError != nil {
+				c.LogError(ctx, fmt.Sprintf("Failed to write after %d retries: %s. Error: %s.", i, data, err.Error()))
+			}
+			if errors.Is(err, ErrCircuitOpen) || ctx.Err() != nil {
+				// the retry is stopped by the shutdown, so the message is redelivered, instead of being passed to HandleError
+				Nack(ctx, c.LogError)
+			} else {
+				c.handleError(WithFailure(ctx, err), data)
 			}
+		} else {
+			Ack(ctx, c.LogError)
 		}
 		return nil
 	} else {
 		if c.LogError != nil {
 			c.LogError(ctx, fmt.Sprintf("Failed to write %s . Error: %s", data, er3.Error()))
 		}
-		if c.HandleError != nil {
-			c.HandleError(ctx, data)
+		if errors.Is(er3, ErrCircuitOpen) {
+			Nack(ctx, c.LogError)
+		} else {
+			c.handleError(WithFailure(ctx, er3), data)
 		}
 		return er3
 	}
 }
 
-// Retry Copy this code from https://stackoverflow.com/questions/47606761/repeat-code-if-an-error-occured
-func Retry(ctx context.Context, sleeps []time.Duration, f func() error, log func(context.Context, string)) (err error) {
-	attempts := len(sleeps)
-	for i := 0; ; i++ {
-		time.Sleep(sleeps[i])
-		err = f()
-		if err == nil {
-			return
-		}
-		if i >= (attempts - 1) {
-			break
-		}
-		if log != nil {
-			log(ctx, fmt.Sprintf("Retrying %d of %d after error: %s", i+1, attempts, err.Error()))
-		}
+// safe calls Write, and returns a PanicError if Write panics, so that the message is passed to HandleError.
+// While Write fails by an open circuit breaker, the consumer is paused, and Write is called again when the breaker allows it.
+func (c *Handler[T]) safe(ctx context.Context, item *T) error {
+	return WaitCircuit(ctx, func() error {
+		return Safe(func() error {
+			return c.Write(ctx, item)
+		})
+	})
+}
+
+func (c *Handler[T]) reject(ctx context.Context, v *T, errs []ErrorMessage, data []byte, attrs map[string]string) {
+	if c.Reject != nil {
+		c.Reject(ctx, v, errs, data)
 	}
-	return fmt.Errorf("after %d attempts, last error: %s", attempts, err)
+}
+
+// handleError acks the message after it is passed to HandleError, or nacks it to be redelivered if there is no HandleError or HandleError nacks it
+func (c *Handler[T]) handleError(ctx context.Context, data []byte) {
+	if c.HandleError != nil && Handled(ctx, func(ctx context.Context) {
+		c.HandleError(ctx, data)
+	}) {
+		Ack(ctx, c.LogError)
+	} else {
+		Nack(ctx, c.LogError)
+	}
+}
+
+// Retry waits sleeps[i] before the (i+1)th call of f, and stops when f succeeds or ctx is done
+func Retry(ctx context.Context, sleeps []time.Duration, f func() error, log func(context.Context, string)) (err error) {
+	return RetryWithPolicy(ctx, Durations(sleeps), f, nil, log)
 }
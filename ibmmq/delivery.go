@@ -0,0 +1,46 @@
+package ibmmq
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/core-go/mq"
+)
+
+// Delivery records the settlement of a message which is got under syncpoint. It does not commit or back out the unit of work,
+// because the handler may settle the message in another goroutine. Subscriber waits for the settlement, then commits the unit of work on Ack,
+// or backs it out on Nack, so that the message is put back to the queue, before the next message is got.
+type Delivery struct {
+	settled chan bool
+	once    sync.Once
+}
+
+func NewDelivery() *Delivery {
+	return &Delivery{settled: make(chan bool, 1)}
+}
+func (d *Delivery) Ack(ctx context.Context) error {
+	d.settle(true)
+	return nil
+}
+func (d *Delivery) Nack(ctx context.Context) error {
+	d.settle(false)
+	return nil
+}
+func (d *Delivery) Requeue(ctx context.Context, delay time.Duration) error {
+	if delay > 0 {
+		return mq.ErrRequeueNotSupported
+	}
+	d.settle(false)
+	return nil
+}
+
+// Settled returns the channel, which receives true if the message is acked, or false if it is nacked
+func (d *Delivery) Settled() <-chan bool {
+	return d.settled
+}
+func (d *Delivery) settle(ack bool) {
+	d.once.Do(func() {
+		d.settled <- ack
+	})
+}
@@ -3,6 +3,7 @@ package ibmmq
 import (
 	"context"
 	"fmt"
+	"github.com/core-go/mq"
 	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
 )
 
@@ -13,6 +14,7 @@ type SubscriberConfig struct {
 	QueueName      string `yaml:"queue_name" mapstructure:"queue_name" json:"queueName,omitempty" gorm:"column:queuename" bson:"queueName,omitempty" dynamodbav:"queueName,omitempty" firestore:"queueName,omitempty"`
 	WaitInterval   int32  `yaml:"wait_interval" mapstructure:"wait_interval" json:"waitInterval,omitempty" gorm:"column:waitinterval" bson:"waitInterval,omitempty" dynamodbav:"waitInterval,omitempty" firestore:"waitInterval,omitempty"`
 	Topic          string `yaml:"topic" mapstructure:"topic" json:"topic,omitempty" gorm:"column:topic" bson:"topic,omitempty" dynamodbav:"topic,omitempty" firestore:"topic,omitempty"`
+	Syncpoint      bool   `yaml:"syncpoint" mapstructure:"syncpoint" json:"syncpoint,omitempty" gorm:"column:syncpoint" bson:"syncpoint,omitempty" dynamodbav:"syncpoint,omitempty" firestore:"syncpoint,omitempty"`
 }
 
 type Subscriber struct {
@@ -20,7 +22,9 @@ type Subscriber struct {
 	QueueName    string
 	WaitInterval int32
 	Topic        string
+	Syncpoint    bool // get the message under syncpoint, put the Delivery into the context of handle, and wait for its settlement before the next message is got
 	LogError     func(context.Context, string)
+	canceler     mq.Canceler
 }
 
 func NewSubscriberByConfig(c SubscriberConfig, auth MQAuth, logError func(context.Context, string)) (*Subscriber, error) {
@@ -34,7 +38,9 @@ func NewSubscriberByConfig(c SubscriberConfig, auth MQAuth, logError func(contex
 	if err != nil {
 		return nil, err
 	}
-	return NewSubscriber(mgr, c.QueueName, c.Topic, c.WaitInterval, logError), nil
+	s := NewSubscriber(mgr, c.QueueName, c.Topic, c.WaitInterval, logError)
+	s.Syncpoint = c.Syncpoint
+	return s, nil
 }
 func NewSubscriber(mgr *ibmmq.MQQueueManager, topic string, queueName string, waitInterval int32, logError func(context.Context, string)) *Subscriber {
 	return NewSubscriberByMQSD(mgr, queueName, topic, waitInterval, logError)
@@ -49,7 +55,10 @@ func NewSubscriberByMQSD(manager *ibmmq.MQQueueManager, queueName string, topic
 	}
 }
 
+// Subscribe reads the messages until ctx is done, Close is called or the queue cannot be read.
+// If Syncpoint is true, the handler must ack or nack each message, such as by Handler, and the next message is got only after that.
 func (c *Subscriber) Subscribe(ctx context.Context, handle func(context.Context, []byte)) {
+	handle = mq.Recover(handle, c.LogError)
 	// The qObject is filled in with a reference to the queue created automatically
 	// for publications. It will be used in a moment for the Get operations
 	md := ibmmq.NewMQOD()
@@ -68,35 +77,74 @@ func (c *Subscriber) Subscribe(ctx context.Context, handle func(context.Context,
 		defer qObject.Close(0)
 	}
 
-	for {
-		msgAvail := true
-		for msgAvail == true && err == nil {
-			mqmd := ibmmq.NewMQMD()
-			// The GET requires control structures, the Message Descriptor (MQMD)
-			// and Get Options (MQGMO). Create those with default values.
-			gmo := ibmmq.NewMQGMO()
-			// The default options are OK, but it's always
-			// a good idea to be explicit about transactional boundaries as
-			// not all platforms behave the same way.
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	for ctx2.Err() == nil {
+		mqmd := ibmmq.NewMQMD()
+		// The GET requires control structures, the Message Descriptor (MQMD)
+		// and Get Options (MQGMO). Create those with default values.
+		gmo := ibmmq.NewMQGMO()
+		// The default options are OK, but it's always
+		// a good idea to be explicit about transactional boundaries as
+		// not all platforms behave the same way.
+		if c.Syncpoint {
+			gmo.Options = ibmmq.MQGMO_SYNCPOINT
+		} else {
 			gmo.Options = ibmmq.MQGMO_NO_SYNCPOINT
-			// Set options to wait for a maximum of 3 seconds for any new message to arrive
-			gmo.Options |= ibmmq.MQGMO_WAIT
-			gmo.WaitInterval = c.WaitInterval // The WaitInterval is in milliseconds
-			buffer := make([]byte, 0, 1024)
-			buffer, _, err = qObject.GetSlice(mqmd, gmo, buffer)
+		}
+		// Set options to wait for a maximum of WaitInterval for any new message to arrive, then check ctx again
+		gmo.Options |= ibmmq.MQGMO_WAIT
+		gmo.WaitInterval = c.WaitInterval // The WaitInterval is in milliseconds
+		buffer := make([]byte, 0, 1024)
+		buffer, _, err = qObject.GetSlice(mqmd, gmo, buffer)
 
-			if err != nil {
-				msgAvail = false
-				mqReturn := err.(*ibmmq.MQReturn)
-				if mqReturn.MQRC != ibmmq.MQRC_NO_MSG_AVAILABLE {
-					c.LogError(ctx, "Error when subscribe: "+err.Error())
-				} else {
-					err = nil
-				}
-			} else {
-				msgAvail = true
-				handle(ctx, buffer)
+		if err != nil {
+			mqReturn, ok := err.(*ibmmq.MQReturn)
+			if ok && mqReturn.MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
+				continue
+			}
+			// the queue cannot be read any more, such as the connection is broken
+			if c.LogError != nil {
+				c.LogError(ctx, "Error when subscribe: "+err.Error())
+			}
+			return
+		}
+		if c.Syncpoint {
+			delivery := NewDelivery()
+			handle(mq.WithDelivery(ctx, delivery), buffer)
+			if !c.settle(ctx, ctx2, delivery) {
+				return
 			}
+		} else {
+			handle(ctx, buffer)
 		}
 	}
 }
+
+// settle waits until the message is acked or nacked, even by another goroutine, then commits or backs out the unit of work.
+// If ctx2 is done before that, the unit of work is backed out, and settle returns false, so the subscribing loop stops.
+func (c *Subscriber) settle(ctx context.Context, ctx2 context.Context, delivery *Delivery) bool {
+	var err error
+	ok := true
+	select {
+	case ack := <-delivery.Settled():
+		if ack {
+			err = c.QueueManager.Cmit()
+		} else {
+			err = c.QueueManager.Back()
+		}
+	case <-ctx2.Done():
+		ok = false
+		err = c.QueueManager.Back()
+	}
+	if err != nil && c.LogError != nil {
+		c.LogError(ctx, "Error when settle: "+err.Error())
+	}
+	return ok
+}
+
+// Close stops the subscribing loop, and disconnects from the queue manager
+func (c *Subscriber) Close() error {
+	c.canceler.Cancel()
+	return c.QueueManager.Disc()
+}
@@ -0,0 +1,109 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/core-go/mq"
+	"github.com/segmentio/kafka-go"
+)
+
+// ReadBatch reads the messages until ctx is done or Close is called, and passes them to handle in batches, such as BatchWorker.HandleBatch.
+// A batch has up to batchSize messages, and is passed to handle when it is full, or after timeout since its first message is fetched.
+// After handle returns, the highest contiguous acked offset of each partition is committed, so the offsets are never committed before the messages are processed.
+// Kafka does not redeliver a message in a live session, so when a message is not acked, the error is logged and ReadBatch returns,
+// and the messages after the last committed offset are received again when the reader restarts.
+// If AckOnConsume is true, the offsets of the batch are committed before handle.
+func (c *Reader) ReadBatch(ctx context.Context, batchSize int, timeout time.Duration, handle func(context.Context, []mq.RawMessage)) {
+	handle = mq.Recover(handle, c.LogError)
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	ctx = mq.WithPauser(ctx, c)
+	for {
+		if c.gate.Wait(ctx2) != nil {
+			return
+		}
+		msgs, stop := c.fetchBatch(ctx2, batchSize, timeout)
+		if len(msgs) > 0 && !c.handleBatch(ctx, msgs, handle) {
+			return
+		}
+		if stop {
+			return
+		}
+	}
+}
+
+// fetchBatch fetches up to batchSize messages, and waits for timeout after the first message. It returns true if the reading loop should stop.
+func (c *Reader) fetchBatch(ctx context.Context, batchSize int, timeout time.Duration) ([]kafka.Message, bool) {
+	msgs := make([]kafka.Message, 0, batchSize)
+	var deadline time.Time
+	for len(msgs) < batchSize {
+		msg, err := c.fetch(ctx, deadline)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return msgs, true
+			}
+			if !deadline.IsZero() && !time.Now().Before(deadline) {
+				return msgs, false
+			}
+			c.LogError(ctx, "Error when read: "+err.Error())
+			continue
+		}
+		if len(msgs) == 0 && timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, false
+}
+func (c *Reader) fetch(ctx context.Context, deadline time.Time) (kafka.Message, error) {
+	if deadline.IsZero() {
+		return c.Reader.FetchMessage(ctx)
+	}
+	ctx2, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	return c.Reader.FetchMessage(ctx2)
+}
+
+// handleBatch handles the batch, and commits the acked offsets. It returns false if a message is not acked, so the reading loop should stop.
+func (c *Reader) handleBatch(ctx context.Context, msgs []kafka.Message, handle func(context.Context, []mq.RawMessage)) bool {
+	batch := make([]mq.RawMessage, len(msgs))
+	deliveries := make([]*mq.OffsetDelivery, len(msgs))
+	for i, msg := range msgs {
+		partition := Partition(msg)
+		c.tracker.Received(partition, msg.Offset)
+		deliveries[i] = mq.NewOffsetDelivery(partition, msg.Offset)
+		batch[i] = mq.RawMessage{Data: msg.Value, Attributes: HeaderToMap(msg.Headers), Delivery: deliveries[i]}
+	}
+	if c.AckOnConsume {
+		if err := c.Reader.CommitMessages(ctx, msgs...); err != nil {
+			c.LogError(ctx, "Error when commit: "+err.Error())
+		}
+		handle(ctx, batch)
+		return true
+	}
+	handle(ctx, batch)
+	indices := c.tracker.Commits(deliveries)
+	if len(indices) > 0 {
+		commits := make([]kafka.Message, len(indices))
+		for i, j := range indices {
+			commits[i] = msgs[j]
+		}
+		if err := c.Reader.CommitMessages(ctx, commits...); err != nil {
+			c.LogError(ctx, "Error when commit: "+err.Error())
+		}
+	}
+	for i, d := range deliveries {
+		if !d.Acked() {
+			c.LogError(ctx, fmt.Sprintf("Message at offset %d of %s is not acked. Stop reading, so that it is received again when the reader restarts", msgs[i].Offset, d.Partition))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,12 @@
+package kafka
+
+import (
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Partition is the key of the partition of the message in mq.OffsetTracker and mq.OffsetCommitter
+func Partition(msg kafka.Message) string {
+	return msg.Topic + ":" + strconv.Itoa(msg.Partition)
+}
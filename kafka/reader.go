@@ -3,16 +3,24 @@ package kafka
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/core-go/mq"
 	"github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/sasl/scram"
+	"io"
 	"time"
 )
 
 type Reader struct {
 	Reader       *kafka.Reader
 	LogError     func(ctx context.Context, msg string)
+	HandleError  func(context.Context, []byte, map[string]string)
 	AckOnConsume bool
 	Key          string
+	canceler     mq.Canceler
+	tracker      mq.OffsetTracker
+	gate         mq.Gate
 }
 
 func NewReader(reader *kafka.Reader, logError func(ctx context.Context, msg string), ackOnConsume bool, key string) (*Reader, error) {
@@ -32,52 +40,135 @@ func NewReaderByConfig(c ReaderConfig, logError func(ctx context.Context, msg st
 	return NewReader(reader, logError, ackOnConsume, c.Key)
 }
 
+// Read reads the messages until ctx is done or Close is called.
+// If AckOnConsume is false, the offsets are committed by mq.OffsetCommitter: the acks only record the state of the messages,
+// and each partition is committed up to its highest contiguous acked offset, so the handler can ack out of order, such as by Goroutines or WorkerPool.
+// Kafka does not redeliver a message in a live session, so when a message is nacked, the error is logged and Read returns,
+// and the messages after the last committed offset are received again when the reader restarts.
 func (c *Reader) Read(ctx context.Context, handle func(context.Context, []byte, map[string]string)) {
+	handle = mq.RecoverWithMap(handle, c.LogError, c.HandleError)
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	ctx2, cancel := context.WithCancel(ctx2)
+	defer cancel()
+	committer := c.newCommitter(cancel)
+	ctx = mq.WithPauser(ctx, c)
 	for {
-		msg, err := c.Reader.FetchMessage(ctx)
+		if c.gate.Wait(ctx2) != nil {
+			return
+		}
+		msg, err := c.Reader.FetchMessage(ctx2)
 		if err != nil {
+			if ctx2.Err() != nil || errors.Is(err, io.EOF) {
+				return
+			}
 			c.LogError(ctx, "Error when read: "+err.Error())
 		} else {
+			ctx := ctx
 			attributes := HeaderToMap(msg.Headers)
 			if len(c.Key) > 0 && msg.Key != nil {
 				ctx = context.WithValue(ctx, c.Key, string(msg.Key))
 			}
 			if c.AckOnConsume {
 				c.Reader.CommitMessages(ctx, msg)
+				handle(ctx, msg.Value, attributes)
+			} else {
+				handle(withDelivery(ctx, committer, msg), msg.Value, attributes)
 			}
-			handle(ctx, msg.Value, attributes)
 		}
 	}
 }
 func (c *Reader) ReadValue(ctx context.Context, handle func(context.Context, []byte)) {
+	handle = mq.Recover(handle, c.LogError)
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	ctx2, cancel := context.WithCancel(ctx2)
+	defer cancel()
+	committer := c.newCommitter(cancel)
+	ctx = mq.WithPauser(ctx, c)
 	for {
-		msg, err := c.Reader.FetchMessage(ctx)
+		if c.gate.Wait(ctx2) != nil {
+			return
+		}
+		msg, err := c.Reader.FetchMessage(ctx2)
 		if err != nil {
+			if ctx2.Err() != nil || errors.Is(err, io.EOF) {
+				return
+			}
 			c.LogError(ctx, "Error when read: "+err.Error())
 		} else {
+			ctx := ctx
 			if len(c.Key) > 0 && msg.Key != nil {
 				ctx = context.WithValue(ctx, c.Key, string(msg.Key))
 			}
 			if c.AckOnConsume {
 				c.Reader.CommitMessages(ctx, msg)
+				handle(ctx, msg.Value)
+			} else {
+				handle(withDelivery(ctx, committer, msg), msg.Value)
 			}
-			handle(ctx, msg.Value)
 		}
 	}
 }
 func (c *Reader) ReadMessage(ctx context.Context, handle func(context.Context, kafka.Message)) {
+	handle = mq.Recover(handle, c.LogError)
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	ctx2, cancel := context.WithCancel(ctx2)
+	defer cancel()
+	committer := c.newCommitter(cancel)
+	ctx = mq.WithPauser(ctx, c)
 	for {
-		msg, err := c.Reader.FetchMessage(ctx)
+		if c.gate.Wait(ctx2) != nil {
+			return
+		}
+		msg, err := c.Reader.FetchMessage(ctx2)
 		if err != nil {
+			if ctx2.Err() != nil || errors.Is(err, io.EOF) {
+				return
+			}
 			c.LogError(ctx, "Error when read: "+err.Error())
 		} else {
+			ctx := ctx
 			if len(c.Key) > 0 && msg.Key != nil {
 				ctx = context.WithValue(ctx, c.Key, string(msg.Key))
 			}
 			if c.AckOnConsume {
 				c.Reader.CommitMessages(ctx, msg)
+				handle(ctx, msg)
+			} else {
+				handle(withDelivery(ctx, committer, msg), msg)
 			}
-			handle(ctx, msg)
 		}
 	}
 }
+
+// Pause stops fetching the messages, after the message which is being handled. The reader still sends the heartbeats, so the partitions are not rebalanced.
+func (c *Reader) Pause() error {
+	return c.gate.Pause()
+}
+func (c *Reader) Resume() error {
+	return c.gate.Resume()
+}
+func (c *Reader) Paused() bool {
+	return c.gate.Paused()
+}
+
+// newCommitter returns the committer of a reading loop, which logs the error and cancels the loop when a message is nacked
+func (c *Reader) newCommitter(cancel context.CancelFunc) *mq.OffsetCommitter[kafka.Message] {
+	return mq.NewOffsetCommitter(func(ctx context.Context, msgs []kafka.Message) error {
+		return c.Reader.CommitMessages(ctx, msgs...)
+	}, func(ctx context.Context, msg kafka.Message) {
+		c.LogError(ctx, fmt.Sprintf("Message at offset %d of %s is nacked. Stop reading, so that it is received again when the reader restarts", msg.Offset, Partition(msg)))
+		cancel()
+	})
+}
+func withDelivery(ctx context.Context, committer *mq.OffsetCommitter[kafka.Message], msg kafka.Message) context.Context {
+	return mq.WithDelivery(ctx, committer.Track(Partition(msg), msg.Offset, msg))
+}
+
+// Close stops the reading loops, and closes the reader
+func (c *Reader) Close() error {
+	c.canceler.Cancel()
+	return c.Reader.Close()
+}
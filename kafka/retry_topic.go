@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/core-go/mq"
+	"github.com/segmentio/kafka-go"
+)
+
+// NewRetryTopics creates the retry topics of topic, which are written by writer. The writer must not have a topic, because the topic of each message is set.
+func NewRetryTopics(writer *kafka.Writer, topic string, delays []time.Duration, retryCountName string, logs ...func(context.Context, string)) *mq.RetryTopics {
+	send := func(ctx context.Context, topic string, data []byte, attributes map[string]string) error {
+		msg := kafka.Message{Topic: topic, Value: data, Headers: MapToHeader(attributes)}
+		return writer.WriteMessages(ctx, msg)
+	}
+	return mq.NewRetryTopics(send, topic, delays, retryCountName, logs...)
+}
+
+// RetryReader reads the retry topics with a reader for each tier, and waits until the time of mq.NotBeforeName of each message before handling it.
+// The delay of a tier is fixed, so the messages of a tier are due in order, and a message waits only for the earlier messages of its tier.
+type RetryReader struct {
+	Readers  []*Reader
+	LogError func(ctx context.Context, msg string)
+	canceler mq.Canceler
+}
+
+func NewRetryReader(readers []*Reader, logError func(ctx context.Context, msg string)) *RetryReader {
+	return &RetryReader{Readers: readers, LogError: logError}
+}
+
+// NewRetryReaderByConfig creates a reader for each topic of topics, such as RetryTopics.Topics(), with the config of c
+func NewRetryReaderByConfig(c ReaderConfig, topics []string, logError func(ctx context.Context, msg string)) (*RetryReader, error) {
+	readers := make([]*Reader, 0, len(topics))
+	for _, topic := range topics {
+		c2 := c
+		c2.Topic = topic
+		reader, err := NewReaderByConfig(c2, logError, false)
+		if err != nil {
+			for _, r := range readers {
+				r.Close()
+			}
+			return nil, err
+		}
+		readers = append(readers, reader)
+	}
+	return NewRetryReader(readers, logError), nil
+}
+
+// Read reads all tiers until ctx is done or Close is called. The messages of the retry topics are handled by handle, which is the handle of the original topic.
+func (c *RetryReader) Read(ctx context.Context, handle func(context.Context, []byte, map[string]string)) {
+	handle = mq.Chain(handle, mq.WaitNotBefore(c.LogError))
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	var wg sync.WaitGroup
+	for _, reader := range c.Readers {
+		wg.Add(1)
+		go func(reader *Reader) {
+			defer wg.Done()
+			reader.Read(ctx2, handle)
+		}(reader)
+	}
+	wg.Wait()
+}
+
+// Close stops the waiting and the reading loops, and closes the readers
+func (c *RetryReader) Close() error {
+	c.canceler.Cancel()
+	var err error
+	for _, reader := range c.Readers {
+		if er1 := reader.Close(); er1 != nil && err == nil {
+			err = er1
+		}
+	}
+	return err
+}
@@ -3,6 +3,7 @@ package kafka
 import (
 	"context"
 	"crypto/tls"
+	"github.com/core-go/mq"
 	"github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/sasl/scram"
 	"time"
@@ -50,6 +51,16 @@ func (p *Writer) Write(ctx context.Context, data []byte, attributes map[string]s
 		return err
 	}
 }
+
+// WriteWithDelay writes the message with the mq.NotBeforeName header, so the consumer of the retry topic waits until the delay passes, by mq.WaitNotBefore. It is the Send of mq.DelayedRetry.
+func (p *Writer) WriteWithDelay(ctx context.Context, data []byte, attributes map[string]string, delay time.Duration) error {
+	attrs := make(map[string]string, len(attributes)+1)
+	for k, v := range attributes {
+		attrs[k] = v
+	}
+	attrs[mq.NotBeforeName] = mq.NotBefore(delay)
+	return p.Write(ctx, data, attrs)
+}
 func (p *Writer) WriteValue(ctx context.Context, data []byte) error {
 	var err error
 	msg := kafka.Message{Value: data}
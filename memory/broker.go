@@ -0,0 +1,274 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Message is a message of the in-memory broker. Data and Attributes are copied when the message is published.
+type Message struct {
+	ID            string
+	Topic         string
+	Data          []byte
+	Attributes    map[string]string
+	Timestamp     time.Time
+	DeliveryCount int
+	readyAt       time.Time
+}
+
+// Broker keeps the messages of the topics in memory, for tests and local development.
+// A message of a topic is delivered to every consumer group of that topic, and to only one subscriber of each group, so a queue is a topic with one group.
+// A new group receives the messages from the beginning of the topic, as the kafka consumers with the oldest initial offset.
+// The unacked messages are redelivered after AckTimeout, if AckTimeout is greater than 0. The nacked messages are redelivered after NackDelay.
+type Broker struct {
+	AckTimeout time.Duration
+	NackDelay  time.Duration
+	mu         sync.Mutex
+	topics     map[string]*topic
+	sequence   int64
+}
+type topic struct {
+	messages []*Message
+	groups   map[string]*group
+}
+type group struct {
+	pending  []*Message
+	inFlight map[string]*inFlight
+	acked    []*Message
+	notify   chan struct{}
+}
+type inFlight struct {
+	message *Message
+	timer   *time.Timer
+}
+
+func NewBroker() *Broker {
+	return &Broker{topics: make(map[string]*topic)}
+}
+
+// Publish adds the message to the topic, and delivers it to the groups after delay
+func (b *Broker) Publish(topicName string, data []byte, attrs map[string]string, delay time.Duration) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sequence++
+	now := time.Now()
+	msg := &Message{ID: strconv.FormatInt(b.sequence, 10), Topic: topicName, Data: copyBytes(data), Attributes: copyMap(attrs), Timestamp: now, readyAt: now.Add(delay)}
+	t := b.getTopic(topicName)
+	t.messages = append(t.messages, msg)
+	for _, g := range t.groups {
+		g.push(copyMessage(msg))
+	}
+	return msg.ID
+}
+
+// Published returns the messages which are published to the topic
+func (b *Broker) Published(topicName string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[topicName]
+	if !ok {
+		return nil
+	}
+	return toMessages(t.messages)
+}
+
+// Acked returns the messages which are acked by the group
+func (b *Broker) Acked(topicName string, groupName string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return toMessages(b.getGroup(topicName, groupName).acked)
+}
+
+// Pending returns the number of the messages which are waiting to be delivered to the group, including the delayed messages
+func (b *Broker) Pending(topicName string, groupName string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.getGroup(topicName, groupName).pending)
+}
+
+// InFlight returns the number of the messages which are delivered to the group, but not acked or nacked
+func (b *Broker) InFlight(topicName string, groupName string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.getGroup(topicName, groupName).inFlight)
+}
+
+// WaitAcked waits until the group acks n messages, or ctx is done
+func (b *Broker) WaitAcked(ctx context.Context, topicName string, groupName string, n int) error {
+	for {
+		b.mu.Lock()
+		g := b.getGroup(topicName, groupName)
+		acked := len(g.acked)
+		notify := g.notify
+		b.mu.Unlock()
+		if acked >= n {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// Reset removes all topics and messages
+func (b *Broker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, t := range b.topics {
+		for _, g := range t.groups {
+			for _, f := range g.inFlight {
+				if f.timer != nil {
+					f.timer.Stop()
+				}
+			}
+			g.broadcast()
+		}
+	}
+	b.topics = make(map[string]*topic)
+}
+
+// next returns the next ready message of the group, and waits if there is no ready message
+func (b *Broker) next(ctx context.Context, topicName string, groupName string) (*Message, bool) {
+	for {
+		b.mu.Lock()
+		g := b.getGroup(topicName, groupName)
+		now := time.Now()
+		var wait time.Duration = -1
+		if len(g.pending) > 0 {
+			msg := g.pending[0]
+			if !msg.readyAt.After(now) {
+				g.pending = g.pending[1:]
+				msg.DeliveryCount++
+				f := &inFlight{message: msg}
+				if b.AckTimeout > 0 {
+					f.timer = time.AfterFunc(b.AckTimeout, func() {
+						b.settle(topicName, groupName, msg.ID, msg.DeliveryCount, false, 0)
+					})
+				}
+				g.inFlight[msg.ID] = f
+				b.mu.Unlock()
+				return copyMessage(msg), true
+			}
+			wait = msg.readyAt.Sub(now)
+		}
+		notify := g.notify
+		b.mu.Unlock()
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-notify:
+		case <-expired:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return nil, false
+		}
+	}
+}
+
+// settle acks the in-flight message, or requeues it after delay. It returns false if the message is not in flight, or is redelivered after AckTimeout.
+func (b *Broker) settle(topicName string, groupName string, id string, deliveryCount int, ack bool, delay time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.getGroup(topicName, groupName)
+	f, ok := g.inFlight[id]
+	if !ok || f.message.DeliveryCount != deliveryCount {
+		return false
+	}
+	delete(g.inFlight, id)
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	if ack {
+		g.acked = append(g.acked, f.message)
+		g.broadcast()
+	} else {
+		f.message.readyAt = time.Now().Add(delay)
+		g.push(f.message)
+	}
+	return true
+}
+func (b *Broker) getTopic(topicName string) *topic {
+	if b.topics == nil {
+		b.topics = make(map[string]*topic)
+	}
+	t, ok := b.topics[topicName]
+	if !ok {
+		t = &topic{groups: make(map[string]*group)}
+		b.topics[topicName] = t
+	}
+	return t
+}
+
+// getGroup returns the group of the topic. A new group receives all messages of the topic.
+func (b *Broker) getGroup(topicName string, groupName string) *group {
+	t := b.getTopic(topicName)
+	g, ok := t.groups[groupName]
+	if !ok {
+		g = &group{inFlight: make(map[string]*inFlight), notify: make(chan struct{})}
+		for _, msg := range t.messages {
+			g.push(copyMessage(msg))
+		}
+		t.groups[groupName] = g
+	}
+	return g
+}
+
+// push adds the message to the pending messages, which are sorted by the ready time, then wakes up the subscribers
+func (g *group) push(msg *Message) {
+	i := sort.Search(len(g.pending), func(i int) bool {
+		return g.pending[i].readyAt.After(msg.readyAt)
+	})
+	g.pending = append(g.pending, nil)
+	copy(g.pending[i+1:], g.pending[i:])
+	g.pending[i] = msg
+	g.broadcast()
+}
+func (g *group) broadcast() {
+	close(g.notify)
+	g.notify = make(chan struct{})
+}
+
+func copyMessage(msg *Message) *Message {
+	m := *msg
+	m.Data = copyBytes(msg.Data)
+	m.Attributes = copyMap(msg.Attributes)
+	return &m
+}
+func toMessages(messages []*Message) []Message {
+	result := make([]Message, len(messages))
+	for i, msg := range messages {
+		result[i] = *copyMessage(msg)
+	}
+	return result
+}
+func copyBytes(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	b := make([]byte, len(data))
+	copy(b, data)
+	return b
+}
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
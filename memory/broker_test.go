@@ -0,0 +1,218 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/core-go/mq"
+)
+
+// receive returns the next message of the group, or nil if there is no ready message within wait
+func receive(b *Broker, topic string, group string, wait time.Duration) *Message {
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	msg, ok := b.next(ctx, topic, group)
+	if !ok {
+		return nil
+	}
+	return msg
+}
+
+func checkCounts(t *testing.T, b *Broker, topic string, group string, acked int, pending int, inFlight int) {
+	t.Helper()
+	if n := len(b.Acked(topic, group)); n != acked {
+		t.Errorf("%s: Acked = %d, want %d", group, n, acked)
+	}
+	if n := b.Pending(topic, group); n != pending {
+		t.Errorf("%s: Pending = %d, want %d", group, n, pending)
+	}
+	if n := b.InFlight(topic, group); n != inFlight {
+		t.Errorf("%s: InFlight = %d, want %d", group, n, inFlight)
+	}
+}
+
+func TestBrokerFanOut(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker()
+	b.Publish("orders", []byte("1"), nil, 0)
+	checkCounts(t, b, "orders", "billing", 0, 1, 0)
+	b.Publish("orders", []byte("2"), nil, 0)
+	for _, group := range []string{"billing", "shipping"} {
+		for i := 0; i < 2; i++ {
+			msg := receive(b, "orders", group, time.Second)
+			if msg == nil {
+				t.Fatalf("%s: message %d is not delivered", group, i+1)
+			}
+			if err := NewDelivery(b, "orders", group, msg).Ack(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkCounts(t, b, "orders", group, 2, 0, 0)
+	}
+	if n := len(b.Published("orders")); n != 2 {
+		t.Errorf("Published = %d, want 2", n)
+	}
+}
+
+func TestBrokerSharedGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	b := NewBroker()
+	var mu sync.Mutex
+	received := make(map[string]int)
+	subscribers := []*Subscriber{NewSubscriber(b, "orders", "billing", false, nil), NewSubscriber(b, "orders", "billing", false, nil)}
+	for _, s := range subscribers {
+		go s.SubscribeMessage(ctx, func(ctx context.Context, msg *Message) {
+			mu.Lock()
+			received[msg.ID]++
+			mu.Unlock()
+			mq.Ack(ctx, nil)
+		})
+	}
+	for i := 0; i < 20; i++ {
+		b.Publish("orders", []byte("order"), nil, 0)
+	}
+	if err := b.WaitAcked(ctx, "orders", "billing", 20); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range subscribers {
+		s.Close()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 20 {
+		t.Errorf("received %d messages, want 20", len(received))
+	}
+	for id, n := range received {
+		if n != 1 {
+			t.Errorf("message %s is received %d times, want once in the group", id, n)
+		}
+	}
+	checkCounts(t, b, "orders", "billing", 20, 0, 0)
+}
+
+func TestBrokerNackDelay(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker()
+	b.NackDelay = 50 * time.Millisecond
+	b.Publish("orders", []byte("1"), nil, 0)
+	msg := receive(b, "orders", "billing", time.Second)
+	checkCounts(t, b, "orders", "billing", 0, 0, 1)
+	start := time.Now()
+	if err := NewDelivery(b, "orders", "billing", msg).Nack(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(t, b, "orders", "billing", 0, 1, 0)
+	if m := receive(b, "orders", "billing", 10*time.Millisecond); m != nil {
+		t.Fatal("the nacked message is redelivered before NackDelay")
+	}
+	m := receive(b, "orders", "billing", time.Second)
+	if m == nil {
+		t.Fatal("the nacked message is not redelivered")
+	}
+	if elapsed := time.Since(start); elapsed < b.NackDelay {
+		t.Errorf("redelivered after %s, want after %s", elapsed, b.NackDelay)
+	}
+	if m.ID != msg.ID || m.DeliveryCount != 2 {
+		t.Errorf("redelivered %s with DeliveryCount %d, want %s with 2", m.ID, m.DeliveryCount, msg.ID)
+	}
+	NewDelivery(b, "orders", "billing", m).Ack(ctx)
+	checkCounts(t, b, "orders", "billing", 1, 0, 0)
+}
+
+func TestBrokerAckTimeout(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker()
+	b.AckTimeout = 20 * time.Millisecond
+	b.Publish("orders", []byte("1"), nil, 0)
+	stale := NewDelivery(b, "orders", "billing", receive(b, "orders", "billing", time.Second))
+	checkCounts(t, b, "orders", "billing", 0, 0, 1)
+	m := receive(b, "orders", "billing", time.Second)
+	if m == nil {
+		t.Fatal("the unacked message is not redelivered after AckTimeout")
+	}
+	if m.DeliveryCount != 2 {
+		t.Errorf("DeliveryCount = %d, want 2", m.DeliveryCount)
+	}
+	if err := stale.Ack(ctx); !errors.Is(err, ErrNotInFlight) {
+		t.Errorf("Ack of the first delivery = %v, want %v", err, ErrNotInFlight)
+	}
+	checkCounts(t, b, "orders", "billing", 0, 0, 1)
+	if err := NewDelivery(b, "orders", "billing", m).Ack(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(t, b, "orders", "billing", 1, 0, 0)
+	if err := NewDelivery(b, "orders", "billing", m).Nack(ctx); !errors.Is(err, ErrNotInFlight) {
+		t.Errorf("Nack after Ack = %v, want %v", err, ErrNotInFlight)
+	}
+}
+
+func TestBrokerDelayedPublish(t *testing.T) {
+	b := NewBroker()
+	p := NewPublisher(b, "orders")
+	start := time.Now()
+	p.PublishWithDelay(context.Background(), 50*time.Millisecond, []byte("1"), nil)
+	checkCounts(t, b, "orders", "billing", 0, 1, 0)
+	if m := receive(b, "orders", "billing", 10*time.Millisecond); m != nil {
+		t.Fatal("the delayed message is delivered before the delay")
+	}
+	if m := receive(b, "orders", "billing", time.Second); m == nil {
+		t.Fatal("the delayed message is not delivered")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("delivered after %s, want after 50ms", elapsed)
+	}
+	checkCounts(t, b, "orders", "billing", 0, 0, 1)
+}
+
+type order struct {
+	ID string `json:"id"`
+}
+
+// TestHandlerToSubscriber checks that a message which cannot be written is nacked by Handler, redelivered by the broker, then acked after it is written
+func TestHandlerToSubscriber(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	b := NewBroker()
+	var mu sync.Mutex
+	written := make(map[string]int)
+	attempts := 0
+	handler := mq.NewHandlerWithKey[order](nil, func(ctx context.Context, o *order) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			return errors.New("database is down")
+		}
+		written[o.ID]++
+		return nil
+	}, nil, nil, nil, nil, false, "")
+	s := NewSubscriber(b, "orders", "billing", false, nil)
+	go s.Subscribe(ctx, handler.HandleWithMap)
+	defer s.Close()
+
+	p := NewPublisher(b, "orders")
+	p.Publish(ctx, []byte(`{"id":"1"}`), nil)
+	p.Publish(ctx, []byte(`{"id":"2"}`), nil)
+	if err := b.WaitAcked(ctx, "orders", "billing", 2); err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(t, b, "orders", "billing", 2, 0, 0)
+	mu.Lock()
+	defer mu.Unlock()
+	if written["1"] != 1 || written["2"] != 1 || attempts != 3 {
+		t.Errorf("written = %v after %d attempts, want each order once after 3 attempts", written, attempts)
+	}
+	var redelivered int
+	for _, m := range b.Acked("orders", "billing") {
+		if m.DeliveryCount == 2 {
+			redelivered++
+		}
+	}
+	if redelivered != 1 {
+		t.Errorf("%d messages are redelivered, want 1", redelivered)
+	}
+}
@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrNotInFlight = errors.New("message is not in flight")
+
+// Delivery acks the message of the group, or requeues it to be redelivered
+type Delivery struct {
+	Broker  *Broker
+	Topic   string
+	Group   string
+	Message *Message
+}
+
+func NewDelivery(broker *Broker, topic string, group string, msg *Message) *Delivery {
+	return &Delivery{Broker: broker, Topic: topic, Group: group, Message: msg}
+}
+func (d *Delivery) Ack(ctx context.Context) error {
+	return d.settle(true, 0)
+}
+
+// Nack requeues the message after the NackDelay of the broker
+func (d *Delivery) Nack(ctx context.Context) error {
+	return d.settle(false, d.Broker.NackDelay)
+}
+func (d *Delivery) Requeue(ctx context.Context, delay time.Duration) error {
+	return d.settle(false, delay)
+}
+func (d *Delivery) settle(ack bool, delay time.Duration) error {
+	if !d.Broker.settle(d.Topic, d.Group, d.Message.ID, d.Message.DeliveryCount, ack, delay) {
+		return ErrNotInFlight
+	}
+	return nil
+}
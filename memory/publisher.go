@@ -0,0 +1,31 @@
+package memory
+
+import (
+	"context"
+	"time"
+)
+
+// Publisher publishes the messages to a topic of the broker. If Delay is greater than 0, the messages are delivered after Delay.
+type Publisher struct {
+	Broker *Broker
+	Topic  string
+	Delay  time.Duration
+}
+
+func NewPublisher(broker *Broker, topic string, options ...time.Duration) *Publisher {
+	p := &Publisher{Broker: broker, Topic: topic}
+	if len(options) >= 1 {
+		p.Delay = options[0]
+	}
+	return p
+}
+func (p *Publisher) Publish(ctx context.Context, data []byte, attributes map[string]string) error {
+	p.Broker.Publish(p.Topic, data, attributes, p.Delay)
+	return nil
+}
+
+// PublishWithDelay publishes the message, which is delivered after delay
+func (p *Publisher) PublishWithDelay(ctx context.Context, delay time.Duration, data []byte, attributes map[string]string) error {
+	p.Broker.Publish(p.Topic, data, attributes, delay)
+	return nil
+}
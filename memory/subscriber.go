@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/core-go/mq"
+)
+
+// Subscriber receives the messages of a topic, as a member of Group. The subscribers of the same group share the messages.
+// If AckOnConsume is false, the Delivery is put into the context of handle, and the message is redelivered if it is nacked.
+// If ID is not empty, the message id is put into the context of handle, with ID as the key.
+type Subscriber struct {
+	Broker       *Broker
+	Topic        string
+	Group        string
+	AckOnConsume bool
+	ID           string
+	LogError     func(ctx context.Context, msg string)
+	HandleError  func(context.Context, []byte, map[string]string)
+	canceler     mq.Canceler
+}
+
+func NewSubscriber(broker *Broker, topic string, group string, ackOnConsume bool, logError func(ctx context.Context, msg string), options ...string) *Subscriber {
+	s := &Subscriber{Broker: broker, Topic: topic, Group: group, AckOnConsume: ackOnConsume, LogError: logError}
+	if len(options) >= 1 {
+		s.ID = options[0]
+	}
+	return s
+}
+
+// Subscribe handles the messages until ctx is done or Close is called
+func (c *Subscriber) Subscribe(ctx context.Context, handle func(context.Context, []byte, map[string]string)) {
+	handle = mq.RecoverWithMap(handle, c.LogError, c.HandleError)
+	c.subscribe(ctx, func(ctx context.Context, msg *Message) {
+		handle(ctx, msg.Data, msg.Attributes)
+	})
+}
+func (c *Subscriber) SubscribeData(ctx context.Context, handle func(context.Context, []byte)) {
+	handle = mq.Recover(handle, c.LogError)
+	c.subscribe(ctx, func(ctx context.Context, msg *Message) {
+		handle(ctx, msg.Data)
+	})
+}
+func (c *Subscriber) SubscribeMessage(ctx context.Context, handle func(context.Context, *Message)) {
+	handle = mq.Recover(handle, c.LogError)
+	c.subscribe(ctx, handle)
+}
+func (c *Subscriber) subscribe(ctx context.Context, handle func(context.Context, *Message)) {
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	for {
+		msg, ok := c.Broker.next(ctx2, c.Topic, c.Group)
+		if !ok {
+			return
+		}
+		ctx3 := ctx
+		if len(c.ID) > 0 {
+			ctx3 = context.WithValue(ctx3, c.ID, msg.ID)
+		}
+		delivery := NewDelivery(c.Broker, c.Topic, c.Group, msg)
+		if c.AckOnConsume {
+			if err := delivery.Ack(ctx3); err != nil && c.LogError != nil {
+				c.LogError(ctx3, "Cannot ack message: "+err.Error())
+			}
+		} else {
+			ctx3 = mq.WithDelivery(ctx3, delivery)
+		}
+		handle(ctx3, msg)
+	}
+}
+
+// Close stops the subscribing loops
+func (c *Subscriber) Close() error {
+	c.canceler.Cancel()
+	return nil
+}
@@ -0,0 +1,175 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+var ErrPayloadTooLarge = errors.New("payload is too large")
+
+// Middleware wraps the handle callback of the consumers, such as kafka Reader.Read, rabbitmq Consumer.Consume, sqs Receiver.Receive or pubsub Subscriber.Subscribe
+type Middleware func(func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string)
+
+// Chain wraps handle by the middlewares. The first middleware is the outermost, which receives the message first.
+func Chain(handle func(context.Context, []byte, map[string]string), middlewares ...Middleware) func(context.Context, []byte, map[string]string) {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] != nil {
+			handle = middlewares[i](handle)
+		}
+	}
+	return handle
+}
+
+// PanicError is the error of a recovered panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Safe calls f, and returns a PanicError if f panics, so that the panic is handled as other errors
+func Safe(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return f()
+}
+
+// Recover is used by the consumer loops and goroutines. It recovers the panic of handle, logs the panic value and the stack, then nacks the message, so that the loop continues with the next message.
+func Recover[M any](handle func(context.Context, M), logError func(context.Context, string)) func(context.Context, M) {
+	return func(ctx context.Context, msg M) {
+		defer NackOnPanic(ctx, nil, nil, logError)
+		handle(ctx, msg)
+	}
+}
+
+// NackOnPanic must be deferred. It recovers the panic, logs the panic value and the stack, then nacks the message of ctx.
+func NackOnPanic(ctx context.Context, data []byte, attrs map[string]string, logError func(context.Context, string)) {
+	if r := recover(); r != nil {
+		var msg string
+		if data != nil {
+			msg = GetLog(data, attrs)
+		}
+		logPanic(ctx, r, debug.Stack(), msg, logError)
+		Nack(ctx, logError)
+	}
+}
+
+// RecoverWithMap is used by the consumer loops. The message of a panic is passed to handleError, such as DLQ.HandleErrorWithMap, or nacked if there is no handleError. See Recovery.
+func RecoverWithMap(handle func(context.Context, []byte, map[string]string), logError func(context.Context, string), handleError ...func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string) {
+	var handleErr func(context.Context, []byte, map[string]string)
+	if len(handleError) > 0 {
+		handleErr = handleError[0]
+	}
+	return Recovery(logError, handleErr)(handle)
+}
+func logPanic(ctx context.Context, r interface{}, stack []byte, msg string, logError func(context.Context, string)) {
+	if logError == nil {
+		return
+	}
+	if len(msg) > 0 {
+		logError(ctx, fmt.Sprintf("Panic when handle message: %s Error: %v\n%s", msg, r, stack))
+	} else {
+		logError(ctx, fmt.Sprintf("Panic when handle message. Error: %v\n%s", r, stack))
+	}
+}
+
+// Recovery recovers the panic of handle, and logs the panic value and the stack.
+// The message is passed to handleError with the PanicError as the failure, then acked, or nacked if handleError is nil or handleError nacks it.
+func Recovery(logError func(context.Context, string), handleError func(context.Context, []byte, map[string]string)) Middleware {
+	return func(handle func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string) {
+		return func(ctx context.Context, data []byte, attrs map[string]string) {
+			defer func() {
+				if r := recover(); r != nil {
+					err := &PanicError{Value: r, Stack: debug.Stack()}
+					logPanic(ctx, r, err.Stack, GetLog(data, attrs), logError)
+					defer NackOnPanic(ctx, data, attrs, logError)
+					HandleErrorAndAck(WithFailure(ctx, err), data, attrs, handleError, logError)
+				}
+			}()
+			handle(ctx, data, attrs)
+		}
+	}
+}
+
+// Logging logs the received messages
+func Logging(logInfo func(context.Context, string)) Middleware {
+	return func(handle func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string) {
+		return func(ctx context.Context, data []byte, attrs map[string]string) {
+			if logInfo != nil {
+				logInfo(ctx, "Received message: "+GetLog(data, attrs))
+			}
+			handle(ctx, data, attrs)
+		}
+	}
+}
+
+// Timing passes the duration of handle to record. If the handler writes the messages in goroutines, the duration does not include writing.
+func Timing(record func(ctx context.Context, data []byte, attrs map[string]string, duration time.Duration)) Middleware {
+	return func(handle func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string) {
+		return func(ctx context.Context, data []byte, attrs map[string]string) {
+			start := time.Now()
+			handle(ctx, data, attrs)
+			record(ctx, data, attrs, time.Since(start))
+		}
+	}
+}
+
+// Timeout cancels the context of handle after timeout. The context is also canceled when handle returns, so the handler should not write the messages in goroutines.
+func Timeout(timeout time.Duration) Middleware {
+	return func(handle func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string) {
+		if timeout <= 0 {
+			return handle
+		}
+		return func(ctx context.Context, data []byte, attrs map[string]string) {
+			ctx2, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			handle(ctx2, data, attrs)
+		}
+	}
+}
+
+// AttributesToContext puts the attributes into the context, so that they can be read by GetString. keys maps the attribute names to the context keys.
+// For example, {"traceparent": "traceparent", "correlation-id": "correlationId"}.
+func AttributesToContext(keys map[string]string) Middleware {
+	return func(handle func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string) {
+		return func(ctx context.Context, data []byte, attrs map[string]string) {
+			for name, key := range keys {
+				if v, ok := attrs[name]; ok && len(v) > 0 {
+					ctx = context.WithValue(ctx, key, v)
+				}
+			}
+			handle(ctx, data, attrs)
+		}
+	}
+}
+
+// PayloadLimit does not handle the messages which are larger than limit bytes. They are passed to reject with ErrPayloadTooLarge as the failure, then acked.
+func PayloadLimit(limit int, reject func(context.Context, []byte, map[string]string), logError func(context.Context, string)) Middleware {
+	return func(handle func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string) {
+		if limit <= 0 {
+			return handle
+		}
+		return func(ctx context.Context, data []byte, attrs map[string]string) {
+			if len(data) <= limit {
+				handle(ctx, data, attrs)
+				return
+			}
+			if logError != nil {
+				logError(ctx, fmt.Sprintf("Message size %d is larger than %d. Attributes: %+v", len(data), limit, attrs))
+			}
+			if reject != nil {
+				reject(WithFailure(ctx, ErrPayloadTooLarge), data, attrs)
+			}
+			Ack(ctx, logError)
+		}
+	}
+}
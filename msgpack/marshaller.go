@@ -0,0 +1,16 @@
+package msgpack
+
+import "github.com/vmihailenco/msgpack/v5"
+
+type Marshaller struct {
+}
+
+func NewMarshaller() *Marshaller {
+	return &Marshaller{}
+}
+func (c *Marshaller) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+func (c *Marshaller) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
@@ -0,0 +1,38 @@
+package nats
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/core-go/mq"
+	"github.com/nats-io/nats.go"
+)
+
+const jetStreamAckPrefix = "$JS.ACK."
+
+// Delivery acks or naks a JetStream message. Core NATS messages have no acknowledgement, so they have no Delivery.
+type Delivery struct {
+	Msg *nats.Msg
+}
+
+func NewDelivery(msg *nats.Msg) *Delivery {
+	return &Delivery{Msg: msg}
+}
+func (d *Delivery) Ack(ctx context.Context) error {
+	return d.Msg.Ack(nats.Context(ctx))
+}
+func (d *Delivery) Nack(ctx context.Context) error {
+	return d.Msg.Nak(nats.Context(ctx))
+}
+func (d *Delivery) Requeue(ctx context.Context, delay time.Duration) error {
+	return d.Msg.NakWithDelay(delay, nats.Context(ctx))
+}
+
+// WithDelivery puts the Delivery into ctx if msg is a JetStream message
+func WithDelivery(ctx context.Context, msg *nats.Msg) context.Context {
+	if !strings.HasPrefix(msg.Reply, jetStreamAckPrefix) {
+		return ctx
+	}
+	return mq.WithDelivery(ctx, NewDelivery(msg))
+}
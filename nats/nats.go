@@ -1,11 +1,10 @@
 package nats
 
 import (
-	"fmt"
+	"context"
+	"github.com/core-go/mq"
 	"github.com/nats-io/nats.go"
 	"log"
-	"reflect"
-	"strconv"
 	"time"
 )
 
@@ -14,17 +13,7 @@ type ConnConfig struct {
 	Option nats.Option `yaml:"option" mapstructure:"option" json:"option,omitempty" gorm:"column:option" bson:"option,omitempty" dynamodbav:"option,omitempty" firestore:"option,omitempty"`
 	Retry  RetryConfig `yaml:"retry" mapstructure:"retry" json:"retry,omitempty" gorm:"column:retry" bson:"retry,omitempty" dynamodbav:"retry,omitempty" firestore:"retry,omitempty"`
 }
-type RetryConfig struct {
-	Retry1 int64 `yaml:"1" mapstructure:"1" json:"retry1,omitempty" gorm:"column:retry1" bson:"retry1,omitempty" dynamodbav:"retry1,omitempty" firestore:"retry1,omitempty"`
-	Retry2 int64 `yaml:"2" mapstructure:"2" json:"retry2,omitempty" gorm:"column:retry2" bson:"retry2,omitempty" dynamodbav:"retry2,omitempty" firestore:"retry2,omitempty"`
-	Retry3 int64 `yaml:"3" mapstructure:"3" json:"retry3,omitempty" gorm:"column:retry3" bson:"retry3,omitempty" dynamodbav:"retry3,omitempty" firestore:"retry3,omitempty"`
-	Retry4 int64 `yaml:"4" mapstructure:"4" json:"retry4,omitempty" gorm:"column:retry4" bson:"retry4,omitempty" dynamodbav:"retry4,omitempty" firestore:"retry4,omitempty"`
-	Retry5 int64 `yaml:"5" mapstructure:"5" json:"retry5,omitempty" gorm:"column:retry5" bson:"retry5,omitempty" dynamodbav:"retry5,omitempty" firestore:"retry5,omitempty"`
-	Retry6 int64 `yaml:"6" mapstructure:"6" json:"retry6,omitempty" gorm:"column:retry6" bson:"retry6,omitempty" dynamodbav:"retry6,omitempty" firestore:"retry6,omitempty"`
-	Retry7 int64 `yaml:"7" mapstructure:"7" json:"retry7,omitempty" gorm:"column:retry7" bson:"retry7,omitempty" dynamodbav:"retry7,omitempty" firestore:"retry7,omitempty"`
-	Retry8 int64 `yaml:"8" mapstructure:"8" json:"retry8,omitempty" gorm:"column:retry8" bson:"retry8,omitempty" dynamodbav:"retry8,omitempty" firestore:"retry8,omitempty"`
-	Retry9 int64 `yaml:"9" mapstructure:"9" json:"retry9,omitempty" gorm:"column:retry9" bson:"retry9,omitempty" dynamodbav:"retry9,omitempty" firestore:"retry9,omitempty"`
-}
+type RetryConfig = mq.RetryConfig
 
 func NewConn(retries []time.Duration, url string, options ...nats.Option) (*nats.Conn, error) {
 	if len(retries) == 0 {
@@ -50,44 +39,22 @@ func NewConn(retries []time.Duration, url string, options ...nats.Option) (*nats
 	}
 }
 func MakeDurations(vs []int64) []time.Duration {
-	durations := make([]time.Duration, 0)
-	for _, v := range vs {
-		d := time.Duration(v) * time.Second
-		durations = append(durations, d)
-	}
-	return durations
+	return mq.MakeDurations(vs)
 }
 func MakeArray(v interface{}, prefix string, max int) []int64 {
-	var ar []int64
-	v2 := reflect.Indirect(reflect.ValueOf(v))
-	for i := 1; i <= max; i++ {
-		fn := prefix + strconv.Itoa(i)
-		v3 := v2.FieldByName(fn).Interface().(int64)
-		if v3 > 0 {
-			ar = append(ar, v3)
-		} else {
-			return ar
-		}
-	}
-	return ar
+	return mq.MakeArray(v, prefix, max)
 }
 func DurationsFromValue(v interface{}, prefix string, max int) []time.Duration {
-	arr := MakeArray(v, prefix, max)
-	return MakeDurations(arr)
+	return mq.DurationsFromValue(v, prefix, max)
 }
 func Retry(sleeps []time.Duration, f func() error) (err error) {
-	attempts := len(sleeps)
-	for i := 0; ; i++ {
-		log.Printf("Retrying %d of %d ", i+1, attempts)
-		err = f()
-		if err == nil {
-			return
-		}
-		if i >= (attempts - 1) {
-			break
-		}
-		time.Sleep(sleeps[i])
-		log.Printf("Retrying %d of %d after error: %s", i+1, attempts, err.Error())
-	}
-	return fmt.Errorf("after %d attempts, last error: %s", attempts, err)
+	return RetryWithContext(context.Background(), sleeps, f)
+}
+
+// RetryWithContext calls f, waits sleeps[i] after each failure, and stops as soon as ctx is done
+func RetryWithContext(ctx context.Context, sleeps []time.Duration, f func() error) (err error) {
+	return mq.Attempt(ctx, sleeps, f, logRetry)
+}
+func logRetry(ctx context.Context, msg string) {
+	log.Println(msg)
 }
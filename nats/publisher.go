@@ -15,14 +15,17 @@ func NewPublisher(conn *nats.Conn, subject string) *Publisher {
 	return &Publisher{conn, subject}
 }
 func NewPublisherByConfig(p PublisherConfig) (*Publisher, error) {
-	if p.Connection.Retry.Retry1 <= 0 {
+	durations, err := p.Connection.Retry.Durations()
+	if err != nil {
+		return nil, err
+	}
+	if len(durations) == 0 {
 		conn, err := nats.Connect(p.Connection.Url, p.Connection.Option)
 		if err != nil {
 			return nil, err
 		}
 		return NewPublisher(conn, p.Subject), nil
 	} else {
-		durations := DurationsFromValue(p.Connection.Retry, "Retry", 9)
 		conn, err := NewConn(durations, p.Connection.Url, p.Connection.Option)
 		if err != nil {
 			return nil, err
@@ -13,14 +13,17 @@ func NewSubjectPublisher(conn *nats.Conn) *SubjectPublisher {
 	return &SubjectPublisher{conn}
 }
 func NewSubjectPublisherByConfig(p PublisherConfig) (*SubjectPublisher, error) {
-	if p.Connection.Retry.Retry1 <= 0 {
+	durations, err := p.Connection.Retry.Durations()
+	if err != nil {
+		return nil, err
+	}
+	if len(durations) == 0 {
 		conn, err := nats.Connect(p.Connection.Url, p.Connection.Option)
 		if err != nil {
 			return nil, err
 		}
 		return NewSubjectPublisher(conn), nil
 	} else {
-		durations := DurationsFromValue(p.Connection.Retry, "Retry", 9)
 		conn, err := NewConn(durations, p.Connection.Url, p.Connection.Option)
 		if err != nil {
 			return nil, err
@@ -2,58 +2,212 @@ package nats
 
 import (
 	"context"
+	"errors"
+	"github.com/core-go/mq"
 	"github.com/nats-io/nats.go"
 	"net/http"
-	"runtime"
+	"sync"
 )
 
 type Subscriber struct {
-	Conn     *nats.Conn
-	Subject  string
-	LogError func(ctx context.Context, msg string)
+	Conn        *nats.Conn
+	Subject     string
+	JetStream   nats.JetStreamContext
+	Stream      string
+	Durable     string
+	LogError    func(ctx context.Context, msg string)
+	HandleError func(context.Context, []byte, map[string]string)
+	canceler    mq.Canceler
+	gate        mq.Gate
+	mu          sync.Mutex
+	subs        map[*nats.Subscription]chan struct{}
 }
 
 func NewSubscriber(conn *nats.Conn, subject string, logError func(ctx context.Context, msg string)) *Subscriber {
-	return &Subscriber{conn, subject, logError}
+	return &Subscriber{Conn: conn, Subject: subject, LogError: logError}
 }
 
-func NewSubscriberByConfig(c SubscriberConfig, logError func(ctx context.Context, msg string)) (*Subscriber, error) {
-	if c.Connection.Retry.Retry1 <= 0 {
-		conn, err := nats.Connect(c.Connection.Url, c.Connection.Option)
+// NewJetStreamSubscriber subscribes the durable consumer of a JetStream stream, and creates the consumer if it does not exist.
+// The subscriptions are bound to the consumer, so that the consumer is not deleted when they are unsubscribed by Pause.
+// If stream is empty, it is the stream of the subject.
+func NewJetStreamSubscriber(conn *nats.Conn, subject string, stream string, durable string, logError func(ctx context.Context, msg string)) (*Subscriber, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	if len(stream) == 0 {
+		stream, err = js.StreamNameBySubject(subject)
 		if err != nil {
 			return nil, err
 		}
-		return NewSubscriber(conn, c.Subject, logError), nil
-	} else {
-		durations := DurationsFromValue(c.Connection.Retry, "Retry", 9)
-		conn, err := NewConn(durations, c.Connection.Url, c.Connection.Option)
+	}
+	if _, err = js.ConsumerInfo(stream, durable); err != nil {
+		if !errors.Is(err, nats.ErrConsumerNotFound) {
+			return nil, err
+		}
+		_, err = js.AddConsumer(stream, &nats.ConsumerConfig{Durable: durable, DeliverSubject: nats.NewInbox(), AckPolicy: nats.AckExplicitPolicy, FilterSubject: subject})
 		if err != nil {
 			return nil, err
 		}
-		return NewSubscriber(conn, c.Subject, logError), nil
 	}
+	return &Subscriber{Conn: conn, Subject: subject, JetStream: js, Stream: stream, Durable: durable, LogError: logError}, nil
+}
+
+func NewSubscriberByConfig(c SubscriberConfig, logError func(ctx context.Context, msg string)) (*Subscriber, error) {
+	durations, err := c.Connection.Retry.Durations()
+	if err != nil {
+		return nil, err
+	}
+	var conn *nats.Conn
+	if len(durations) == 0 {
+		conn, err = nats.Connect(c.Connection.Url, c.Connection.Option)
+	} else {
+		conn, err = NewConn(durations, c.Connection.Url, c.Connection.Option)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Durable) > 0 {
+		return NewJetStreamSubscriber(conn, c.Subject, c.Stream, c.Durable, logError)
+	}
+	return NewSubscriber(conn, c.Subject, logError), nil
 }
+
+// SubscribeMsg handles the messages until ctx is done or Close is called
 func (c *Subscriber) SubscribeMsg(ctx context.Context, handle func(context.Context, *nats.Msg)) {
-	c.Conn.Subscribe(c.Subject, func(msg *nats.Msg) {
-		handle(ctx, msg)
+	handle = mq.Recover(handle, c.LogError)
+	ctx = c.withPauser(ctx)
+	c.subscribe(ctx, func(msg *nats.Msg) {
+		handle(WithDelivery(ctx, msg), msg)
 	})
-	c.Conn.Flush()
-	runtime.Goexit()
 }
 func (c *Subscriber) SubscribeData(ctx context.Context, handle func(context.Context, []byte)) {
-	c.Conn.Subscribe(c.Subject, func(msg *nats.Msg) {
-		handle(ctx, msg.Data)
+	handle = mq.Recover(handle, c.LogError)
+	ctx = c.withPauser(ctx)
+	c.subscribe(ctx, func(msg *nats.Msg) {
+		handle(WithDelivery(ctx, msg), msg.Data)
 	})
-	c.Conn.Flush()
-	runtime.Goexit()
 }
 func (c *Subscriber) Subscribe(ctx context.Context, handle func(context.Context, []byte, map[string]string)) {
-	c.Conn.Subscribe(c.Subject, func(msg *nats.Msg) {
+	handle = mq.RecoverWithMap(handle, c.LogError, c.HandleError)
+	ctx = c.withPauser(ctx)
+	c.subscribe(ctx, func(msg *nats.Msg) {
 		attrs := HeaderToMap(http.Header(msg.Header))
-		handle(ctx, msg.Data, attrs)
+		handle(WithDelivery(ctx, msg), msg.Data, attrs)
 	})
-	c.Conn.Flush()
-	runtime.Goexit()
+}
+
+// withPauser puts the subscriber into ctx if it subscribes a durable consumer.
+// Core NATS subscribers are not put into ctx, so that the handlers wait inside the callback, instead of pausing them.
+func (c *Subscriber) withPauser(ctx context.Context) context.Context {
+	if len(c.Durable) == 0 {
+		return ctx
+	}
+	return mq.WithPauser(ctx, c)
+}
+
+// subscribe subscribes the subject until ctx is done or Close is called. When it is unsubscribed by Pause, it subscribes again after Resume.
+func (c *Subscriber) subscribe(ctx context.Context, handler nats.MsgHandler) {
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	for {
+		if c.gate.Wait(ctx2) != nil {
+			return
+		}
+		sub, unsubscribed, err := c.subscribeSubject(handler)
+		if err != nil {
+			c.LogError(ctx, "Error when subscribe: "+err.Error())
+			return
+		}
+		if sub == nil {
+			// paused before subscribing
+			continue
+		}
+		c.Conn.Flush()
+		select {
+		case <-unsubscribed:
+		case <-ctx2.Done():
+			if err = c.unsubscribe(sub); err != nil && err != nats.ErrConnectionClosed {
+				c.LogError(ctx, "Error when unsubscribe: "+err.Error())
+			}
+			return
+		}
+	}
+}
+
+// subscribeSubject subscribes the subject, or binds the durable consumer, if the subscriber is not paused
+func (c *Subscriber) subscribeSubject(handler nats.MsgHandler) (*nats.Subscription, chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gate.Paused() {
+		return nil, nil, nil
+	}
+	var sub *nats.Subscription
+	var err error
+	if len(c.Durable) == 0 {
+		sub, err = c.Conn.Subscribe(c.Subject, handler)
+	} else {
+		sub, err = c.JetStream.Subscribe(c.Subject, handler, nats.Bind(c.Stream, c.Durable), nats.ManualAck())
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.subs == nil {
+		c.subs = make(map[*nats.Subscription]chan struct{})
+	}
+	unsubscribed := make(chan struct{})
+	c.subs[sub] = unsubscribed
+	return sub, unsubscribed, nil
+}
+
+// unsubscribe unsubscribes sub, if it is not unsubscribed by Pause
+func (c *Subscriber) unsubscribe(sub *nats.Subscription) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subs[sub]; !ok {
+		return nil
+	}
+	delete(c.subs, sub)
+	return sub.Unsubscribe()
+}
+
+// Pause unsubscribes the durable consumer, so that the server keeps the messages until Resume. The messages which are not acked are redelivered after AckWait.
+// Pause returns mq.ErrPauseNotSupported for core NATS, which does not keep the messages for a subscriber, so the messages which are sent while it is unsubscribed are lost.
+func (c *Subscriber) Pause() error {
+	if len(c.Durable) == 0 {
+		return mq.ErrPauseNotSupported
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gate.Pause()
+	var err error
+	for sub, unsubscribed := range c.subs {
+		delete(c.subs, sub)
+		close(unsubscribed)
+		if er1 := sub.Unsubscribe(); er1 != nil {
+			err = er1
+		}
+	}
+	// the server removes the interest before the consumer is bound again by Resume
+	if er2 := c.Conn.Flush(); er2 != nil && err == nil {
+		err = er2
+	}
+	return err
+}
+
+// Resume subscribes the durable consumer again
+func (c *Subscriber) Resume() error {
+	return c.gate.Resume()
+}
+func (c *Subscriber) Paused() bool {
+	return c.gate.Paused()
+}
+
+// Close stops the subscriptions, and closes the connection
+func (c *Subscriber) Close() error {
+	c.canceler.Cancel()
+	c.Conn.Close()
+	return nil
 }
 
 func HeaderToMap(header http.Header) map[string]string {
@@ -2,5 +2,7 @@ package nats
 
 type SubscriberConfig struct {
 	Subject    string     `yaml:"subject" mapstructure:"subject" json:"subject,omitempty" gorm:"column:subject" bson:"subject,omitempty" dynamodbav:"subject,omitempty" firestore:"subject,omitempty"`
+	Stream     string     `yaml:"stream" mapstructure:"stream" json:"stream,omitempty" gorm:"column:stream" bson:"stream,omitempty" dynamodbav:"stream,omitempty" firestore:"stream,omitempty"`
+	Durable    string     `yaml:"durable" mapstructure:"durable" json:"durable,omitempty" gorm:"column:durable" bson:"durable,omitempty" dynamodbav:"durable,omitempty" firestore:"durable,omitempty"`
 	Connection ConnConfig `yaml:"connection" mapstructure:"connection" json:"connection,omitempty" gorm:"column:connection" bson:"connection,omitempty" dynamodbav:"connection,omitempty" firestore:"connection,omitempty"`
 }
@@ -0,0 +1,98 @@
+package mq
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// OffsetCommitter commits the offsets of the messages which are acked one by one, such as by Handler with Goroutines or WorkerPool, so the acks may arrive out of order.
+// Ack only records the state of the message. Then each partition is advanced to its highest contiguous acked offset, by the same rule as OffsetTracker.Commits,
+// and Commit is called with the last of the acked messages of each partition. The calls of Commit are serialized, so a lower offset is never committed after a higher one.
+// Kafka does not redeliver a message in a live session, so Nack calls Fail, which should log the error and stop the consumer.
+// The partition is not advanced past the nacked message, so it is received again when the consumer restarts.
+type OffsetCommitter[M any] struct {
+	Commit   func(context.Context, []M) error
+	Fail     func(context.Context, M)
+	mu       sync.Mutex
+	commitMu sync.Mutex
+	pending  map[string][]*OffsetMessage[M]
+}
+
+func NewOffsetCommitter[M any](commit func(context.Context, []M) error, fail func(context.Context, M)) *OffsetCommitter[M] {
+	return &OffsetCommitter[M]{Commit: commit, Fail: fail, pending: make(map[string][]*OffsetMessage[M])}
+}
+
+// Track adds the message, and returns its delivery. The messages of a partition must be tracked in the order of their offsets.
+func (c *OffsetCommitter[M]) Track(partition string, offset int64, msg M) *OffsetMessage[M] {
+	m := &OffsetMessage[M]{OffsetDelivery: OffsetDelivery{Partition: partition, Offset: offset}, Message: msg, committer: c}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = make(map[string][]*OffsetMessage[M])
+	}
+	c.pending[partition] = append(c.pending[partition], m)
+	return m
+}
+
+// Pending returns the number of the tracked messages which are not committed yet
+func (c *OffsetCommitter[M]) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, ms := range c.pending {
+		n += len(ms)
+	}
+	return n
+}
+
+// Advance removes the contiguous acked messages at the head of each partition, and commits the last of them
+func (c *OffsetCommitter[M]) Advance(ctx context.Context) error {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+	c.mu.Lock()
+	var commits []M
+	for p, ms := range c.pending {
+		k := lastAcked(len(ms), func(i int) *OffsetDelivery { return &ms[i].OffsetDelivery })
+		if k < 0 {
+			continue
+		}
+		commits = append(commits, ms[k].Message)
+		if k+1 < len(ms) {
+			c.pending[p] = ms[k+1:]
+		} else {
+			delete(c.pending, p)
+		}
+	}
+	c.mu.Unlock()
+	if len(commits) == 0 || c.Commit == nil {
+		return nil
+	}
+	return c.Commit(ctx, commits)
+}
+
+// OffsetMessage is the delivery of a message which is tracked by OffsetCommitter
+type OffsetMessage[M any] struct {
+	OffsetDelivery
+	Message   M
+	committer *OffsetCommitter[M]
+}
+
+func (m *OffsetMessage[M]) Ack(ctx context.Context) error {
+	m.OffsetDelivery.Ack(ctx)
+	return m.committer.Advance(ctx)
+}
+
+// Nack keeps the partition at the offset of the message, and calls Fail of the committer
+func (m *OffsetMessage[M]) Nack(ctx context.Context) error {
+	m.OffsetDelivery.Nack(ctx)
+	if m.committer.Fail != nil {
+		m.committer.Fail(ctx, m.Message)
+	}
+	return nil
+}
+
+// Requeue is not supported, because the offset of a partition cannot be requeued
+func (m *OffsetMessage[M]) Requeue(ctx context.Context, delay time.Duration) error {
+	return ErrRequeueNotSupported
+}
@@ -0,0 +1,65 @@
+package mq
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestOffsetCommitterOutOfOrder(t *testing.T) {
+	ctx := context.Background()
+	var commits [][]int64
+	c := NewOffsetCommitter(func(ctx context.Context, msgs []int64) error {
+		commits = append(commits, msgs)
+		return nil
+	}, nil)
+	ms := make([]*OffsetMessage[int64], 4)
+	for i := range ms {
+		ms[i] = c.Track("p", int64(i), int64(i))
+	}
+	ms[2].Ack(ctx)
+	ms[1].Ack(ctx)
+	if len(commits) != 0 {
+		t.Fatalf("commits = %v, want none before offset 0 is acked", commits)
+	}
+	ms[0].Ack(ctx)
+	ms[3].Ack(ctx)
+	if want := [][]int64{{2}, {3}}; !reflect.DeepEqual(commits, want) {
+		t.Errorf("commits = %v, want %v", commits, want)
+	}
+	if n := c.Pending(); n != 0 {
+		t.Errorf("Pending() = %d, want 0", n)
+	}
+}
+
+func TestOffsetCommitterNack(t *testing.T) {
+	ctx := context.Background()
+	var commits [][]int64
+	var failed []int64
+	c := NewOffsetCommitter(func(ctx context.Context, msgs []int64) error {
+		commits = append(commits, msgs)
+		return nil
+	}, func(ctx context.Context, msg int64) {
+		failed = append(failed, msg)
+	})
+	a0 := c.Track("a", 0, 0)
+	a1 := c.Track("a", 1, 1)
+	a2 := c.Track("a", 2, 2)
+	b0 := c.Track("b", 0, 10)
+	a0.Ack(ctx)
+	a1.Nack(ctx)
+	a2.Ack(ctx)
+	b0.Ack(ctx)
+	if want := [][]int64{{0}, {10}}; !reflect.DeepEqual(commits, want) {
+		t.Errorf("commits = %v, want %v", commits, want)
+	}
+	if want := []int64{1}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed = %v, want %v", failed, want)
+	}
+	if n := c.Pending(); n != 2 {
+		t.Errorf("Pending() = %d, want 2, the nacked message and the message after it", n)
+	}
+	if err := a1.Requeue(ctx, 0); err != ErrRequeueNotSupported {
+		t.Errorf("Requeue() = %v, want %v", err, ErrRequeueNotSupported)
+	}
+}
@@ -0,0 +1,83 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPauseNotSupported is returned by Pause of the consumers which cannot stop the broker sending the messages without losing them, such as core NATS
+var ErrPauseNotSupported = errors.New("pause is not supported")
+
+// Pauser stops and restarts the fetching of a consumer. The consumers put themselves into the context of the handle callback,
+// so that the handlers can pause them by GetPauser, such as CircuitBreaker when it opens, or BatchWorker when all batches are in flight.
+type Pauser interface {
+	Pause() error
+	Resume() error
+	Paused() bool
+}
+
+type pauserKey struct{}
+
+func WithPauser(ctx context.Context, pauser Pauser) context.Context {
+	if pauser == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, pauserKey{}, pauser)
+}
+func GetPauser(ctx context.Context) Pauser {
+	pauser, ok := ctx.Value(pauserKey{}).(Pauser)
+	if !ok {
+		return nil
+	}
+	return pauser
+}
+
+// Gate is used by the consumers to block their loops while they are paused. The zero value is resumed.
+type Gate struct {
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{}
+}
+
+func (g *Gate) Pause() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		g.paused = true
+		g.resumed = make(chan struct{})
+	}
+	return nil
+}
+func (g *Gate) Resume() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		g.paused = false
+		close(g.resumed)
+	}
+	return nil
+}
+func (g *Gate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// Wait waits until the gate is resumed, and returns ctx.Err() if ctx is done before that
+func (g *Gate) Wait(ctx context.Context) error {
+	for {
+		g.mu.Lock()
+		if !g.paused {
+			g.mu.Unlock()
+			return nil
+		}
+		resumed := g.resumed
+		g.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resumed:
+		}
+	}
+}
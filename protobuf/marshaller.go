@@ -0,0 +1,33 @@
+package protobuf
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type Marshaller struct {
+	Options proto.UnmarshalOptions
+}
+
+func NewMarshaller(options ...proto.UnmarshalOptions) *Marshaller {
+	c := &Marshaller{}
+	if len(options) > 0 {
+		c.Options = options[0]
+	}
+	return c
+}
+func (c *Marshaller) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T does not implement proto.Message", v)
+	}
+	return c.Options.Unmarshal(data, m)
+}
+func (c *Marshaller) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T does not implement proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
@@ -0,0 +1,34 @@
+package pubsub
+
+import (
+	"cloud.google.com/go/pubsub"
+	"context"
+	"time"
+)
+
+// Delivery acks or nacks the Pub/Sub message. The redelivery backoff of Nack is the retry policy of the subscription.
+type Delivery struct {
+	Message *pubsub.Message
+}
+
+func NewDelivery(msg *pubsub.Message) *Delivery {
+	return &Delivery{Message: msg}
+}
+func (d *Delivery) Ack(ctx context.Context) error {
+	d.Message.Ack()
+	return nil
+}
+func (d *Delivery) Nack(ctx context.Context) error {
+	d.Message.Nack()
+	return nil
+}
+
+// Requeue keeps the lease of the message for delay, then nacks it
+func (d *Delivery) Requeue(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		d.Message.Nack()
+		return nil
+	}
+	time.AfterFunc(delay, d.Message.Nack)
+	return nil
+}
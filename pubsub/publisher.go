@@ -27,14 +27,17 @@ func NewPublisher(ctx context.Context, client *pubsub.Client, topicId string, c
 }
 
 func NewPublisherByConfig(ctx context.Context, c PublisherConfig, options ...func(context.Context, []byte) ([]byte, error)) (*Publisher, error) {
-	if c.Retry.Retry1 <= 0 {
+	durations, err := c.Retry.Durations()
+	if err != nil {
+		return nil, err
+	}
+	if len(durations) == 0 {
 		client, err := NewPubSubClient(ctx, []byte(c.Client.Credentials), c.Client.ProjectId)
 		if err != nil {
 			return nil, err
 		}
 		return NewPublisher(ctx, client, c.TopicId, c.Topic, options...), nil
 	} else {
-		durations := DurationsFromValue(c.Retry, "Retry", 9)
 		client, err := NewPubSubClientWithRetries(ctx, []byte(c.Client.Credentials), durations, c.Client.ProjectId)
 		if err != nil {
 			return nil, err
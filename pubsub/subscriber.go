@@ -3,14 +3,22 @@ package pubsub
 import (
 	"cloud.google.com/go/pubsub"
 	"context"
+	"github.com/core-go/mq"
+	"strconv"
 )
 
+// If RetryCountName is not empty, the retry count of a redelivered message is set from the delivery attempt, which is counted by Pub/Sub when the subscription has a dead letter policy.
+// It is used with mq.DelayedRetry.Requeue, because the attributes of a nacked message cannot be changed.
 type Subscriber struct {
-	Client       *pubsub.Client
-	Subscription *pubsub.Subscription
-	LogError     func(ctx context.Context, msg string)
-	AckOnConsume bool
-	ID           string
+	Client         *pubsub.Client
+	Subscription   *pubsub.Subscription
+	LogError       func(ctx context.Context, msg string)
+	HandleError    func(context.Context, []byte, map[string]string)
+	AckOnConsume   bool
+	ID             string
+	RetryCountName string
+	canceler       mq.Canceler
+	gate           mq.Gate
 }
 
 func ConfigureSubscription(subscription *pubsub.Subscription, c SubscriptionConfig) *pubsub.Subscription {
@@ -28,14 +36,17 @@ func NewSubscriber(client *pubsub.Client, subscriptionId string, c SubscriptionC
 }
 
 func NewSubscriberByConfig(ctx context.Context, c SubscriberConfig, logError func(context.Context, string), ackOnConsume bool) (*Subscriber, error) {
-	if c.Retry.Retry1 <= 0 {
+	durations, err := c.Retry.Durations()
+	if err != nil {
+		return nil, err
+	}
+	if len(durations) == 0 {
 		client, err := NewPubSubClient(ctx, []byte(c.Client.Credentials), c.Client.ProjectId)
 		if err != nil {
 			return nil, err
 		}
 		return NewSubscriber(client, c.SubscriptionId, c.SubscriptionConfig, logError, ackOnConsume, ""), nil
 	} else {
-		durations := DurationsFromValue(c.Retry, "Retry", 9)
 		client, err := NewPubSubClientWithRetries(ctx, []byte(c.Client.Credentials), durations, c.Client.ProjectId)
 		if err != nil {
 			return nil, err
@@ -44,10 +55,21 @@ func NewSubscriberByConfig(ctx context.Context, c SubscriberConfig, logError fun
 	}
 }
 
+// SubscribeMessage reads the messages until ctx is done or Close is called
 func (c *Subscriber) SubscribeMessage(ctx context.Context, handle func(context.Context, *pubsub.Message)) {
-	er1 := c.Subscription.Receive(ctx, func(ctx2 context.Context, msg *pubsub.Message) {
+	handle = mq.Recover(handle, c.LogError)
+	ctx1, done := c.canceler.WithCancel(ctx)
+	defer done()
+	er1 := c.Subscription.Receive(ctx1, func(ctx2 context.Context, msg *pubsub.Message) {
+		if c.gate.Wait(ctx2) != nil {
+			msg.Nack()
+			return
+		}
+		ctx2 = mq.WithPauser(ctx2, c)
 		if c.AckOnConsume {
 			msg.Ack()
+		} else {
+			ctx2 = mq.WithDelivery(ctx2, NewDelivery(msg))
 		}
 		if len(c.ID) > 0 && len(msg.ID) > 0 {
 			ctx2 = context.WithValue(ctx2, c.ID, msg.ID)
@@ -59,10 +81,20 @@ func (c *Subscriber) SubscribeMessage(ctx context.Context, handle func(context.C
 	}
 }
 func (c *Subscriber) SubscribeData(ctx context.Context, handle func(context.Context, []byte)) {
-	er1 := c.Subscription.Receive(ctx, func(ctx2 context.Context, msg *pubsub.Message) {
+	handle = mq.Recover(handle, c.LogError)
+	ctx1, done := c.canceler.WithCancel(ctx)
+	defer done()
+	er1 := c.Subscription.Receive(ctx1, func(ctx2 context.Context, msg *pubsub.Message) {
 		if msg != nil {
+			if c.gate.Wait(ctx2) != nil {
+				msg.Nack()
+				return
+			}
+			ctx2 = mq.WithPauser(ctx2, c)
 			if c.AckOnConsume {
 				msg.Ack()
+			} else {
+				ctx2 = mq.WithDelivery(ctx2, NewDelivery(msg))
 			}
 			if len(c.ID) > 0 && len(msg.ID) > 0 {
 				ctx2 = context.WithValue(ctx2, c.ID, msg.ID)
@@ -75,18 +107,56 @@ func (c *Subscriber) SubscribeData(ctx context.Context, handle func(context.Cont
 	}
 }
 func (c *Subscriber) Subscribe(ctx context.Context, handle func(context.Context, []byte, map[string]string)) {
-	er1 := c.Subscription.Receive(ctx, func(ctx2 context.Context, msg *pubsub.Message) {
+	handle = mq.RecoverWithMap(handle, c.LogError, c.HandleError)
+	ctx1, done := c.canceler.WithCancel(ctx)
+	defer done()
+	er1 := c.Subscription.Receive(ctx1, func(ctx2 context.Context, msg *pubsub.Message) {
 		if msg != nil {
+			if c.gate.Wait(ctx2) != nil {
+				msg.Nack()
+				return
+			}
+			ctx2 = mq.WithPauser(ctx2, c)
 			if c.AckOnConsume {
 				msg.Ack()
+			} else {
+				ctx2 = mq.WithDelivery(ctx2, NewDelivery(msg))
 			}
 			if len(c.ID) > 0 && len(msg.ID) > 0 {
 				ctx2 = context.WithValue(ctx2, c.ID, msg.ID)
 			}
-			handle(ctx2, msg.Data, msg.Attributes)
+			handle(ctx2, msg.Data, c.getAttributes(msg))
 		}
 	})
 	if er1 != nil {
 		c.LogError(ctx, "Error when subscribe: "+er1.Error())
 	}
 }
+func (c *Subscriber) getAttributes(msg *pubsub.Message) map[string]string {
+	if len(c.RetryCountName) == 0 || msg.DeliveryAttempt == nil || *msg.DeliveryAttempt <= 1 {
+		return msg.Attributes
+	}
+	attrs := make(map[string]string, len(msg.Attributes)+1)
+	for k, v := range msg.Attributes {
+		attrs[k] = v
+	}
+	attrs[c.RetryCountName] = strconv.Itoa(*msg.DeliveryAttempt - 1)
+	return attrs
+}
+
+// Pause holds the received messages until Resume is called. Pub/Sub stops sending the messages when the held messages reach MaxOutstandingMessages, and extends the ack deadline of the held messages.
+func (c *Subscriber) Pause() error {
+	return c.gate.Pause()
+}
+func (c *Subscriber) Resume() error {
+	return c.gate.Resume()
+}
+func (c *Subscriber) Paused() bool {
+	return c.gate.Paused()
+}
+
+// Close stops the subscribing loops, and closes the client
+func (c *Subscriber) Close() error {
+	c.canceler.Cancel()
+	return c.Client.Close()
+}
@@ -15,14 +15,17 @@ func NewTopicPublisher(client *pubsub.Client, c *TopicConfig) *TopicPublisher {
 }
 
 func NewTopicPublisherByConfig(ctx context.Context, c PublisherConfig) (*TopicPublisher, error) {
-	if c.Retry.Retry1 <= 0 {
+	durations, err := c.Retry.Durations()
+	if err != nil {
+		return nil, err
+	}
+	if len(durations) == 0 {
 		client, err := NewPubSubClient(ctx, []byte(c.Client.Credentials), c.Client.ProjectId)
 		if err != nil {
 			return nil, err
 		}
 		return NewTopicPublisher(client, c.Topic), nil
 	} else {
-		durations := DurationsFromValue(c.Retry, "Retry", 9)
 		client, err := NewPubSubClientWithRetries(ctx, []byte(c.Client.Credentials), durations, c.Client.ProjectId)
 		if err != nil {
 			return nil, err
@@ -2,7 +2,11 @@ package rabbitmq
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"sync"
 
+	"github.com/core-go/mq"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -13,6 +17,12 @@ type Consumer struct {
 	AutoAck      bool
 	AckOnConsume bool
 	LogError     func(ctx context.Context, msg string)
+	HandleError  func(context.Context, []byte, map[string]string)
+	canceler     mq.Canceler
+	gate         mq.Gate
+	mu           sync.Mutex
+	tags         map[string]bool
+	sequence     int
 }
 
 func NewConsumer(channel *amqp.Channel, exchangeName string, queueName string, autoAck, ackOnConsume bool, logError func(ctx context.Context, msg string)) (*Consumer, error) {
@@ -31,53 +41,169 @@ func NewConsumerByConfig(config ConsumerConfig, autoAck, ackOnConsume bool, logE
 	if err != nil {
 		return nil, err
 	}
+	if config.PrefetchCount > 0 {
+		if err = channel.Qos(config.PrefetchCount, 0, false); err != nil {
+			return nil, err
+		}
+	}
 	return NewConsumer(channel, config.ExchangeName, queue.Name, autoAck, ackOnConsume, logError)
 }
 
+// Consume reads the messages until ctx is done, Close is called or the channel is closed
 func (c *Consumer) Consume(ctx context.Context, handle func(context.Context, []byte, map[string]string)) {
-	delivery, err := c.Channel.Consume(c.QueueName, "", c.AutoAck, false, false, false, nil)
-	if err != nil {
-		c.LogError(ctx, "Error when consume: "+err.Error())
-	} else {
-		for msg := range delivery {
-			attributes := TableToMap(msg.Headers)
-			if c.AckOnConsume && !c.AutoAck {
-				msg.Ack(false)
-			}
-			handle(ctx, msg.Body, attributes)
+	handle = mq.RecoverWithMap(handle, c.LogError, c.HandleError)
+	// the headers are converted inside the recovered function, so that a panic of the conversion does not stop the loop
+	consume := mq.Recover(func(ctx context.Context, msg amqp.Delivery) {
+		handle(ctx, msg.Body, TableToMap(msg.Headers))
+	}, c.LogError)
+	c.receive(ctx, func(ctx context.Context, msg amqp.Delivery) {
+		if c.AutoAck {
+			consume(ctx, msg)
+		} else if c.AckOnConsume {
+			msg.Ack(false)
+			consume(ctx, msg)
+		} else {
+			consume(mq.WithDelivery(ctx, NewDelivery(msg)), msg)
 		}
-	}
+	})
 }
 func (c *Consumer) ConsumeBody(ctx context.Context, handle func(context.Context, []byte)) {
-	delivery, err := c.Channel.Consume(c.QueueName, "", c.AutoAck, false, false, false, nil)
-	if err != nil {
-		c.LogError(ctx, "Error when consume: "+err.Error())
-	} else {
-		for msg := range delivery {
-			if c.AckOnConsume && !c.AutoAck {
-				msg.Ack(false)
-			}
+	handle = mq.Recover(handle, c.LogError)
+	c.receive(ctx, func(ctx context.Context, msg amqp.Delivery) {
+		if c.AutoAck {
+			handle(ctx, msg.Body)
+		} else if c.AckOnConsume {
+			msg.Ack(false)
 			handle(ctx, msg.Body)
+		} else {
+			handle(mq.WithDelivery(ctx, NewDelivery(msg)), msg.Body)
 		}
-	}
+	})
 }
 func (c *Consumer) ConsumeDelivery(ctx context.Context, handle func(context.Context, amqp.Delivery)) {
-	delivery, err := c.Channel.Consume(c.QueueName, "", c.AutoAck, false, false, false, nil)
-	if err != nil {
-		c.LogError(ctx, "Error when consume: "+err.Error())
-	} else {
-		for msg := range delivery {
-			if c.AckOnConsume && !c.AutoAck {
-				msg.Ack(false)
+	handle = mq.Recover(handle, c.LogError)
+	c.receive(ctx, func(ctx context.Context, msg amqp.Delivery) {
+		if c.AutoAck {
+			handle(ctx, msg)
+		} else if c.AckOnConsume {
+			msg.Ack(false)
+			handle(ctx, msg)
+		} else {
+			handle(mq.WithDelivery(ctx, NewDelivery(msg)), msg)
+		}
+	})
+}
+
+// receive consumes the queue until ctx is done, Close is called or the channel is closed.
+// When the consumer is cancelled by Pause, the deliveries which are already received are kept until Resume, then the queue is consumed again.
+func (c *Consumer) receive(ctx context.Context, handle func(context.Context, amqp.Delivery)) {
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	ctx = mq.WithPauser(ctx, c)
+	for {
+		if c.gate.Wait(ctx2) != nil {
+			return
+		}
+		tag, delivery, err := c.consume()
+		if err != nil {
+			c.LogError(ctx, "Error when consume: "+err.Error())
+			return
+		}
+		if delivery == nil {
+			// paused before consuming
+			continue
+		}
+		for {
+			if c.gate.Wait(ctx2) != nil {
+				c.done(tag)
+				return
+			}
+			msg, ok := mq.Receive(ctx2, delivery)
+			if !ok {
+				break
 			}
 			handle(ctx, msg)
 		}
+		if !c.done(tag) {
+			// the delivery channel is closed by the channel, not by Pause
+			return
+		}
 	}
 }
+
+// consume starts a consumer of the queue, if the consumer is not paused
+func (c *Consumer) consume() (string, <-chan amqp.Delivery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gate.Paused() {
+		return "", nil, nil
+	}
+	c.sequence++
+	tag := c.QueueName + "-" + strconv.Itoa(c.sequence)
+	delivery, err := c.Channel.Consume(c.QueueName, tag, c.AutoAck, false, false, false, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if c.tags == nil {
+		c.tags = make(map[string]bool)
+	}
+	c.tags[tag] = true
+	return tag, delivery, nil
+}
+
+// done removes the consumer, and returns true if it is cancelled by Pause
+func (c *Consumer) done(tag string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tags[tag] {
+		delete(c.tags, tag)
+		return false
+	}
+	return true
+}
+
+// Pause cancels the consumers of the queue (basic.cancel), so that the broker stops sending the messages. The messages which are already sent are handled after Resume.
+func (c *Consumer) Pause() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gate.Pause()
+	var err error
+	for tag := range c.tags {
+		delete(c.tags, tag)
+		if er1 := c.Channel.Cancel(tag, false); er1 != nil {
+			err = er1
+		}
+	}
+	return err
+}
+
+// Resume consumes the queue again
+func (c *Consumer) Resume() error {
+	return c.gate.Resume()
+}
+func (c *Consumer) Paused() bool {
+	return c.gate.Paused()
+}
+
+// Close stops the consuming loops, and closes the channel, so that the unacked messages are redelivered
+func (c *Consumer) Close() error {
+	c.canceler.Cancel()
+	return c.Channel.Close()
+}
+
+// TableToMap converts the headers to strings. The headers which are tables or arrays, such as x-death of the dead-lettered messages, are skipped.
 func TableToMap(header amqp.Table) map[string]string {
 	attributes := make(map[string]string, 0)
 	for k, v := range header {
-		attributes[k] = v.(string)
+		switch t := v.(type) {
+		case string:
+			attributes[k] = t
+		case []byte:
+			attributes[k] = string(t)
+		case nil, amqp.Table, []interface{}:
+		default:
+			attributes[k] = fmt.Sprint(t)
+		}
 	}
 	return attributes
 }
@@ -0,0 +1,55 @@
+package rabbitmq
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DelayPublisher publishes the messages to a delay queue, which has no consumer. A message expires after its delay, which is the per-message TTL,
+// then it is dead-lettered to the exchange and the routing key of the delay queue, which are the exchange and the key of the original queue.
+// RabbitMQ expires only the messages at the head of a queue, so a message with a short delay waits for the earlier messages with a longer delay.
+// If the delays are very different, use a delay queue for each retry count.
+type DelayPublisher struct {
+	Channel     *amqp.Channel
+	Queue       string
+	ContentType string
+}
+
+func NewDelayPublisher(channel *amqp.Channel, queue string, exchangeName string, key string, contentType string) (*DelayPublisher, error) {
+	if len(contentType) == 0 {
+		contentType = "text/plain"
+	}
+	if err := DeclareDelayQueue(channel, queue, exchangeName, key); err != nil {
+		return nil, err
+	}
+	return &DelayPublisher{Channel: channel, Queue: queue, ContentType: contentType}, nil
+}
+
+// DeclareDelayQueue declares a durable queue, which dead-letters the expired messages to exchangeName with key
+func DeclareDelayQueue(channel *amqp.Channel, queue string, exchangeName string, key string) error {
+	args := amqp.Table{"x-dead-letter-exchange": exchangeName}
+	if len(key) > 0 {
+		args["x-dead-letter-routing-key"] = key
+	}
+	_, err := channel.QueueDeclare(queue, true, false, false, false, args)
+	return err
+}
+
+// Publish publishes the message to the delay queue with the expiration of delay. It is the Send of mq.DelayedRetry.
+func (p *DelayPublisher) Publish(ctx context.Context, data []byte, attributes map[string]string, delay time.Duration) error {
+	if delay < 0 {
+		delay = 0
+	}
+	msg := amqp.Publishing{
+		Headers:      MapToTable(attributes),
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		ContentType:  p.ContentType,
+		Expiration:   strconv.FormatInt(delay.Milliseconds(), 10),
+		Body:         data,
+	}
+	return p.Channel.PublishWithContext(ctx, "", p.Queue, false, false, msg)
+}
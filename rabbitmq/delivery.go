@@ -0,0 +1,34 @@
+package rabbitmq
+
+import (
+	"context"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Delivery acks or nacks the message on the channel. It is used when both AutoAck and AckOnConsume are false.
+type Delivery struct {
+	Delivery amqp.Delivery
+}
+
+func NewDelivery(delivery amqp.Delivery) *Delivery {
+	return &Delivery{Delivery: delivery}
+}
+func (d *Delivery) Ack(ctx context.Context) error {
+	return d.Delivery.Ack(false)
+}
+func (d *Delivery) Nack(ctx context.Context) error {
+	return d.Delivery.Nack(false, true)
+}
+
+// Requeue keeps the message unacked for delay, then requeues it
+func (d *Delivery) Requeue(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return d.Delivery.Nack(false, true)
+	}
+	time.AfterFunc(delay, func() {
+		d.Delivery.Nack(false, true)
+	})
+	return nil
+}
@@ -0,0 +1,31 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DedupStore keeps the IDs of the processed messages in redis, or any redis compatible store, such as KeyDB, Dragonfly or Valkey.
+// Client can be *redis.Client, *redis.ClusterClient or *redis.Ring. The keys are Prefix + id, and expire after TTL. If TTL is 0, the keys do not expire.
+type DedupStore struct {
+	Client redis.Cmdable
+	Prefix string
+	TTL    time.Duration
+}
+
+func NewDedupStore(client redis.Cmdable, prefix string, ttl time.Duration) *DedupStore {
+	return &DedupStore{Client: client, Prefix: prefix, TTL: ttl}
+}
+
+func (s *DedupStore) Exists(ctx context.Context, id string) (bool, error) {
+	n, err := s.Client.Exists(ctx, s.Prefix+id).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+func (s *DedupStore) Save(ctx context.Context, id string) error {
+	return s.Client.Set(ctx, s.Prefix+id, time.Now().Unix(), s.TTL).Err()
+}
@@ -1,8 +1,12 @@
 package mq
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,6 +31,237 @@ type RetryConfig struct {
 	Retry18 int64 `yaml:"18" mapstructure:"18" json:"retry18,omitempty" gorm:"column:retry18" bson:"retry18,omitempty" dynamodbav:"retry18,omitempty" firestore:"retry18,omitempty"`
 	Retry19 int64 `yaml:"19" mapstructure:"19" json:"retry19,omitempty" gorm:"column:retry19" bson:"retry19,omitempty" dynamodbav:"retry19,omitempty" firestore:"retry19,omitempty"`
 	Retry20 int64 `yaml:"20" mapstructure:"20" json:"retry20,omitempty" gorm:"column:retry20" bson:"retry20,omitempty" dynamodbav:"retry20,omitempty" firestore:"retry20,omitempty"`
+
+	Delays      []string `yaml:"delays" mapstructure:"delays" json:"delays,omitempty" gorm:"column:delays" bson:"delays,omitempty" dynamodbav:"delays,omitempty" firestore:"delays,omitempty"`
+	Initial     string   `yaml:"initial" mapstructure:"initial" json:"initial,omitempty" gorm:"column:initial" bson:"initial,omitempty" dynamodbav:"initial,omitempty" firestore:"initial,omitempty"`
+	Multiplier  float64  `yaml:"multiplier" mapstructure:"multiplier" json:"multiplier,omitempty" gorm:"column:multiplier" bson:"multiplier,omitempty" dynamodbav:"multiplier,omitempty" firestore:"multiplier,omitempty"`
+	Max         string   `yaml:"max" mapstructure:"max" json:"max,omitempty" gorm:"column:max" bson:"max,omitempty" dynamodbav:"max,omitempty" firestore:"max,omitempty"`
+	MaxAttempts int      `yaml:"max_attempts" mapstructure:"max_attempts" json:"maxAttempts,omitempty" gorm:"column:maxattempts" bson:"maxAttempts,omitempty" dynamodbav:"maxAttempts,omitempty" firestore:"maxAttempts,omitempty"`
+}
+
+// DefaultMaxAttempts is used by the policy block of RetryConfig when max_attempts is not set, the same as the number of Retry1..Retry20
+const DefaultMaxAttempts = 20
+
+// Durations returns the retry schedule of the config, which can be:
+//   - a list of durations: [500ms, 2s, 10s]
+//   - a policy block: initial, multiplier, max, max_attempts
+//   - the legacy Retry1..Retry20, in seconds
+//
+// The policy block requires initial: multiplier, max or max_attempts without initial or delays is an error, instead of falling back to Retry1..Retry20.
+func (c *RetryConfig) Durations() ([]time.Duration, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if len(c.Delays) > 0 {
+		durations, err := ParseDurations(c.Delays)
+		if err != nil {
+			return nil, err
+		}
+		max, err := c.maxDuration()
+		if err != nil {
+			return nil, err
+		}
+		if c.MaxAttempts > 0 && c.MaxAttempts < len(durations) {
+			durations = durations[0:c.MaxAttempts]
+		}
+		for i := range durations {
+			durations[i] = capDuration(durations[i], max)
+		}
+		return durations, nil
+	}
+	if len(c.Initial) > 0 {
+		policy, err := c.backoff()
+		if err != nil {
+			return nil, err
+		}
+		var durations []time.Duration
+		var previous time.Duration
+		for i := 1; ; i++ {
+			d, ok := policy.Delay(i, previous)
+			if !ok {
+				return durations, nil
+			}
+			durations = append(durations, d)
+			previous = d
+		}
+	}
+	if c.Multiplier != 0 || len(c.Max) > 0 || c.MaxAttempts != 0 {
+		return nil, errors.New("retry policy requires initial: multiplier, max or max_attempts is set without initial or delays")
+	}
+	return DurationsFromValue(c, "Retry", 20), nil
+}
+
+// Policy returns nil if there is no retry in the config
+func (c *RetryConfig) Policy() (RetryPolicy, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if len(c.Delays) == 0 && len(c.Initial) > 0 {
+		return c.backoff()
+	}
+	durations, err := c.Durations()
+	if err != nil || len(durations) == 0 {
+		return nil, err
+	}
+	return Durations(durations), nil
+}
+func (c *RetryConfig) backoff() (*ExponentialBackoff, error) {
+	initial, err := ParseDuration(c.Initial)
+	if err != nil {
+		return nil, err
+	}
+	max, err := c.maxDuration()
+	if err != nil {
+		return nil, err
+	}
+	maxAttempts := c.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	return NewExponentialBackoff(initial, c.Multiplier, max, maxAttempts), nil
+}
+func (c *RetryConfig) maxDuration() (time.Duration, error) {
+	if len(c.Max) == 0 {
+		return 0, nil
+	}
+	return ParseDuration(c.Max)
+}
+
+type retryConfig RetryConfig
+
+// UnmarshalJSON accepts a list, such as ["500ms", "2s", 10], or an object
+func (c *RetryConfig) UnmarshalJSON(data []byte) error {
+	s := strings.TrimSpace(string(data))
+	if strings.HasPrefix(s, "[") || strings.HasPrefix(s, "\"") {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		return c.decode(v)
+	}
+	var v retryConfig
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*c = RetryConfig(v)
+	_, err := c.Durations()
+	return err
+}
+
+// UnmarshalYAML accepts a list, such as [500ms, 2s, 10s], or a map. It supports both gopkg.in/yaml.v2 and gopkg.in/yaml.v3
+func (c *RetryConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []interface{}
+	if err := unmarshal(&list); err == nil {
+		return c.decode(list)
+	}
+	var s string
+	if err := unmarshal(&s); err == nil {
+		return c.decode(s)
+	}
+	var v retryConfig
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	*c = RetryConfig(v)
+	_, err := c.Durations()
+	return err
+}
+func (c *RetryConfig) decode(v interface{}) error {
+	delays, err := ToDelays(v)
+	if err != nil {
+		return err
+	}
+	*c = RetryConfig{Delays: delays}
+	_, err = c.Durations()
+	return err
+}
+
+// RetryConfigHook is a mapstructure decode hook, to decode a list or a comma separated string into RetryConfig. For example: viper.Unmarshal(&c, viper.DecodeHook(mq.RetryConfigHook))
+func RetryConfigHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(RetryConfig{}) || (from.Kind() != reflect.Slice && from.Kind() != reflect.Array && from.Kind() != reflect.String) {
+		return data, nil
+	}
+	delays, err := ToDelays(data)
+	if err != nil {
+		return nil, err
+	}
+	c := RetryConfig{Delays: delays}
+	if _, err := c.Durations(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ToDelays converts a list or a comma separated string to the duration strings of RetryConfig.Delays
+func ToDelays(v interface{}) ([]string, error) {
+	if s, ok := v.(string); ok {
+		var delays []string
+		for _, d := range strings.Split(s, ",") {
+			d = strings.TrimSpace(d)
+			if len(d) > 0 {
+				delays = append(delays, d)
+			}
+		}
+		return delays, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("cannot convert %T to retry delays", v)
+	}
+	delays := make([]string, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		d, err := ToDuration(rv.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		delays = append(delays, d.String())
+	}
+	return delays, nil
+}
+
+// ParseDuration parses a Go duration string, such as "500ms" or "2s". A number without unit is in seconds, the same as Retry1..Retry20
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retry duration '%s': %w", s, err)
+	}
+	return d, nil
+}
+func ParseDurations(vs []string) ([]time.Duration, error) {
+	durations := make([]time.Duration, 0, len(vs))
+	for _, v := range vs {
+		d, err := ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		durations = append(durations, d)
+	}
+	return durations, nil
+}
+
+// ToDuration accepts a duration string, a time.Duration or a number of seconds
+func ToDuration(v interface{}) (time.Duration, error) {
+	switch d := v.(type) {
+	case time.Duration:
+		return d, nil
+	case string:
+		return ParseDuration(d)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return time.Duration(rv.Int()) * time.Second, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return time.Duration(rv.Uint()) * time.Second, nil
+	case reflect.Float32, reflect.Float64:
+		return time.Duration(rv.Float() * float64(time.Second)), nil
+	default:
+		return 0, fmt.Errorf("cannot convert %T to duration", v)
+	}
 }
 
 func DurationsFromValue(v interface{}, prefix string, max int) []time.Duration {
@@ -41,17 +276,18 @@ func MakeDurations(vs []int64) []time.Duration {
 	}
 	return durations
 }
+
+// MakeArray reads the fields prefix1..prefix{max} in seconds, until a field is missing or not positive
 func MakeArray(v interface{}, prefix string, max int) []int64 {
 	var ar []int64
 	v2 := reflect.Indirect(reflect.ValueOf(v))
 	for i := 1; i <= max; i++ {
 		fn := prefix + strconv.Itoa(i)
-		v3 := v2.FieldByName(fn).Interface().(int64)
-		if v3 > 0 {
-			ar = append(ar, v3)
-		} else {
+		v3 := v2.FieldByName(fn)
+		if !v3.IsValid() || !v3.CanInt() || v3.Int() <= 0 {
 			return ar
 		}
+		ar = append(ar, v3.Int())
 	}
 	return ar
 }
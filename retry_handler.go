@@ -3,27 +3,42 @@ package mq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
+	"time"
 )
 
+// DefaultRetryCountName is the default attribute of the retry count of RetryHandler, DelayedRetry and RetryTopics. The default of BatchWorker is DefaultBatchRetryCountName.
+const DefaultRetryCountName = "retry"
+
+// If Goroutines is true, Concurrency limits the goroutines of writing. If Ordered is true, the messages with the same key (the context value of Key) are written serially.
 type RetryHandlerConfig struct {
 	RetryCountName string `yaml:"retry_count_name" mapstructure:"retry_count_name" json:"retryCountName,omitempty" gorm:"column:retrycountname" bson:"retryCountName,omitempty" dynamodbav:"retryCountName,omitempty" firestore:"retryCountName,omitempty"`
 	LimitRetry     int    `yaml:"limit_retry" mapstructure:"limit_retry" json:"limitRetry,omitempty" gorm:"column:limitretry" bson:"limitRetry,omitempty" dynamodbav:"limitRetry,omitempty" firestore:"limitRetry,omitempty"`
 	Goroutines     bool   `yaml:"goroutines" mapstructure:"goroutines" json:"goroutines,omitempty" gorm:"column:goroutines" bson:"goroutines,omitempty" dynamodbav:"goroutines,omitempty" firestore:"goroutines,omitempty"`
 	Key            string `yaml:"key" mapstructure:"key" json:"key,omitempty" gorm:"column:key" bson:"key,omitempty" dynamodbav:"key,omitempty" firestore:"key,omitempty"`
+	Validation     string `yaml:"validation" mapstructure:"validation" json:"validation,omitempty" gorm:"column:validation" bson:"validation,omitempty" dynamodbav:"validation,omitempty" firestore:"validation,omitempty"`
+	Concurrency    int    `yaml:"concurrency" mapstructure:"concurrency" json:"concurrency,omitempty" gorm:"column:concurrency" bson:"concurrency,omitempty" dynamodbav:"concurrency,omitempty" firestore:"concurrency,omitempty"`
+	Ordered        bool   `yaml:"ordered" mapstructure:"ordered" json:"ordered,omitempty" gorm:"column:ordered" bson:"ordered,omitempty" dynamodbav:"ordered,omitempty" firestore:"ordered,omitempty"`
+	ContentType    string `yaml:"content_type" mapstructure:"content_type" json:"contentType,omitempty" gorm:"column:contenttype" bson:"contentType,omitempty" dynamodbav:"contentType,omitempty" firestore:"contentType,omitempty"`
 }
 
 type RetryHandler[T any] struct {
 	Unmarshal      func(data []byte, v any) error
+	ContentType    string
+	Codecs         Codecs
 	Write          func(context.Context, *T) error
 	Validate       func(context.Context, *T) ([]ErrorMessage, error)
 	Reject         func(context.Context, *T, []ErrorMessage, []byte, map[string]string)
+	ValidationMode string
+	Quarantine     func(context.Context, []byte, map[string]string) error
 	HandleError    func(context.Context, []byte, map[string]string)
 	Retry          func(context.Context, []byte, map[string]string) error
 	LimitRetry     int
 	RetryCountName string
 	Goroutines     bool
+	Pool           *WorkerPool
 	LogError       func(context.Context, string)
 	LogInfo        func(context.Context, string)
 	Key            string
@@ -37,7 +52,7 @@ func NewRetryHandlerByConfig[T any](
 	handleError func(context.Context, []byte, map[string]string),
 	retry func(context.Context, []byte, map[string]string) error,
 	logs ...func(context.Context, string)) *RetryHandler[T] {
-	return NewRetryHandler[T](nil, write, validate, reject, handleError, retry, c.LimitRetry, c.RetryCountName, c.Goroutines, c.Key, logs...)
+	return NewRetryHandlerByConfigAndUnmarshal[T](c, nil, write, validate, reject, handleError, retry, logs...)
 }
 func NewRetryHandlerByConfigAndUnmarshal[T any](
 	c RetryHandlerConfig,
@@ -48,7 +63,41 @@ func NewRetryHandlerByConfigAndUnmarshal[T any](
 	handleError func(context.Context, []byte, map[string]string),
 	retry func(context.Context, []byte, map[string]string) error,
 	logs ...func(context.Context, string)) *RetryHandler[T] {
-	return NewRetryHandler[T](unmarshal, write, validate, reject, handleError, retry, c.LimitRetry, c.RetryCountName, c.Goroutines, c.Key, logs...)
+	h := NewRetryHandler[T](unmarshal, write, validate, reject, handleError, retry, c.LimitRetry, c.RetryCountName, c.Goroutines, c.Key, logs...)
+	h.Pool = NewWorkerPoolByConfig(c.Goroutines, c.Concurrency, c.Ordered)
+	h.ValidationMode = c.Validation
+	h.ContentType = c.ContentType
+	return h
+}
+
+// NewRetryHandlerByConfigE returns an error if the validation mode is invalid, see CheckValidation
+func NewRetryHandlerByConfigE[T any](
+	c RetryHandlerConfig,
+	write func(context.Context, *T) error,
+	validate func(context.Context, *T) ([]ErrorMessage, error),
+	reject func(context.Context, *T, []ErrorMessage, []byte, map[string]string),
+	handleError func(context.Context, []byte, map[string]string),
+	retry func(context.Context, []byte, map[string]string) error,
+	quarantine func(context.Context, []byte, map[string]string) error,
+	logs ...func(context.Context, string)) (*RetryHandler[T], error) {
+	return NewRetryHandlerByConfigAndUnmarshalE[T](c, nil, write, validate, reject, handleError, retry, quarantine, logs...)
+}
+func NewRetryHandlerByConfigAndUnmarshalE[T any](
+	c RetryHandlerConfig,
+	unmarshal func(data []byte, v any) error,
+	write func(context.Context, *T) error,
+	validate func(context.Context, *T) ([]ErrorMessage, error),
+	reject func(context.Context, *T, []ErrorMessage, []byte, map[string]string),
+	handleError func(context.Context, []byte, map[string]string),
+	retry func(context.Context, []byte, map[string]string) error,
+	quarantine func(context.Context, []byte, map[string]string) error,
+	logs ...func(context.Context, string)) (*RetryHandler[T], error) {
+	if err := CheckValidation(c.Validation, quarantine); err != nil {
+		return nil, err
+	}
+	h := NewRetryHandlerByConfigAndUnmarshal[T](c, unmarshal, write, validate, reject, handleError, retry, logs...)
+	h.Quarantine = quarantine
+	return h, nil
 }
 func NewRetryHandler[T any](
 	unmarshal func(data []byte, v any) error,
@@ -61,7 +110,7 @@ func NewRetryHandler[T any](
 	retryCountName string,
 	goroutines bool, key string, logs ...func(context.Context, string)) *RetryHandler[T] {
 	if len(retryCountName) == 0 {
-		retryCountName = "retry"
+		retryCountName = DefaultRetryCountName
 	}
 	if unmarshal == nil {
 		unmarshal = json.Unmarshal
@@ -87,6 +136,22 @@ func NewRetryHandler[T any](
 	return c
 }
 
+// Stop waits until the messages submitted to Pool are written, and stops the workers of Pool. Call it after the consumer is closed.
+func (c *RetryHandler[T]) Stop(ctx context.Context) error {
+	if c.Pool == nil {
+		return nil
+	}
+	return c.Pool.Stop(ctx)
+}
+
+// RegisterCodec registers the codec of a content type for this handler only
+func (c *RetryHandler[T]) RegisterCodec(contentType string, marshal func(v any) ([]byte, error), unmarshal func(data []byte, v any) error) {
+	if c.Codecs == nil {
+		c.Codecs = make(Codecs)
+	}
+	c.Codecs.Register(contentType, marshal, unmarshal)
+}
+
 func (c *RetryHandler[T]) Handle(ctx context.Context, data []byte, attrs map[string]string) {
 	if data == nil {
 		return
@@ -100,27 +165,25 @@ func (c *RetryHandler[T]) Handle(ctx context.Context, data []byte, attrs map[str
 		}
 	}
 	var v T
-	er1 := c.Unmarshal(data, &v)
+	er1 := c.Codecs.Unmarshal(data, &v, ResolveContentType(ctx, attrs, c.ContentType), c.Unmarshal)
 	if er1 != nil {
 		if c.LogError != nil {
 			c.LogError(ctx, fmt.Sprintf("cannot unmarshal item: %s. Error: %s", GetLog(data, attrs), er1.Error()))
 		}
+		Ack(ctx, c.LogError)
 		return
 	}
-	if c.Validate != nil {
-		errs, err := c.Validate(ctx, &v)
-		if err != nil {
-			if c.LogError != nil {
-				c.LogError(ctx, "Error when validate data: "+err.Error())
-			}
-			return
-		}
-		if len(errs) > 0 {
-			c.Reject(ctx, &v, errs, data, attrs)
-		}
+	if !Validate[T](ctx, &v, data, attrs, c.Validate, c.Reject, c.Quarantine, c.ValidationMode, c.LogError) {
+		return
 	}
 	if c.Goroutines {
-		go Write[*T](ctx, c.Write, &v, data, attrs, c.HandleError, c.Retry, c.LimitRetry, c.RetryCountName, c.LogError, c.LogInfo)
+		if c.Pool != nil {
+			c.Pool.Submit(GetString(ctx, c.Key), func() {
+				Write[*T](ctx, c.Write, &v, data, attrs, c.HandleError, c.Retry, c.LimitRetry, c.RetryCountName, c.LogError, c.LogInfo)
+			})
+		} else {
+			go Write[*T](ctx, c.Write, &v, data, attrs, c.HandleError, c.Retry, c.LimitRetry, c.RetryCountName, c.LogError, c.LogInfo)
+		}
 	} else {
 		Write[*T](ctx, c.Write, &v, data, attrs, c.HandleError, c.Retry, c.LimitRetry, c.RetryCountName, c.LogError, c.LogInfo)
 	}
@@ -135,8 +198,19 @@ func Write[T any](ctx context.Context, write func(context.Context, T) error, ite
 	if len(logs) > 1 {
 		logInfo = logs[1]
 	}
-	er3 := write(ctx, item)
+	defer NackOnPanic(ctx, data, attrs, logError)
+	er3 := WaitCircuit(ctx, func() error {
+		return Safe(func() error {
+			return write(ctx, item)
+		})
+	})
 	if er3 == nil {
+		Ack(ctx, logError)
+		return
+	}
+	if errors.Is(er3, ErrCircuitOpen) {
+		// ctx is done while the consumer is paused by the breaker, so the message is redelivered
+		Nack(ctx, logError)
 		return
 	}
 	if logError != nil {
@@ -144,9 +218,7 @@ func Write[T any](ctx context.Context, write func(context.Context, T) error, ite
 	}
 
 	if retry == nil {
-		if handleError != nil {
-			handleError(ctx, data, attrs)
-		}
+		HandleErrorAndAck(WithFailure(ctx, er3), data, attrs, handleError, logError)
 		return
 	}
 	retryCount := 0
@@ -164,23 +236,41 @@ func Write[T any](ctx context.Context, write func(context.Context, T) error, ite
 		if logInfo != nil {
 			logInfo(ctx, fmt.Sprintf("Retry: %d . Retry limitation: %d . Message: %s.", retryCount-1, limitRetry, GetLog(data, attrs)))
 		}
-		if handleError != nil {
-			handleError(ctx, data, attrs)
-		}
+		HandleErrorAndAck(WithFailure(ctx, er3), data, attrs, handleError, logError)
 	} else {
 		if logInfo != nil {
 			logInfo(ctx, fmt.Sprintf("Retry: %d . Message: %s", retryCount-1, GetLog(data, attrs)))
 		}
 		attrs[retryCountName] = strconv.Itoa(retryCount)
+		if len(attrs[DLQFirstFailureName]) == 0 {
+			attrs[DLQFirstFailureName] = time.Now().UTC().Format(time.RFC3339Nano)
+		}
 		er2 := retry(ctx, data, attrs)
+		if errors.Is(er2, ErrSettled) {
+			return
+		}
 		if er2 != nil {
 			if logError != nil {
 				logError(ctx, fmt.Sprintf("Cannot retry %s . Error: %s", GetLog(data, attrs), er2.Error()))
 			}
+			Nack(ctx, logError)
+		} else {
+			Ack(ctx, logError)
 		}
 	}
 }
 
+// HandleErrorAndAck acks the message after it is passed to handleError, or nacks it to be redelivered if handleError is nil or handleError nacks it
+func HandleErrorAndAck(ctx context.Context, data []byte, attrs map[string]string, handleError func(context.Context, []byte, map[string]string), logError func(context.Context, string)) {
+	if handleError != nil && Handled(ctx, func(ctx context.Context) {
+		handleError(ctx, data, attrs)
+	}) {
+		Ack(ctx, logError)
+	} else {
+		Nack(ctx, logError)
+	}
+}
+
 func GetLog(data []byte, attrs map[string]string) string {
 	if len(attrs) == 0 {
 		return fmt.Sprintf("%s %+v", data, attrs)
@@ -0,0 +1,231 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy returns the delay before the given retry (1-based), and false when no more retry is allowed.
+// previous is the delay returned for the previous retry, 0 for the first one.
+type RetryPolicy interface {
+	Delay(retry int, previous time.Duration) (time.Duration, bool)
+}
+
+// Durations is the fixed schedule, which is used by RetryConfig
+type Durations []time.Duration
+
+func (d Durations) Delay(retry int, previous time.Duration) (time.Duration, bool) {
+	if retry < 1 || retry > len(d) {
+		return 0, false
+	}
+	return d[retry-1], true
+}
+
+// FixedBackoff waits Interval before each retry. MaxAttempts <= 0 means no limit, the retry stops only when ctx is done.
+type FixedBackoff struct {
+	Interval    time.Duration
+	MaxAttempts int
+}
+
+func NewFixedBackoff(interval time.Duration, maxAttempts int) *FixedBackoff {
+	return &FixedBackoff{Interval: interval, MaxAttempts: maxAttempts}
+}
+func (b *FixedBackoff) Delay(retry int, previous time.Duration) (time.Duration, bool) {
+	if !allow(retry, b.MaxAttempts) {
+		return 0, false
+	}
+	return b.Interval, true
+}
+
+type ExponentialBackoff struct {
+	Initial     time.Duration
+	Multiplier  float64
+	Max         time.Duration
+	MaxAttempts int
+}
+
+func NewExponentialBackoff(initial time.Duration, multiplier float64, max time.Duration, maxAttempts int) *ExponentialBackoff {
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+	return &ExponentialBackoff{Initial: initial, Multiplier: multiplier, Max: max, MaxAttempts: maxAttempts}
+}
+func (b *ExponentialBackoff) Delay(retry int, previous time.Duration) (time.Duration, bool) {
+	if !allow(retry, b.MaxAttempts) {
+		return 0, false
+	}
+	d := float64(b.Initial)
+	for i := 1; i < retry; i++ {
+		d = d * b.Multiplier
+		if b.Max > 0 && d >= float64(b.Max) {
+			return b.Max, true
+		}
+		if d >= maxDelay {
+			return time.Duration(math.MaxInt64), true
+		}
+	}
+	return capDuration(time.Duration(d), b.Max), true
+}
+
+// DecorrelatedJitterBackoff is the "decorrelated jitter" of https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type DecorrelatedJitterBackoff struct {
+	Base        time.Duration
+	Max         time.Duration
+	MaxAttempts int
+}
+
+func NewDecorrelatedJitterBackoff(base time.Duration, max time.Duration, maxAttempts int) *DecorrelatedJitterBackoff {
+	return &DecorrelatedJitterBackoff{Base: base, Max: max, MaxAttempts: maxAttempts}
+}
+func (b *DecorrelatedJitterBackoff) Delay(retry int, previous time.Duration) (time.Duration, bool) {
+	if !allow(retry, b.MaxAttempts) {
+		return 0, false
+	}
+	if previous < b.Base {
+		previous = b.Base
+	}
+	upper := int64(previous) * 3
+	if previous > time.Duration(math.MaxInt64/3) {
+		upper = math.MaxInt64
+	}
+	d := int64(b.Base)
+	if upper > d {
+		d = d + rand.Int63n(upper-d)
+	}
+	return capDuration(time.Duration(d), b.Max), true
+}
+
+// CappedBackoff limits the delay of any policy to Max
+type CappedBackoff struct {
+	Policy RetryPolicy
+	Max    time.Duration
+}
+
+func NewCappedBackoff(policy RetryPolicy, max time.Duration) *CappedBackoff {
+	return &CappedBackoff{Policy: policy, Max: max}
+}
+func (b *CappedBackoff) Delay(retry int, previous time.Duration) (time.Duration, bool) {
+	d, ok := b.Policy.Delay(retry, previous)
+	return capDuration(d, b.Max), ok
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error as not retryable
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// IsRetryable returns false for nil, the permanent errors and ErrCircuitOpen, which is not retried while the breaker is open
+func IsRetryable(err error) bool {
+	return err != nil && !IsPermanent(err) && !errors.Is(err, ErrCircuitOpen)
+}
+
+// Sleep waits for d, and returns ctx.Err() if ctx is done before that
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// RetryWithPolicy waits for the delay of the policy before each call of f, until f succeeds, the policy stops, ctx is done or isRetryable returns false.
+// If isRetryable is nil, IsRetryable is used.
+func RetryWithPolicy(ctx context.Context, policy RetryPolicy, f func() error, isRetryable func(error) bool, logs ...func(context.Context, string)) (err error) {
+	var log func(context.Context, string)
+	if len(logs) > 0 {
+		log = logs[0]
+	}
+	if isRetryable == nil {
+		isRetryable = IsRetryable
+	}
+	var delay time.Duration
+	i := 0
+	for {
+		d, ok := policy.Delay(i+1, delay)
+		if !ok {
+			break
+		}
+		delay = d
+		if er1 := Sleep(ctx, delay); er1 != nil {
+			return fmt.Errorf("retry is cancelled after %d attempts: %w, last error: %v", i, er1, err)
+		}
+		i++
+		err = f()
+		if err == nil {
+			return nil
+		}
+		if !isRetryable(err) || errors.Is(err, ErrCircuitOpen) {
+			return err
+		}
+		if log != nil {
+			log(ctx, fmt.Sprintf("Retrying %d after error: %s", i, err.Error()))
+		}
+	}
+	return fmt.Errorf("after %d attempts, last error: %w", i, err)
+}
+
+// Attempt calls f up to len(sleeps) times, and waits sleeps[i] after the (i+1)th failure. It stops when f succeeds or ctx is done.
+// It is used by the Retry of the sub packages, to reconnect right after the first connection fails.
+func Attempt(ctx context.Context, sleeps []time.Duration, f func() error, log func(context.Context, string)) (err error) {
+	attempts := len(sleeps)
+	for i := 0; ; i++ {
+		if log != nil {
+			log(ctx, fmt.Sprintf("Retrying %d of %d ", i+1, attempts))
+		}
+		err = f()
+		if err == nil {
+			return
+		}
+		if i >= (attempts - 1) {
+			break
+		}
+		if er1 := Sleep(ctx, sleeps[i]); er1 != nil {
+			return fmt.Errorf("retry is cancelled after %d attempts: %w, last error: %v", i+1, er1, err)
+		}
+		if log != nil {
+			log(ctx, fmt.Sprintf("Retrying %d of %d after error: %s", i+1, attempts, err.Error()))
+		}
+	}
+	return fmt.Errorf("after %d attempts, last error: %s", attempts, err)
+}
+
+// maxDelay is the largest float64 which can be converted to time.Duration without overflow
+const maxDelay = float64(math.MaxInt64 - 1<<10)
+
+func allow(retry int, maxAttempts int) bool {
+	return retry >= 1 && (maxAttempts <= 0 || retry <= maxAttempts)
+}
+func capDuration(d time.Duration, max time.Duration) time.Duration {
+	if max > 0 && d > max {
+		return max
+	}
+	return d
+}
@@ -0,0 +1,157 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+type delayCase struct {
+	name     string
+	policy   RetryPolicy
+	retry    int
+	previous time.Duration
+	delay    time.Duration
+	ok       bool
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []delayCase{
+		{name: "durations", policy: Durations{time.Second, 2 * time.Second}, retry: 2, delay: 2 * time.Second, ok: true},
+		{name: "durations stop", policy: Durations{time.Second}, retry: 2},
+		{name: "durations retry 0", policy: Durations{time.Second}, retry: 0},
+		{name: "fixed", policy: NewFixedBackoff(time.Second, 3), retry: 3, delay: time.Second, ok: true},
+		{name: "fixed stop", policy: NewFixedBackoff(time.Second, 3), retry: 4},
+		{name: "fixed no limit", policy: NewFixedBackoff(time.Second, 0), retry: 1000, delay: time.Second, ok: true},
+		{name: "exponential first", policy: NewExponentialBackoff(time.Second, 2, 0, 0), retry: 1, delay: time.Second, ok: true},
+		{name: "exponential third", policy: NewExponentialBackoff(time.Second, 2, 0, 0), retry: 3, delay: 4 * time.Second, ok: true},
+		{name: "exponential default multiplier", policy: NewExponentialBackoff(time.Second, 0, 0, 0), retry: 2, delay: 2 * time.Second, ok: true},
+		{name: "exponential max", policy: NewExponentialBackoff(time.Second, 2, 5*time.Second, 0), retry: 10, delay: 5 * time.Second, ok: true},
+		{name: "exponential overflow", policy: NewExponentialBackoff(time.Second, 10, 0, 0), retry: 100, delay: time.Duration(math.MaxInt64), ok: true},
+		{name: "exponential overflow with max", policy: NewExponentialBackoff(time.Second, 10, time.Hour, 0), retry: 100, delay: time.Hour, ok: true},
+		{name: "exponential stop", policy: NewExponentialBackoff(time.Second, 2, 0, 2), retry: 3},
+		{name: "capped", policy: NewCappedBackoff(Durations{time.Minute}, time.Second), retry: 1, delay: time.Second, ok: true},
+		{name: "capped below max", policy: NewCappedBackoff(Durations{time.Millisecond}, time.Second), retry: 1, delay: time.Millisecond, ok: true},
+		{name: "capped stop", policy: NewCappedBackoff(Durations{time.Minute}, time.Second), retry: 2},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			delay, ok := c.policy.Delay(c.retry, c.previous)
+			if delay != c.delay || ok != c.ok {
+				t.Errorf("Delay(%d, %s) = %s, %v, want %s, %v", c.retry, c.previous, delay, ok, c.delay, c.ok)
+			}
+		})
+	}
+}
+
+// TestDecorrelatedJitterBackoff checks that each delay is between Base and 3 times the previous delay, and never above Max
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     time.Duration
+		max      time.Duration
+		previous time.Duration
+	}{
+		{name: "first", base: time.Second, max: time.Minute},
+		{name: "previous", base: time.Second, max: time.Minute, previous: 5 * time.Second},
+		{name: "capped", base: time.Second, max: 2 * time.Second, previous: time.Minute},
+		{name: "overflow", base: time.Second, previous: time.Duration(math.MaxInt64)},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			b := NewDecorrelatedJitterBackoff(c.base, c.max, 0)
+			upper := c.previous * 3
+			if c.previous < c.base {
+				upper = c.base * 3
+			}
+			if c.previous > time.Duration(math.MaxInt64/3) {
+				upper = time.Duration(math.MaxInt64)
+			}
+			if c.max > 0 && upper > c.max {
+				upper = c.max
+			}
+			for i := 0; i < 1000; i++ {
+				delay, ok := b.Delay(i+1, c.previous)
+				if !ok {
+					t.Fatalf("Delay(%d) stops, want no limit", i+1)
+				}
+				if delay < c.base && (c.max == 0 || delay < c.max) || delay > upper {
+					t.Fatalf("Delay(%d, %s) = %s, want between %s and %s", i+1, c.previous, delay, c.base, upper)
+				}
+			}
+		})
+	}
+	if _, ok := NewDecorrelatedJitterBackoff(time.Second, time.Minute, 2).Delay(3, time.Second); ok {
+		t.Error("Delay(3) with MaxAttempts 2 does not stop")
+	}
+}
+
+func TestRetryWithPolicy(t *testing.T) {
+	failure := errors.New("failure")
+	tests := []struct {
+		name        string
+		policy      RetryPolicy
+		results     []error
+		isRetryable func(error) bool
+		cancel      bool
+		calls       int
+		err         error
+	}{
+		{name: "success", policy: Durations{time.Millisecond, time.Millisecond}, results: []error{failure, nil}, calls: 2},
+		{name: "policy stops", policy: Durations{time.Millisecond, time.Millisecond}, results: []error{failure, failure, failure}, calls: 2, err: failure},
+		{name: "not retryable", policy: Durations{time.Millisecond, time.Millisecond}, results: []error{failure, failure}, isRetryable: func(error) bool { return false }, calls: 1, err: failure},
+		{name: "permanent", policy: Durations{time.Millisecond, time.Millisecond}, results: []error{Permanent(failure), failure}, calls: 1, err: failure},
+		{name: "circuit open", policy: Durations{time.Millisecond, time.Millisecond}, results: []error{ErrCircuitOpen, failure}, isRetryable: func(error) bool { return true }, calls: 1, err: ErrCircuitOpen},
+		{name: "cancelled", policy: NewFixedBackoff(time.Hour, 0), results: []error{failure}, cancel: true, calls: 0, err: context.Canceled},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if c.cancel {
+				cancel()
+			}
+			calls := 0
+			err := RetryWithPolicy(ctx, c.policy, func() error {
+				res := c.results[calls]
+				calls++
+				return res
+			}, c.isRetryable)
+			if calls != c.calls {
+				t.Errorf("calls = %d, want %d", calls, c.calls)
+			}
+			if c.err == nil && err != nil || c.err != nil && !errors.Is(err, c.err) {
+				t.Errorf("err = %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+// TestRetryWithPolicyCancelWhileWaiting checks that the retry returns as soon as ctx is done, instead of waiting for the delay
+func TestRetryWithPolicyCancelWhileWaiting(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := RetryWithPolicy(ctx, NewFixedBackoff(time.Hour, 0), func() error {
+		return errors.New("failure")
+	}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("RetryWithPolicy returns after %s, want about 20ms", elapsed)
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	calls := 0
+	err := Attempt(context.Background(), []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}, func() error {
+		calls++
+		return errors.New("failure")
+	}, nil)
+	if err == nil || calls != 3 {
+		t.Errorf("calls = %d, err = %v, want 3 calls and an error", calls, err)
+	}
+}
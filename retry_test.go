@@ -0,0 +1,123 @@
+package mq
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+type retryConfigCase struct {
+	name      string
+	json      string
+	yaml      string
+	durations []time.Duration
+	err       bool
+}
+
+var retryConfigCases = []retryConfigCase{
+	{name: "list", json: `["500ms", "2s", 10]`, yaml: `[500ms, 2s, 10]`, durations: []time.Duration{500 * time.Millisecond, 2 * time.Second, 10 * time.Second}},
+	{name: "comma separated", json: `"500ms, 2s"`, yaml: `"500ms, 2s"`, durations: []time.Duration{500 * time.Millisecond, 2 * time.Second}},
+	{name: "invalid list", json: `["500ms", "two seconds"]`, yaml: `[500ms, two seconds]`, err: true},
+	{name: "delays with max and max attempts", json: `{"delays": ["1s", "1m", "1h"], "max": "10s", "maxAttempts": 2}`, yaml: "delays: [1s, 1m, 1h]\nmax: 10s\nmax_attempts: 2", durations: []time.Duration{time.Second, 10 * time.Second}},
+	{name: "policy", json: `{"initial": "1s", "multiplier": 3, "max": "5s", "maxAttempts": 4}`, yaml: "initial: 1s\nmultiplier: 3\nmax: 5s\nmax_attempts: 4", durations: []time.Duration{time.Second, 3 * time.Second, 5 * time.Second, 5 * time.Second}},
+	{name: "policy without initial", json: `{"multiplier": 2, "maxAttempts": 3}`, yaml: "multiplier: 2\nmax_attempts: 3", err: true},
+	{name: "max without initial", json: `{"max": "10s"}`, yaml: "max: 10s", err: true},
+	{name: "legacy", json: `{"retry1": 1, "retry2": 5, "retry3": 10}`, yaml: "1: 1\n2: 5\n3: 10", durations: []time.Duration{time.Second, 5 * time.Second, 10 * time.Second}},
+	{name: "legacy stops at the first missing field", json: `{"retry1": 1, "retry3": 10}`, yaml: "1: 1\n3: 10", durations: []time.Duration{time.Second}},
+	{name: "empty", json: `{}`, yaml: `{}`, durations: []time.Duration{}},
+}
+
+func TestRetryConfigUnmarshalJSON(t *testing.T) {
+	for _, c := range retryConfigCases {
+		t.Run(c.name, func(t *testing.T) {
+			var v RetryConfig
+			err := json.Unmarshal([]byte(c.json), &v)
+			checkRetryConfig(t, &v, err, c)
+		})
+	}
+}
+
+func TestRetryConfigUnmarshalYAML(t *testing.T) {
+	for _, c := range retryConfigCases {
+		t.Run(c.name, func(t *testing.T) {
+			var v RetryConfig
+			err := yaml.Unmarshal([]byte(c.yaml), &v)
+			checkRetryConfig(t, &v, err, c)
+		})
+	}
+}
+
+func TestRetryConfigPolicy(t *testing.T) {
+	c := RetryConfig{Initial: "1s", Multiplier: 2}
+	policy, err := c.Policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := policy.Delay(DefaultMaxAttempts, 0); !ok || d != time.Duration(1<<(DefaultMaxAttempts-1))*time.Second {
+		t.Errorf("Delay(%d) = %s, %v", DefaultMaxAttempts, d, ok)
+	}
+	if _, ok := policy.Delay(DefaultMaxAttempts+1, 0); ok {
+		t.Errorf("Delay(%d) does not stop", DefaultMaxAttempts+1)
+	}
+	if policy, err = (&RetryConfig{}).Policy(); policy != nil || err != nil {
+		t.Errorf("Policy() = %v, %v, want nil, nil", policy, err)
+	}
+	if _, err = (&RetryConfig{MaxAttempts: 3}).Policy(); err == nil {
+		t.Error("Policy() with max_attempts only, want an error")
+	}
+}
+
+func TestMakeArray(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		max  int
+		arr  []int64
+	}{
+		{name: "all", v: RetryConfig{Retry1: 1, Retry2: 2, Retry3: 3}, arr: []int64{1, 2, 3}},
+		{name: "pointer", v: &RetryConfig{Retry1: 1, Retry2: 2}, arr: []int64{1, 2}},
+		{name: "stops at zero", v: RetryConfig{Retry1: 1, Retry3: 3}, arr: []int64{1}},
+		{name: "stops at negative", v: RetryConfig{Retry1: 1, Retry2: -1, Retry3: 3}, arr: []int64{1}},
+		{name: "stops at missing field", v: struct{ Retry1, Retry2 int64 }{1, 2}, arr: []int64{1, 2}},
+		{name: "stops at non int field", v: struct {
+			Retry1 int64
+			Retry2 string
+			Retry3 int64
+		}{1, "2", 3}, arr: []int64{1}},
+		{name: "max", v: RetryConfig{Retry1: 1, Retry2: 2, Retry3: 3}, max: 2, arr: []int64{1, 2}},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			max := c.max
+			if max == 0 {
+				max = 20
+			}
+			if arr := MakeArray(c.v, "Retry", max); !reflect.DeepEqual(arr, c.arr) {
+				t.Errorf("MakeArray = %v, want %v", arr, c.arr)
+			}
+		})
+	}
+}
+
+func checkRetryConfig(t *testing.T, c *RetryConfig, err error, want retryConfigCase) {
+	t.Helper()
+	if want.err {
+		if err == nil {
+			t.Errorf("err = nil, want an error")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	durations, err := c.Durations()
+	if err != nil {
+		t.Fatalf("Durations() err = %v", err)
+	}
+	if len(durations) != len(want.durations) || len(durations) > 0 && !reflect.DeepEqual(durations, want.durations) {
+		t.Errorf("Durations() = %v, want %v", durations, want.durations)
+	}
+}
@@ -0,0 +1,90 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RetryTopics routes the failed messages of Topic to the retry topics <Topic>-retry-<n>, where n is the retry count of the message, and Delays[n-1] is the delay of the tier n.
+// The message is sent with NotBeforeName, so the consumer of the tier waits until then, by WaitNotBefore. The messages after the last tier are sent to the DLQ topic <Topic>-dlq.
+// Retry is the retry function of RetryHandler and BatchWorker, and HandleError is their error handler, with LimitRetry = len(Delays).
+// RetryCountName must be the retry count name of the handler, such as DefaultBatchRetryCountName for BatchWorker.
+type RetryTopics struct {
+	Topic          string
+	Delays         []time.Duration
+	RetryCountName string
+	Send           func(ctx context.Context, topic string, data []byte, attributes map[string]string) error
+	LogError       func(context.Context, string)
+	LogInfo        func(context.Context, string)
+}
+
+func NewRetryTopics(send func(context.Context, string, []byte, map[string]string) error, topic string, delays []time.Duration, retryCountName string, logs ...func(context.Context, string)) *RetryTopics {
+	if len(retryCountName) == 0 {
+		retryCountName = DefaultRetryCountName
+	}
+	r := &RetryTopics{Topic: topic, Delays: delays, RetryCountName: retryCountName, Send: send}
+	if len(logs) >= 1 {
+		r.LogError = logs[0]
+	}
+	if len(logs) >= 2 {
+		r.LogInfo = logs[1]
+	}
+	return r
+}
+func RetryTopicName(topic string, tier int) string {
+	return topic + "-retry-" + strconv.Itoa(tier)
+}
+func DLQTopicName(topic string) string {
+	return topic + "-dlq"
+}
+
+// Topics returns the retry topics of all tiers, which are consumed by the retry consumer
+func (r *RetryTopics) Topics() []string {
+	topics := make([]string, len(r.Delays))
+	for i := range r.Delays {
+		topics[i] = RetryTopicName(r.Topic, i+1)
+	}
+	return topics
+}
+
+// Retry sends the message to the retry topic of its retry count, or to the DLQ topic if the retry count is greater than the number of tiers
+func (r *RetryTopics) Retry(ctx context.Context, data []byte, attrs map[string]string) error {
+	tier, _ := strconv.Atoi(attrs[r.RetryCountName])
+	if tier < 1 {
+		tier = 1
+	}
+	if tier > len(r.Delays) {
+		return r.sendToDLQ(ctx, data, attrs)
+	}
+	headers := make(map[string]string, len(attrs)+1)
+	for k, v := range attrs {
+		headers[k] = v
+	}
+	headers[NotBeforeName] = NotBefore(r.Delays[tier-1])
+	topic := RetryTopicName(r.Topic, tier)
+	if r.LogInfo != nil {
+		r.LogInfo(ctx, fmt.Sprintf("Retry %d to %s after %s", tier, topic, r.Delays[tier-1].String()))
+	}
+	return r.Send(ctx, topic, data, headers)
+}
+
+// HandleError sends the message to the DLQ topic, with the DLQ attributes. It nacks the message if it cannot be sent.
+func (r *RetryTopics) HandleError(ctx context.Context, data []byte, attrs map[string]string) {
+	if err := r.sendToDLQ(ctx, data, attrs); err != nil {
+		if r.LogError != nil {
+			r.LogError(ctx, fmt.Sprintf("Cannot send message to DLQ: %s Error: %s", GetLog(data, attrs), err.Error()))
+		}
+		Nack(ctx, r.LogError)
+	}
+}
+func (r *RetryTopics) sendToDLQ(ctx context.Context, data []byte, attrs map[string]string) error {
+	reason := ReasonFailed
+	if err := GetFailure(ctx); err != nil {
+		reason = err.Error()
+	}
+	headers := BuildDLQAttributes(attrs, reason, r.Topic, r.RetryCountName, nil)
+	delete(headers, NotBeforeName)
+	return r.Send(ctx, DLQTopicName(r.Topic), data, headers)
+}
@@ -0,0 +1,82 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestRetryTopicsWithBatchWorker checks that a message which always fails goes through the tiers in order, then to the DLQ topic, with the default retry count name of BatchWorker
+func TestRetryTopicsWithBatchWorker(t *testing.T) {
+	var topics []string
+	var last map[string]string
+	send := func(ctx context.Context, topic string, data []byte, attrs map[string]string) error {
+		topics = append(topics, topic)
+		last = attrs
+		return nil
+	}
+	delays := []time.Duration{time.Second, 10 * time.Second, time.Minute}
+	r := NewRetryTopics(send, "orders", delays, DefaultBatchRetryCountName)
+	handle := func(ctx context.Context, messages []Message[testUser]) ([]Message[testUser], error) {
+		return messages, errors.New("cannot write")
+	}
+	w := NewBatchWorker[testUser](10, 1000, nil, handle, nil, nil, r.HandleError, r.Retry, len(delays), "", false, "")
+
+	attrs := map[string]string{"key": "1"}
+	for i := 0; i <= len(delays); i++ {
+		d := &testDelivery{}
+		w.HandleBatch(context.Background(), []RawMessage{{Data: []byte(`{"id":"1"}`), Attributes: attrs, Delivery: d}})
+		if d.acks != 1 || d.nacks != 0 {
+			t.Fatalf("retry %d: acks = %d, nacks = %d, want 1, 0", i, d.acks, d.nacks)
+		}
+		attrs = last
+	}
+	want := []string{"orders-retry-1", "orders-retry-2", "orders-retry-3", "orders-dlq"}
+	if len(topics) != len(want) {
+		t.Fatalf("topics = %v, want %v", topics, want)
+	}
+	for i := range want {
+		if topics[i] != want[i] {
+			t.Errorf("topics = %v, want %v", topics, want)
+			break
+		}
+	}
+	if w.RetryCountName != "retryCount" {
+		t.Errorf("RetryCountName = %s, want retryCount", w.RetryCountName)
+	}
+	if last[DLQRetryCountName] != "3" {
+		t.Errorf("%s = %s, want 3", DLQRetryCountName, last[DLQRetryCountName])
+	}
+}
+
+// TestRetryTopicsFirstRetry checks that the first retry goes to tier 1, with or without attributes, and an invalid retry count is counted as 1
+func TestRetryTopicsFirstRetry(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs map[string]string
+		topic string
+	}{
+		{name: "no attributes", attrs: nil, topic: "orders-retry-1"},
+		{name: "no retry count", attrs: map[string]string{"key": "1"}, topic: "orders-retry-1"},
+		{name: "invalid retry count", attrs: map[string]string{DefaultBatchRetryCountName: "x"}, topic: "orders-retry-2"},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			var topic string
+			send := func(ctx context.Context, t string, data []byte, attrs map[string]string) error {
+				topic = t
+				return nil
+			}
+			r := NewRetryTopics(send, "orders", []time.Duration{time.Second, time.Minute}, DefaultBatchRetryCountName)
+			handle := func(ctx context.Context, messages []Message[testUser]) ([]Message[testUser], error) {
+				return messages, errors.New("cannot write")
+			}
+			w := NewBatchWorker[testUser](10, 1000, nil, handle, nil, nil, r.HandleError, r.Retry, 2, "", false, "")
+			w.HandleBatch(context.Background(), []RawMessage{{Data: []byte(`{"id":"1"}`), Attributes: c.attrs}})
+			if topic != c.topic {
+				t.Errorf("topic = %s, want %s", topic, c.topic)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/core-go/mq"
+)
+
+// BatchConsumerHandler passes the messages of each claim to Handle in batches. A batch has up to BatchSize messages,
+// and is passed to Handle when it is full, or after Timeout since its first message is received.
+// After Handle returns, the highest contiguous acked offset is marked, so the offsets are never committed before the messages are processed.
+// Kafka does not redeliver a message in a live session, so when a message of a batch is not acked, the error is logged and ConsumeClaim returns ErrNotAcked,
+// and the messages after the last committed offset are received again when the consumer restarts.
+// The messages of an incomplete batch are not handled when the session ends, so they are redelivered to the next session.
+type BatchConsumerHandler struct {
+	Topic        []string
+	BatchSize    int
+	Timeout      time.Duration
+	AckOnConsume bool
+	Handle       func(context.Context, []mq.RawMessage)
+	LogError     func(context.Context, string)
+	failed       int32
+}
+
+func NewBatchConsumerHandler(topic []string, handle func(context.Context, []mq.RawMessage), batchSize int, timeout time.Duration, ackOnConsume bool, logError ...func(context.Context, string)) *BatchConsumerHandler {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	h := &BatchConsumerHandler{Topic: topic, BatchSize: batchSize, Timeout: timeout, AckOnConsume: ackOnConsume, Handle: handle}
+	if len(logError) >= 1 {
+		h.LogError = logError[0]
+	}
+	return h
+}
+func (r *BatchConsumerHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+func (r *BatchConsumerHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+func (r *BatchConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	handle := mq.Recover(r.Handle, r.LogError)
+	var tracker mq.OffsetTracker
+	msgs := make([]*sarama.ConsumerMessage, 0, r.BatchSize)
+	var timer *time.Timer
+	var expired <-chan time.Time
+	flush := func() bool {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		if len(msgs) > 0 {
+			acked := r.handleBatch(session, &tracker, msgs, handle)
+			msgs = make([]*sarama.ConsumerMessage, 0, r.BatchSize)
+			return acked
+		}
+		return true
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			msgs = append(msgs, msg)
+			if len(msgs) >= r.BatchSize {
+				if !flush() {
+					return ErrNotAcked
+				}
+			} else if len(msgs) == 1 && r.Timeout > 0 {
+				timer = time.NewTimer(r.Timeout)
+				expired = timer.C
+			}
+		case <-expired:
+			timer, expired = nil, nil
+			if !flush() {
+				return ErrNotAcked
+			}
+		}
+	}
+}
+func (r *BatchConsumerHandler) handleBatch(session sarama.ConsumerGroupSession, tracker *mq.OffsetTracker, msgs []*sarama.ConsumerMessage, handle func(context.Context, []mq.RawMessage)) bool {
+	ctx := session.Context()
+	batch := make([]mq.RawMessage, len(msgs))
+	deliveries := make([]*mq.OffsetDelivery, len(msgs))
+	for i, msg := range msgs {
+		partition := Partition(msg)
+		tracker.Received(partition, msg.Offset)
+		deliveries[i] = mq.NewOffsetDelivery(partition, msg.Offset)
+		batch[i] = mq.RawMessage{Data: msg.Value, Attributes: HeaderToMap(msg.Headers), Delivery: deliveries[i]}
+	}
+	if r.AckOnConsume {
+		for _, msg := range msgs {
+			session.MarkMessage(msg, "")
+		}
+		handle(ctx, batch)
+		return true
+	}
+	handle(ctx, batch)
+	for _, i := range tracker.Commits(deliveries) {
+		session.MarkMessage(msgs[i], "")
+	}
+	for i, d := range deliveries {
+		if !d.Acked() {
+			atomic.StoreInt32(&r.failed, 1)
+			if r.LogError != nil {
+				r.LogError(ctx, fmt.Sprintf("Message at offset %d of %s is not acked. Stop consuming, so that it is received again when the consumer restarts", msgs[i].Offset, d.Partition))
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// Failed returns true after a message of a batch is not acked
+func (r *BatchConsumerHandler) Failed() bool {
+	return atomic.LoadInt32(&r.failed) == 1
+}
@@ -2,10 +2,11 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/IBM/sarama"
+	"github.com/core-go/mq"
 	"log"
-	"sync"
 	"time"
 )
 
@@ -14,6 +15,9 @@ type Consumer struct {
 	Topic         []string
 	AckOnConsume  bool
 	LogError      func(ctx context.Context, msg string)
+	HandleError   func(context.Context, []byte, map[string]string)
+	canceler      mq.Canceler
+	gate          mq.Gate
 }
 
 func NewConsumer(consumerGroup sarama.ConsumerGroup, topic []string, logError func(context.Context, string), ackOnConsume bool) (*Consumer, error) {
@@ -35,8 +39,11 @@ func NewConsumerByConfig(c ConsumerConfig, logError func(context.Context, string
 		config.Consumer.Offsets.Initial = *c.InitialOffsets
 	}
 	//sarama.Logger = log.New(os.Stdout, "[sarama] ", log.LstdFlags)
-	if c.Client.Retry != nil && c.Client.Retry.Retry1 > 0 {
-		durations := DurationsFromValue(*c.Client.Retry, "Retry", 9)
+	durations, err := c.Client.Retry.Durations()
+	if err != nil {
+		return nil, err
+	}
+	if len(durations) > 0 {
 		reader, er2 := NewConsumerGroupWithRetryArray(c.Brokers, c.GroupID, config, durations)
 		if er2 != nil {
 			return nil, er2
@@ -50,32 +57,50 @@ func NewConsumerByConfig(c ConsumerConfig, logError func(context.Context, string
 		return NewConsumer(reader, []string{c.Topic}, logError, ackOnConsume)
 	}
 }
+
+// Consume reads the messages until ctx is done, Close is called or a message is nacked. See ConsumerHandler.
 func (c *Consumer) Consume(ctx context.Context, handle func(context.Context, []byte, map[string]string)) {
-	readerHandler := &ConsumerHandler{Topic: c.Topic, AckOnConsume: c.AckOnConsume, Handle: handle}
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for {
-			// `Consume` should be called inside an infinite loop, when a
-			// server-side rebalance happens, the consumer session will need to be
-			// recreated to get the new claims
-			if err := c.ConsumerGroup.Consume(ctx, c.Topic, readerHandler); err != nil {
-				c.LogError(ctx, "Error when read: "+err.Error())
-			}
-			// check if context was cancelled, signaling that the consumer should stop
-			if ctx.Err() != nil {
-				c.LogError(ctx, "Error when read: "+ctx.Err().Error())
-			}
-		}
-	}()
+	readerHandler := &ConsumerHandler{Topic: c.Topic, AckOnConsume: c.AckOnConsume, Handle: func(ctx context.Context, data []byte, attrs map[string]string) {
+		handle(mq.WithPauser(ctx, c), data, attrs)
+	}, LogError: c.LogError, HandleError: c.HandleError}
+	c.consume(ctx, readerHandler)
+}
+
+// ConsumeBatch reads the messages until ctx is done, Close is called or a message is not acked, and passes them to handle in batches, such as BatchWorker.HandleBatch. See BatchConsumerHandler.
+func (c *Consumer) ConsumeBatch(ctx context.Context, batchSize int, timeout time.Duration, handle func(context.Context, []mq.RawMessage)) {
+	readerHandler := NewBatchConsumerHandler(c.Topic, func(ctx context.Context, msgs []mq.RawMessage) {
+		handle(mq.WithPauser(ctx, c), msgs)
+	}, batchSize, timeout, c.AckOnConsume, c.LogError)
+	c.consume(ctx, readerHandler)
+}
+func (c *Consumer) consume(ctx context.Context, readerHandler sarama.ConsumerGroupHandler) {
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
 	go func() {
 		for err := range c.ConsumerGroup.Errors() {
 			c.LogError(ctx, "Error when read: "+err.Error())
 		}
 	}()
-	wg.Wait()
-	if err := c.ConsumerGroup.Close(); err != nil {
+	for {
+		// `Consume` should be called inside an infinite loop, when a
+		// server-side rebalance happens, the consumer session will need to be
+		// recreated to get the new claims
+		if err := c.ConsumerGroup.Consume(ctx2, c.Topic, readerHandler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return
+			}
+			c.LogError(ctx, "Error when read: "+err.Error())
+		}
+		// check if context was cancelled, signaling that the consumer should stop
+		if ctx2.Err() != nil {
+			break
+		}
+		// stop if a message is not acked, so that it is received again when the consumer restarts
+		if h, ok := readerHandler.(interface{ Failed() bool }); ok && h.Failed() {
+			break
+		}
+	}
+	if err := c.ConsumerGroup.Close(); err != nil && !errors.Is(err, sarama.ErrClosedConsumerGroup) {
 		log.Printf("Error closing client: %v\n", err)
 	}
 }
@@ -83,33 +108,29 @@ func (c *Consumer) ConsumeValue(ctx context.Context, handle func(context.Context
 	newHandle := func(ctx context.Context, value []byte, attrs map[string]string) {
 		handle(ctx, value)
 	}
-	readerHandler := &ConsumerHandler{Topic: c.Topic, AckOnConsume: c.AckOnConsume, Handle: newHandle}
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for {
-			// `Consume` should be called inside an infinite loop, when a
-			// server-side rebalance happens, the consumer session will need to be
-			// recreated to get the new claims
-			if err := c.ConsumerGroup.Consume(ctx, c.Topic, readerHandler); err != nil {
-				c.LogError(ctx, "Error when read: "+err.Error())
-			}
-			// check if context was cancelled, signaling that the consumer should stop
-			if ctx.Err() != nil {
-				c.LogError(ctx, "Error when read: "+ctx.Err().Error())
-			}
-		}
-	}()
-	go func() {
-		for err := range c.ConsumerGroup.Errors() {
-			c.LogError(ctx, "Error when read: "+err.Error())
-		}
-	}()
-	wg.Wait()
-	if err := c.ConsumerGroup.Close(); err != nil {
-		log.Printf("Error closing client: %v\n", err)
+	c.Consume(ctx, newHandle)
+}
+
+// Pause stops fetching the partitions, and the consumer group still sends the heartbeats. The partitions which are claimed after a rebalance are not paused.
+func (c *Consumer) Pause() error {
+	c.ConsumerGroup.PauseAll()
+	return c.gate.Pause()
+}
+func (c *Consumer) Resume() error {
+	c.ConsumerGroup.ResumeAll()
+	return c.gate.Resume()
+}
+func (c *Consumer) Paused() bool {
+	return c.gate.Paused()
+}
+
+// Close stops the consuming loops, and closes the consumer group
+func (c *Consumer) Close() error {
+	c.canceler.Cancel()
+	if err := c.ConsumerGroup.Close(); err != nil && !errors.Is(err, sarama.ErrClosedConsumerGroup) {
+		return err
 	}
+	return nil
 }
 func NewConsumerGroup(addrs []string, groupID string, config *sarama.Config, retries ...time.Duration) (*sarama.ConsumerGroup, error) {
 	if len(retries) == 0 {
@@ -0,0 +1,12 @@
+package kafka
+
+import (
+	"strconv"
+
+	"github.com/IBM/sarama"
+)
+
+// Partition is the key of the partition of the message in mq.OffsetTracker and mq.OffsetCommitter
+func Partition(msg *sarama.ConsumerMessage) string {
+	return msg.Topic + ":" + strconv.Itoa(int(msg.Partition))
+}
@@ -2,17 +2,36 @@ package kafka
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/IBM/sarama"
+	"github.com/core-go/mq"
+	"sync"
+	"sync/atomic"
 )
 
+// ErrNotAcked is returned by ConsumeClaim when a message is nacked, or a message of a batch is not acked. It ends the session, and Consumer stops.
+var ErrNotAcked = errors.New("message is not acked")
+
+// ConsumerHandler passes the messages of each claim to Handle. If AckOnConsume is false, the offsets are marked by mq.OffsetCommitter:
+// the acks only record the state of the messages, and each partition is marked up to its highest contiguous acked offset, so the handler can ack out of order, such as by Goroutines or WorkerPool.
+// Kafka does not redeliver a message in a live session, so when a message is nacked, the error is logged and ConsumeClaim returns ErrNotAcked,
+// and the messages after the last committed offset are received again when the consumer restarts.
 type ConsumerHandler struct {
 	Topic        []string
 	AckOnConsume bool
 	Handle       func(context.Context, []byte, map[string]string)
+	LogError     func(context.Context, string)
+	HandleError  func(context.Context, []byte, map[string]string)
+	failed       int32
 }
 
-func NewConsumerHandler(Topic []string, handle func(context.Context, []byte, map[string]string), ackOnConsume bool) *ConsumerHandler {
-	return &ConsumerHandler{Topic: Topic, AckOnConsume: ackOnConsume, Handle: handle}
+func NewConsumerHandler(Topic []string, handle func(context.Context, []byte, map[string]string), ackOnConsume bool, logError ...func(context.Context, string)) *ConsumerHandler {
+	h := &ConsumerHandler{Topic: Topic, AckOnConsume: ackOnConsume, Handle: handle}
+	if len(logError) >= 1 {
+		h.LogError = logError[0]
+	}
+	return h
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
@@ -29,14 +48,49 @@ func (r *ConsumerHandler) Cleanup(sarama.ConsumerGroupSession) error {
 
 // ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
 func (r *ConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for msg := range claim.Messages() {
-		attributes := HeaderToMap(msg.Headers)
-		if r.AckOnConsume {
+	handle := mq.RecoverWithMap(r.Handle, r.LogError, r.HandleError)
+	nacked := make(chan struct{})
+	var once sync.Once
+	committer := mq.NewOffsetCommitter(func(ctx context.Context, msgs []*sarama.ConsumerMessage) error {
+		for _, msg := range msgs {
 			session.MarkMessage(msg, "")
 		}
-		r.Handle(session.Context(), msg.Value, attributes)
+		return nil
+	}, func(ctx context.Context, msg *sarama.ConsumerMessage) {
+		r.fail(ctx, fmt.Sprintf("Message at offset %d of %s is nacked. Stop consuming, so that it is received again when the consumer restarts", msg.Offset, Partition(msg)))
+		once.Do(func() {
+			close(nacked)
+		})
+	})
+	for !r.Failed() {
+		select {
+		case <-nacked:
+			return ErrNotAcked
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			attributes := HeaderToMap(msg.Headers)
+			if r.AckOnConsume {
+				session.MarkMessage(msg, "")
+				handle(session.Context(), msg.Value, attributes)
+			} else {
+				handle(mq.WithDelivery(session.Context(), committer.Track(Partition(msg), msg.Offset, msg)), msg.Value, attributes)
+			}
+		}
+	}
+	return ErrNotAcked
+}
+
+// Failed returns true after a message is nacked
+func (r *ConsumerHandler) Failed() bool {
+	return atomic.LoadInt32(&r.failed) == 1
+}
+func (r *ConsumerHandler) fail(ctx context.Context, msg string) {
+	atomic.StoreInt32(&r.failed, 1)
+	if r.LogError != nil {
+		r.LogError(ctx, msg)
 	}
-	return nil
 }
 
 func HeaderToMap(headers []*sarama.RecordHeader) map[string]string {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/IBM/sarama"
+	"github.com/core-go/mq"
 	"log"
 	"time"
 )
@@ -33,8 +34,11 @@ func NewProducerByConfig(c ProducerConfig) (*Producer, error) {
 	return NewProducer(*writer, c.Topic)
 }
 func newSyncProducer(c ProducerConfig) (*sarama.SyncProducer, error) {
-	if c.Client.Retry != nil && c.Client.Retry.Retry1 > 0 {
-		durations := DurationsFromValue(*c.Client.Retry, "Retry", 9)
+	durations, err := c.Client.Retry.Durations()
+	if err != nil {
+		return nil, err
+	}
+	if len(durations) > 0 {
 		return NewSyncProducerWithRetryArray(c, durations)
 	} else {
 		return NewSyncProducer(c)
@@ -122,6 +126,16 @@ func (p *Producer) Produce(ctx context.Context, data []byte, messageAttributes m
 	_, _, err := p.SyncProducer.SendMessage(&msg)
 	return err
 }
+
+// ProduceWithDelay produces the message with the mq.NotBeforeName header, so the consumer of the retry topic waits until the delay passes, by mq.WaitNotBefore. It is the Send of mq.DelayedRetry.
+func (p *Producer) ProduceWithDelay(ctx context.Context, data []byte, messageAttributes map[string]string, delay time.Duration) error {
+	attrs := make(map[string]string, len(messageAttributes)+1)
+	for k, v := range messageAttributes {
+		attrs[k] = v
+	}
+	attrs[mq.NotBeforeName] = mq.NotBefore(delay)
+	return p.Produce(ctx, data, attrs)
+}
 func (p *Producer) ProduceValue(ctx context.Context, data []byte) error {
 	msg := sarama.ProducerMessage{Value: sarama.ByteEncoder(data), Topic: p.Topic}
 	_, _, err := p.SyncProducer.SendMessage(&msg)
@@ -0,0 +1,51 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/core-go/mq"
+)
+
+// NewRetryTopics creates the retry topics of topic, which are produced by producer
+func NewRetryTopics(producer sarama.SyncProducer, topic string, delays []time.Duration, retryCountName string, logs ...func(context.Context, string)) *mq.RetryTopics {
+	send := func(ctx context.Context, topic string, data []byte, attributes map[string]string) error {
+		msg := sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(data), Headers: MapToHeader(attributes)}
+		_, _, err := producer.SendMessage(&msg)
+		return err
+	}
+	return mq.NewRetryTopics(send, topic, delays, retryCountName, logs...)
+}
+
+// RetryConsumer consumes the retry topics of all tiers with one consumer group, and waits until the time of mq.NotBeforeName of each message before handling it.
+// Sarama consumes each partition in its own goroutine, so a message waits only for the earlier messages of its partition, and the waiting stops when the session ends.
+type RetryConsumer struct {
+	Consumer *Consumer
+}
+
+func NewRetryConsumer(consumerGroup sarama.ConsumerGroup, topics []string, logError func(context.Context, string)) (*RetryConsumer, error) {
+	consumer, err := NewConsumer(consumerGroup, topics, logError, false)
+	if err != nil {
+		return nil, err
+	}
+	return &RetryConsumer{Consumer: consumer}, nil
+}
+
+// NewRetryConsumerByConfig creates a consumer of topics, such as RetryTopics.Topics(), with the config of c
+func NewRetryConsumerByConfig(c ConsumerConfig, topics []string, logError func(context.Context, string)) (*RetryConsumer, error) {
+	consumer, err := NewConsumerByConfig(c, logError, false)
+	if err != nil {
+		return nil, err
+	}
+	consumer.Topic = topics
+	return &RetryConsumer{Consumer: consumer}, nil
+}
+
+// Consume reads all tiers until ctx is done or Close is called. The messages of the retry topics are handled by handle, which is the handle of the original topic.
+func (c *RetryConsumer) Consume(ctx context.Context, handle func(context.Context, []byte, map[string]string)) {
+	c.Consumer.Consume(ctx, mq.Chain(handle, mq.WaitNotBefore(c.Consumer.LogError)))
+}
+func (c *RetryConsumer) Close() error {
+	return c.Consumer.Close()
+}
@@ -0,0 +1,83 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DedupStore keeps the IDs of the processed messages in a table, which has the id column as the primary key and a timestamp column, for example:
+// create table processed_messages (id varchar(255) not null primary key, processed_at timestamp not null)
+// If ttl is greater than 0, the IDs which are older than ttl are considered as not processed, and can be removed by Clean.
+type DedupStore struct {
+	DB          *sql.DB
+	Table       string
+	Id          string
+	ProcessedAt string
+	TTL         time.Duration
+	BuildParam  func(i int) string
+}
+
+func NewDedupStore(db *sql.DB, table string, ttl time.Duration, options ...string) *DedupStore {
+	id := "id"
+	processedAt := "processed_at"
+	if len(options) >= 1 && len(options[0]) > 0 {
+		id = options[0]
+	}
+	if len(options) >= 2 && len(options[1]) > 0 {
+		processedAt = options[1]
+	}
+	return &DedupStore{DB: db, Table: table, Id: id, ProcessedAt: processedAt, TTL: ttl, BuildParam: GetBuild(db)}
+}
+
+func (s *DedupStore) Exists(ctx context.Context, id string) (bool, error) {
+	query := fmt.Sprintf("select %s from %s where %s = %s", s.Id, s.Table, s.Id, s.BuildParam(1))
+	args := []interface{}{id}
+	if s.TTL > 0 {
+		query = query + fmt.Sprintf(" and %s > %s", s.ProcessedAt, s.BuildParam(2))
+		args = append(args, time.Now().Add(-s.TTL))
+	}
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	exist := rows.Next()
+	return exist, rows.Err()
+}
+
+// Save inserts the id, or updates the timestamp if the id exists
+func (s *DedupStore) Save(ctx context.Context, id string) error {
+	now := time.Now()
+	query := fmt.Sprintf("update %s set %s = %s where %s = %s", s.Table, s.ProcessedAt, s.BuildParam(1), s.Id, s.BuildParam(2))
+	res, err := s.DB.ExecContext(ctx, query, now, id)
+	if err != nil {
+		return err
+	}
+	if n, er1 := res.RowsAffected(); er1 == nil && n > 0 {
+		return nil
+	}
+	query = fmt.Sprintf("insert into %s (%s, %s) values (%s, %s)", s.Table, s.Id, s.ProcessedAt, s.BuildParam(1), s.BuildParam(2))
+	_, err = s.DB.ExecContext(ctx, query, id, now)
+	if err != nil {
+		// the id can be saved by another consumer at the same time
+		if exist, er2 := s.Exists(ctx, id); er2 == nil && exist {
+			return nil
+		}
+	}
+	return err
+}
+
+// Clean removes the IDs which are older than ttl
+func (s *DedupStore) Clean(ctx context.Context) (int64, error) {
+	if s.TTL <= 0 {
+		return 0, nil
+	}
+	query := fmt.Sprintf("delete from %s where %s <= %s", s.Table, s.ProcessedAt, s.BuildParam(1))
+	res, err := s.DB.ExecContext(ctx, query, time.Now().Add(-s.TTL))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,170 @@
+package sql
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Outbox inserts the messages into the outbox table, in the transaction of the business data. OutboxRelay publishes them later.
+// The columns of the table are id, destination, data, attributes, created_at, sent_at, locked_by, locked_until, attempts and last_error. See OutboxDDL.
+type Outbox struct {
+	Table      string
+	Driver     string
+	BuildParam func(i int) string
+}
+
+func NewOutbox(db *sql.DB, table string) *Outbox {
+	return NewOutboxByDriver(GetDriver(db), table)
+}
+func NewOutboxByDriver(driver string, table string) *Outbox {
+	return &Outbox{Table: table, Driver: driver, BuildParam: GetBuildByDriver(driver)}
+}
+
+// Add inserts a message into the outbox table in tx, and returns the id of the message
+func (o *Outbox) Add(ctx context.Context, tx *sql.Tx, destination string, data []byte, attrs map[string]string) (string, error) {
+	id, err := NewOutboxID()
+	if err != nil {
+		return "", err
+	}
+	var attributes string
+	if len(attrs) > 0 {
+		bs, er1 := json.Marshal(attrs)
+		if er1 != nil {
+			return "", er1
+		}
+		attributes = string(bs)
+	}
+	query := fmt.Sprintf("insert into %s (id, destination, data, attributes, created_at, attempts) values (%s, %s, %s, %s, %s, 0)",
+		o.Table, o.BuildParam(1), o.BuildParam(2), o.BuildParam(3), o.BuildParam(4), o.BuildParam(5))
+	_, err = tx.ExecContext(ctx, query, id, destination, data, attributes, time.Now().UTC())
+	return id, err
+}
+
+// OutboxWriter inserts the model and the outbox message of the model in the same transaction, so that the message is published if and only if the model is saved.
+// Marshal builds the message of the model. If Marshal is nil, the message is the json of the model.
+type OutboxWriter[T any] struct {
+	db          *sql.DB
+	tableName   string
+	BuildParam  func(i int) string
+	Map         func(T)
+	BoolSupport bool
+	schema      *Schema
+	Outbox      *Outbox
+	Destination string
+	Marshal     func(T) ([]byte, map[string]string, error)
+	ToArray     func(interface{}) interface {
+		driver.Valuer
+		sql.Scanner
+	}
+}
+
+func NewOutboxWriter[T any](db *sql.DB, tableName string, outbox *Outbox, destination string, options ...func(T) ([]byte, map[string]string, error)) *OutboxWriter[T] {
+	var marshal func(T) ([]byte, map[string]string, error)
+	if len(options) >= 1 {
+		marshal = options[0]
+	}
+	var t T
+	modelType := reflect.TypeOf(t)
+	if modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+	driver := GetDriver(db)
+	return &OutboxWriter[T]{db: db, tableName: tableName, BuildParam: GetBuild(db), BoolSupport: driver == DriverPostgres, schema: CreateSchema(modelType), Outbox: outbox, Destination: destination, Marshal: marshal}
+}
+
+func (w *OutboxWriter[T]) Write(ctx context.Context, model T) error {
+	if w.Map != nil {
+		w.Map(model)
+	}
+	var data []byte
+	var attrs map[string]string
+	var err error
+	if w.Marshal != nil {
+		data, attrs, err = w.Marshal(model)
+	} else {
+		data, err = json.Marshal(model)
+	}
+	if err != nil {
+		return err
+	}
+	return ExecuteTx(ctx, w.db, func(tx *sql.Tx) error {
+		query, values := BuildToInsertWithSchema(w.tableName, model, -1, w.BuildParam, w.BoolSupport, false, w.ToArray, w.schema)
+		if _, er1 := tx.ExecContext(ctx, query, values...); er1 != nil {
+			return er1
+		}
+		_, er2 := w.Outbox.Add(ctx, tx, w.Destination, data, attrs)
+		return er2
+	})
+}
+
+// ExecuteTx runs f in a transaction, then commits it, or rolls it back if f returns an error
+func ExecuteTx(ctx context.Context, db *sql.DB, f func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func NewOutboxID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// OutboxDDL returns the statements to create the outbox table and its index, for postgres, mysql, mssql, oracle and sqlite3
+func OutboxDDL(driver string, table string) []string {
+	var bytes, text, timestamp, str, integer string
+	switch driver {
+	case DriverPostgres:
+		bytes, text, timestamp, str, integer = "bytea", "text", "timestamp", "varchar", "integer"
+	case DriverMysql:
+		bytes, text, timestamp, str, integer = "longblob", "text", "datetime(6)", "varchar", "int"
+	case DriverMssql:
+		bytes, text, timestamp, str, integer = "varbinary(max)", "nvarchar(max)", "datetime2", "nvarchar", "int"
+	case DriverOracle:
+		bytes, text, timestamp, str, integer = "blob", "clob", "timestamp", "varchar2", "number(10)"
+	default:
+		bytes, text, timestamp, str, integer = "blob", "text", "timestamp", "varchar", "integer"
+	}
+	columns := []string{
+		fmt.Sprintf("id %s(40) not null primary key", str),
+		fmt.Sprintf("destination %s(255) not null", str),
+		fmt.Sprintf("data %s not null", bytes),
+		fmt.Sprintf("attributes %s", text),
+		fmt.Sprintf("created_at %s not null", timestamp),
+		fmt.Sprintf("sent_at %s", timestamp),
+		fmt.Sprintf("locked_by %s(255)", str),
+		fmt.Sprintf("locked_until %s", timestamp),
+		fmt.Sprintf("attempts %s default 0 not null", integer),
+		fmt.Sprintf("last_error %s(1000)", str),
+	}
+	return []string{
+		fmt.Sprintf("create table %s (%s)", table, strings.Join(columns, ", ")),
+		fmt.Sprintf("create index %s_sent_at on %s (sent_at, created_at)", table, table),
+	}
+}
+
+// CreateOutboxTable creates the outbox table and its index
+func CreateOutboxTable(ctx context.Context, db *sql.DB, table string) error {
+	for _, stmt := range OutboxDDL(GetDriver(db), table) {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
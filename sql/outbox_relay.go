@@ -0,0 +1,250 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Interval, LockTimeout and RetryDelay are in milliseconds
+type OutboxConfig struct {
+	Table       string `yaml:"table" mapstructure:"table" json:"table,omitempty" gorm:"column:table" bson:"table,omitempty" dynamodbav:"table,omitempty" firestore:"table,omitempty"`
+	Destination string `yaml:"destination" mapstructure:"destination" json:"destination,omitempty" gorm:"column:destination" bson:"destination,omitempty" dynamodbav:"destination,omitempty" firestore:"destination,omitempty"`
+	BatchSize   int    `yaml:"batch_size" mapstructure:"batch_size" json:"batchSize,omitempty" gorm:"column:batchsize" bson:"batchSize,omitempty" dynamodbav:"batchSize,omitempty" firestore:"batchSize,omitempty"`
+	Interval    int64  `yaml:"interval" mapstructure:"interval" json:"interval,omitempty" gorm:"column:interval" bson:"interval,omitempty" dynamodbav:"interval,omitempty" firestore:"interval,omitempty"`
+	LockTimeout int64  `yaml:"lock_timeout" mapstructure:"lock_timeout" json:"lockTimeout,omitempty" gorm:"column:locktimeout" bson:"lockTimeout,omitempty" dynamodbav:"lockTimeout,omitempty" firestore:"lockTimeout,omitempty"`
+	RetryDelay  int64  `yaml:"retry_delay" mapstructure:"retry_delay" json:"retryDelay,omitempty" gorm:"column:retrydelay" bson:"retryDelay,omitempty" dynamodbav:"retryDelay,omitempty" firestore:"retryDelay,omitempty"`
+}
+
+// OutboxRelay polls the unsent messages of the outbox table, publishes them by Send, and marks them sent.
+// Many relays can run on the same table: each relay locks the rows before publishing them, by updating locked_by and locked_until, so a row is published by only one relay.
+// If a relay stops before the row is marked sent, the lock expires after LockTimeout, then the row is published again, so the consumers should be idempotent.
+// The messages are published in the order of created_at by each relay, but the order is not guaranteed between the relays,
+// and a message which cannot be published is retried after RetryDelay, so it can be published after the later messages.
+// If Destination is not empty, only the messages of Destination are published. Send receives the destination of each message.
+type OutboxRelay struct {
+	DB          *sql.DB
+	Table       string
+	Destination string
+	Send        func(ctx context.Context, destination string, data []byte, attributes map[string]string) error
+	BatchSize   int
+	Interval    time.Duration
+	LockTimeout time.Duration
+	RetryDelay  time.Duration
+	Instance    string
+	Driver      string
+	BuildParam  func(i int) string
+	LogError    func(context.Context, string)
+	LogInfo     func(context.Context, string)
+}
+
+func NewOutboxRelay(db *sql.DB, table string, destination string, send func(context.Context, string, []byte, map[string]string) error, batchSize int, interval time.Duration, lockTimeout time.Duration, logs ...func(context.Context, string)) *OutboxRelay {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if lockTimeout <= 0 {
+		lockTimeout = time.Minute
+	}
+	instance, _ := os.Hostname()
+	if id, err := NewOutboxID(); err == nil {
+		instance = instance + "-" + id[:8]
+	}
+	driver := GetDriver(db)
+	r := &OutboxRelay{DB: db, Table: table, Destination: destination, Send: send, BatchSize: batchSize, Interval: interval, LockTimeout: lockTimeout, Instance: instance, Driver: driver, BuildParam: GetBuildByDriver(driver)}
+	if len(logs) >= 1 {
+		r.LogError = logs[0]
+	}
+	if len(logs) >= 2 {
+		r.LogInfo = logs[1]
+	}
+	return r
+}
+
+// NewOutboxRelayBySender is used when the destination is fixed, such as kafka Writer or sqs Sender, so only the messages of destination are published
+func NewOutboxRelayBySender(db *sql.DB, table string, destination string, send func(context.Context, []byte, map[string]string) error, batchSize int, interval time.Duration, lockTimeout time.Duration, logs ...func(context.Context, string)) (*OutboxRelay, error) {
+	if len(destination) == 0 {
+		return nil, errors.New("destination is required, so that the messages of other destinations are not published by send")
+	}
+	send2 := func(ctx context.Context, destination string, data []byte, attributes map[string]string) error {
+		return send(ctx, data, attributes)
+	}
+	return NewOutboxRelay(db, table, destination, send2, batchSize, interval, lockTimeout, logs...), nil
+}
+func NewOutboxRelayByConfig(db *sql.DB, c OutboxConfig, send func(context.Context, string, []byte, map[string]string) error, logs ...func(context.Context, string)) *OutboxRelay {
+	r := NewOutboxRelay(db, c.Table, c.Destination, send, c.BatchSize, time.Duration(c.Interval)*time.Millisecond, time.Duration(c.LockTimeout)*time.Millisecond, logs...)
+	r.RetryDelay = time.Duration(c.RetryDelay) * time.Millisecond
+	return r
+}
+
+// Run polls the outbox table every Interval until ctx is done. If a poll publishes a full batch, the next poll starts immediately.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := r.Relay(ctx)
+		if err != nil && ctx.Err() == nil && r.LogError != nil {
+			r.LogError(ctx, "Error when relay outbox: "+err.Error())
+		}
+		if err == nil && n >= r.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay publishes a batch of the unsent messages, and returns the number of the published messages.
+// It stops at the first message which cannot be published, to keep the order.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	ids, err := r.selectIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, id := range ids {
+		destination, data, attrs, locked, er1 := r.lock(ctx, id)
+		if er1 != nil {
+			return count, er1
+		}
+		if !locked {
+			continue
+		}
+		if er2 := r.Send(ctx, destination, data, attrs); er2 != nil {
+			if r.LogError != nil {
+				r.LogError(ctx, fmt.Sprintf("Cannot publish outbox message %s. Error: %s", id, er2.Error()))
+			}
+			return count, r.fail(ctx, id, er2)
+		}
+		// the message is published, so it is marked sent even if ctx is done, such as on shutdown, to avoid publishing it again
+		if er4 := r.markSent(detach(ctx), id); er4 != nil {
+			return count, er4
+		}
+		count++
+	}
+	if count > 0 && r.LogInfo != nil {
+		r.LogInfo(ctx, fmt.Sprintf("Relayed %d outbox messages", count))
+	}
+	return count, nil
+}
+
+func (r *OutboxRelay) selectIDs(ctx context.Context) ([]string, error) {
+	now := time.Now().UTC()
+	where := fmt.Sprintf("sent_at is null and (locked_until is null or locked_until < %s)", r.BuildParam(1))
+	args := []interface{}{now}
+	if len(r.Destination) > 0 {
+		where = where + fmt.Sprintf(" and destination = %s", r.BuildParam(2))
+		args = append(args, r.Destination)
+	}
+	limit := strconv.Itoa(r.BatchSize)
+	var query string
+	switch r.Driver {
+	case DriverMssql:
+		query = fmt.Sprintf("select top %s id from %s where %s order by created_at", limit, r.Table, where)
+	case DriverOracle:
+		query = fmt.Sprintf("select id from %s where %s order by created_at fetch first %s rows only", r.Table, where, limit)
+	default:
+		query = fmt.Sprintf("select id from %s where %s order by created_at limit %s", r.Table, where, limit)
+	}
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// lock locks the row by a conditional update, so only one relay can lock it, then loads the message
+func (r *OutboxRelay) lock(ctx context.Context, id string) (string, []byte, map[string]string, bool, error) {
+	now := time.Now().UTC()
+	query := fmt.Sprintf("update %s set locked_by = %s, locked_until = %s where id = %s and sent_at is null and (locked_until is null or locked_until < %s)",
+		r.Table, r.BuildParam(1), r.BuildParam(2), r.BuildParam(3), r.BuildParam(4))
+	res, err := r.DB.ExecContext(ctx, query, r.Instance, now.Add(r.LockTimeout), id, now)
+	if err != nil {
+		return "", nil, nil, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return "", nil, nil, false, err
+	}
+	var destination string
+	var data []byte
+	var attributes sql.NullString
+	query = fmt.Sprintf("select destination, data, attributes from %s where id = %s", r.Table, r.BuildParam(1))
+	if err = r.DB.QueryRowContext(ctx, query, id).Scan(&destination, &data, &attributes); err != nil {
+		return "", nil, nil, false, err
+	}
+	var attrs map[string]string
+	if attributes.Valid && len(attributes.String) > 0 {
+		if err = json.Unmarshal([]byte(attributes.String), &attrs); err != nil {
+			return "", nil, nil, false, err
+		}
+	}
+	return destination, data, attrs, true, nil
+}
+func (r *OutboxRelay) markSent(ctx context.Context, id string) error {
+	query := fmt.Sprintf("update %s set sent_at = %s, locked_by = null, locked_until = null where id = %s and locked_by = %s",
+		r.Table, r.BuildParam(1), r.BuildParam(2), r.BuildParam(3))
+	_, err := r.DB.ExecContext(ctx, query, time.Now().UTC(), id, r.Instance)
+	return err
+}
+
+// fail keeps the row locked until RetryDelay, and saves the error
+func (r *OutboxRelay) fail(ctx context.Context, id string, err error) error {
+	message := truncate(err.Error(), 1000)
+	query := fmt.Sprintf("update %s set attempts = attempts + 1, last_error = %s, locked_by = null, locked_until = %s where id = %s and locked_by = %s",
+		r.Table, r.BuildParam(1), r.BuildParam(2), r.BuildParam(3), r.BuildParam(4))
+	_, er1 := r.DB.ExecContext(ctx, query, message, time.Now().UTC().Add(r.RetryDelay), id, r.Instance)
+	return er1
+}
+
+// detachedContext keeps the values of the parent context, without its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+func (c detachedContext) Err() error {
+	return nil
+}
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// truncate cuts s to at most n bytes, without splitting a UTF-8 character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
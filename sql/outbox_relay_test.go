@@ -0,0 +1,221 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const outboxTable = "outbox"
+
+func openOutbox(t *testing.T, messages ...string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an in-memory database exists only in its connection
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	ctx := context.Background()
+	if err = CreateOutboxTable(ctx, db, outboxTable); err != nil {
+		t.Fatal(err)
+	}
+	outbox := NewOutbox(db, outboxTable)
+	for _, msg := range messages {
+		err = ExecuteTx(ctx, db, func(tx *sql.Tx) error {
+			_, er1 := outbox.Add(ctx, tx, "orders", []byte(msg), map[string]string{"type": "order"})
+			return er1
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// created_at orders the messages
+		time.Sleep(time.Millisecond)
+	}
+	return db
+}
+
+type outboxRow struct {
+	sent     bool
+	lockedBy sql.NullString
+	attempts int
+	err      sql.NullString
+}
+
+func getOutboxRow(t *testing.T, db *sql.DB, data string) outboxRow {
+	t.Helper()
+	var row outboxRow
+	var sentAt sql.NullString
+	query := fmt.Sprintf("select sent_at, locked_by, attempts, last_error from %s where data = ?", outboxTable)
+	if err := db.QueryRow(query, []byte(data)).Scan(&sentAt, &row.lockedBy, &row.attempts, &row.err); err != nil {
+		t.Fatal(err)
+	}
+	row.sent = sentAt.Valid
+	return row
+}
+
+type outboxSender struct {
+	mu   sync.Mutex
+	sent []string
+	fail map[string]error
+}
+
+func (s *outboxSender) send(ctx context.Context, destination string, data []byte, attrs map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail[string(data)]; err != nil {
+		return err
+	}
+	if destination != "orders" || attrs["type"] != "order" {
+		return fmt.Errorf("destination %s and attributes %v are not loaded", destination, attrs)
+	}
+	s.sent = append(s.sent, string(data))
+	return nil
+}
+
+func TestOutboxRelay(t *testing.T) {
+	ctx := context.Background()
+	db := openOutbox(t, "1", "2", "3")
+	s := &outboxSender{}
+	r := NewOutboxRelay(db, outboxTable, "orders", s.send, 10, time.Second, time.Minute)
+	n, err := r.Relay(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("Relay() = %d, %v, want 3, nil", n, err)
+	}
+	if fmt.Sprint(s.sent) != "[1 2 3]" {
+		t.Errorf("sent = %v, want [1 2 3] in the order of created_at", s.sent)
+	}
+	for _, data := range []string{"1", "2", "3"} {
+		if row := getOutboxRow(t, db, data); !row.sent || row.lockedBy.Valid {
+			t.Errorf("message %s: sent %v, locked by %v, want sent and unlocked", data, row.sent, row.lockedBy.String)
+		}
+	}
+	if n, err = r.Relay(ctx); err != nil || n != 0 {
+		t.Errorf("Relay() = %d, %v, want 0, nil after all messages are sent", n, err)
+	}
+}
+
+func TestOutboxRelayRetry(t *testing.T) {
+	ctx := context.Background()
+	db := openOutbox(t, "1", "2")
+	s := &outboxSender{fail: map[string]error{"1": errors.New("broker is down")}}
+	r := NewOutboxRelay(db, outboxTable, "orders", s.send, 10, time.Second, time.Minute)
+	r.RetryDelay = 50 * time.Millisecond
+	n, err := r.Relay(ctx)
+	if err != nil || n != 0 {
+		t.Fatalf("Relay() = %d, %v, want 0, nil, the relay stops at the failed message", n, err)
+	}
+	row := getOutboxRow(t, db, "1")
+	if row.sent || row.lockedBy.Valid || row.attempts != 1 || row.err.String != "broker is down" {
+		t.Errorf("failed message = %+v, want not sent, unlocked, 1 attempt and the error", row)
+	}
+	s.fail = nil
+	if n, _ = r.Relay(ctx); n != 1 || fmt.Sprint(s.sent) != "[2]" {
+		t.Errorf("Relay() = %d, sent = %v, want the failed message to wait for RetryDelay", n, s.sent)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if n, _ = r.Relay(ctx); n != 1 || fmt.Sprint(s.sent) != "[2 1]" {
+		t.Errorf("Relay() = %d, sent = %v, want the failed message after RetryDelay", n, s.sent)
+	}
+	if row = getOutboxRow(t, db, "1"); !row.sent {
+		t.Error("the retried message is not marked sent")
+	}
+}
+
+func TestOutboxRelayLock(t *testing.T) {
+	ctx := context.Background()
+	db := openOutbox(t, "1")
+	s := &outboxSender{}
+	r1 := NewOutboxRelay(db, outboxTable, "orders", s.send, 10, time.Second, 50*time.Millisecond)
+	r2 := NewOutboxRelay(db, outboxTable, "orders", s.send, 10, time.Second, 50*time.Millisecond)
+	r1.Instance, r2.Instance = "relay1", "relay2"
+	ids, err := r1.selectIDs(ctx)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("selectIDs() = %v, %v", ids, err)
+	}
+	if _, _, _, locked, er1 := r1.lock(ctx, ids[0]); !locked || er1 != nil {
+		t.Fatalf("relay1 lock() = %v, %v, want true", locked, er1)
+	}
+	if _, _, _, locked, _ := r2.lock(ctx, ids[0]); locked {
+		t.Fatal("relay2 locks the row which is locked by relay1")
+	}
+	if n, _ := r2.Relay(ctx); n != 0 {
+		t.Errorf("relay2 Relay() = %d, want 0 while the row is locked", n)
+	}
+	// relay1 stops before the row is marked sent, so relay2 publishes it after the lock expires
+	time.Sleep(60 * time.Millisecond)
+	if n, _ := r2.Relay(ctx); n != 1 {
+		t.Errorf("relay2 Relay() = %d, want 1 after the lock expires", n)
+	}
+	if err = r1.markSent(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if row := getOutboxRow(t, db, "1"); !row.sent || row.lockedBy.Valid {
+		t.Errorf("message = %+v, want sent by relay2", row)
+	}
+}
+
+// TestOutboxRelayCompete checks that each message is published once, when the relays poll the same table at the same time
+func TestOutboxRelayCompete(t *testing.T) {
+	messages := make([]string, 20)
+	for i := range messages {
+		messages[i] = fmt.Sprint(i)
+	}
+	db := openOutbox(t, messages...)
+	s := &outboxSender{}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		r := NewOutboxRelay(db, outboxTable, "orders", s.send, 3, time.Second, time.Minute)
+		r.Instance = fmt.Sprintf("relay%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n, err := r.Relay(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if n == 0 {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	count := make(map[string]int)
+	for _, data := range s.sent {
+		count[data]++
+	}
+	for _, data := range messages {
+		if count[data] != 1 {
+			t.Errorf("message %s is published %d times, want once", data, count[data])
+		}
+	}
+}
+
+// TestOutboxRelayMarkSentAfterCancel checks that a published message is marked sent, even if ctx is done while it is published
+func TestOutboxRelayMarkSentAfterCancel(t *testing.T) {
+	db := openOutbox(t, "1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewOutboxRelay(db, outboxTable, "orders", func(ctx context.Context, destination string, data []byte, attrs map[string]string) error {
+		cancel()
+		return nil
+	}, 10, time.Second, time.Minute)
+	if n, err := r.Relay(ctx); err != nil || n != 1 {
+		t.Fatalf("Relay() = %d, %v, want 1, nil", n, err)
+	}
+	if row := getOutboxRow(t, db, "1"); !row.sent {
+		t.Error("the published message is not marked sent after ctx is done")
+	}
+}
@@ -0,0 +1,101 @@
+package sqs
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/core-go/mq"
+)
+
+const (
+	DataTypeString = "String"
+	DataTypeNumber = "Number"
+	DataTypeBinary = "Binary"
+)
+
+// AttributeValue returns the value of a message attribute as a string. The value of Binary is encoded by base64. The custom types, such as Number.float, are mapped by their base types.
+func AttributeValue(v *types.MessageAttributeValue) string {
+	if v == nil {
+		return ""
+	}
+	if strings.HasPrefix(aws.ToString(v.DataType), DataTypeBinary) {
+		return base64.StdEncoding.EncodeToString(v.BinaryValue)
+	}
+	return aws.ToString(v.StringValue)
+}
+func MessageAttributesToMap(m map[string]types.MessageAttributeValue) map[string]string {
+	attributes := make(map[string]string)
+	for k, v := range m {
+		attributes[k] = AttributeValue(&v)
+	}
+	return attributes
+}
+
+// GetAttributes returns the system attributes and the message attributes of the message. If a name is in both, the message attribute is used.
+func GetAttributes(m *types.Message) map[string]string {
+	attributes := make(map[string]string, len(m.Attributes)+len(m.MessageAttributes))
+	for k, v := range m.Attributes {
+		attributes[k] = v
+	}
+	for k, v := range m.MessageAttributes {
+		attributes[k] = AttributeValue(&v)
+	}
+	return attributes
+}
+
+// MapToTypedAttributes converts the attributes by dataTypes, the data types of the names, such as Number or Binary. The value of Binary is decoded from base64. The attributes which are not in dataTypes are String.
+func MapToTypedAttributes(attributes map[string]string, dataTypes map[string]string) (map[string]types.MessageAttributeValue, error) {
+	attrs := make(map[string]types.MessageAttributeValue)
+	for k, v := range attributes {
+		dataType := dataTypes[k]
+		if len(dataType) == 0 {
+			dataType = DataTypeString
+		}
+		if strings.HasPrefix(dataType, DataTypeBinary) {
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, err
+			}
+			attrs[k] = types.MessageAttributeValue{DataType: aws.String(dataType), BinaryValue: b}
+		} else {
+			attrs[k] = types.MessageAttributeValue{DataType: aws.String(dataType), StringValue: aws.String(v)}
+		}
+	}
+	return attrs, nil
+}
+
+type receiptHandleKey struct{}
+type messageIdKey struct{}
+
+// WithMessage puts the receipt handle and the message id of the message into ctx
+func WithMessage(ctx context.Context, m *types.Message) context.Context {
+	if m.ReceiptHandle != nil {
+		ctx = context.WithValue(ctx, receiptHandleKey{}, *m.ReceiptHandle)
+	}
+	if m.MessageId != nil {
+		ctx = context.WithValue(ctx, messageIdKey{}, *m.MessageId)
+	}
+	return ctx
+}
+
+// GetReceiptHandle returns the receipt handle of the message of ctx, which is put by Receiver
+func GetReceiptHandle(ctx context.Context) string {
+	receiptHandle, _ := ctx.Value(receiptHandleKey{}).(string)
+	return receiptHandle
+}
+
+// GetMessageId returns the message id of the message of ctx, which is put by Receiver. It is the MessageID of mq.Dedup, see NewDedup.
+func GetMessageId(ctx context.Context) string {
+	messageId, _ := ctx.Value(messageIdKey{}).(string)
+	return messageId
+}
+
+// NewDedup returns the mq.Dedup of the messages of Receiver, by the message id of SQS
+func NewDedup(store mq.DedupStore, logs ...func(context.Context, string)) *mq.Dedup {
+	d := mq.NewDedup(store, "", "", logs...)
+	d.MessageID = GetMessageId
+	return d
+}
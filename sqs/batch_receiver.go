@@ -0,0 +1,41 @@
+package sqs
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/core-go/mq"
+)
+
+// ReceiveBatch reads the messages until ctx is done or Close is called, and passes the messages of each receive call to handle as a batch, such as BatchWorker.HandleBatch.
+// A batch has up to MaxNumberOfMessages messages. After handle returns, the acked messages are deleted by DeleteMessageBatch.
+// If AckOnConsume is true, the messages are deleted before handle is called.
+func (c *Receiver) ReceiveBatch(ctx context.Context, handle func(context.Context, []mq.RawMessage)) {
+	handle = mq.Recover(handle, c.LogError)
+	c.loop(ctx, func(ctx context.Context, messages []*types.Message) {
+		c.handleBatch(ctx, messages, handle)
+	})
+}
+func (c *Receiver) handleBatch(ctx context.Context, messages []*types.Message, handle func(context.Context, []mq.RawMessage)) {
+	if c.AckOnConsume {
+		messages = c.deleteMessages(ctx, messages)
+		if len(messages) == 0 {
+			return
+		}
+		batch := make([]mq.RawMessage, len(messages))
+		for i, m := range messages {
+			batch[i] = mq.RawMessage{Data: []byte(*m.Body), Attributes: GetAttributes(m)}
+		}
+		handle(ctx, batch)
+		return
+	}
+	acks := NewAcks(c.Client, c.QueueURL)
+	batch := make([]mq.RawMessage, len(messages))
+	for i, m := range messages {
+		batch[i] = mq.RawMessage{Data: []byte(*m.Body), Attributes: GetAttributes(m), Delivery: acks.Delivery(m.ReceiptHandle)}
+	}
+	handle(ctx, batch)
+	if err := acks.Flush(ctx); err != nil {
+		c.LogError(ctx, "Error when delete messages: "+err.Error())
+	}
+}
@@ -0,0 +1,337 @@
+package sqs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/core-go/mq"
+)
+
+const (
+	MaxBatchEntries = 10
+	MaxBatchBytes   = 256 * 1024
+	// DefaultFlushInterval is the longest time a message of Send waits in the buffer
+	DefaultFlushInterval = 100 * time.Millisecond
+)
+
+// BatchSender sends the messages by SendMessageBatch, up to MaxBatchEntries entries or MaxBatchBytes bytes a call.
+// Send buffers the messages, and flushes the buffer when it has MaxBatchEntries entries or MaxBatchBytes bytes, or FlushInterval after the first message of the buffer.
+// Write sends the models of a batch, such as a batch of mq.BatchWorker, and returns the indices of the models which are not sent, so it is the Write of mq.BatchHandler.
+// The failed entries are retried after each duration of Retries, unless they fail by the fault of the sender. A message larger than MaxBatchBytes fails without being sent.
+// If GroupId is not nil, the queue is a FIFO queue, so DelaySeconds must be 0, see ErrFIFODelay.
+type BatchSender[T any] struct {
+	Client          Client
+	QueueURL        *string
+	DelaySeconds    *int64
+	Marshal         func(T) ([]byte, map[string]string, error)
+	GroupId         func(context.Context, []byte, map[string]string) string
+	DeduplicationId func(context.Context, []byte, map[string]string) string
+	Retries         []time.Duration
+	FlushInterval   time.Duration
+	LogError        func(context.Context, string)
+	mu              sync.Mutex
+	buffer          []bufferedEntry
+	size            int
+	timer           *time.Timer
+}
+
+// bufferedEntry is an entry of Send, with the channel of its result
+type bufferedEntry struct {
+	entry *types.SendMessageBatchRequestEntry
+	done  chan error
+}
+
+func NewBatchSenderByQueueName[T any](client Client, queueName string, delaySeconds int64, retries []time.Duration, options ...func(T) ([]byte, map[string]string, error)) (*BatchSender[T], error) {
+	queueUrl, err := GetQueueUrl(client, queueName)
+	if err != nil {
+		return nil, err
+	}
+	return NewBatchSender[T](client, queueUrl, delaySeconds, retries, options...), nil
+}
+func NewBatchSender[T any](client Client, queueURL string, delaySeconds int64, retries []time.Duration, options ...func(T) ([]byte, map[string]string, error)) *BatchSender[T] {
+	var marshal func(T) ([]byte, map[string]string, error)
+	if len(options) > 0 && options[0] != nil {
+		marshal = options[0]
+	} else {
+		marshal = func(model T) ([]byte, map[string]string, error) {
+			data, err := json.Marshal(model)
+			return data, nil, err
+		}
+	}
+	return &BatchSender[T]{Client: client, QueueURL: &queueURL, DelaySeconds: &delaySeconds, Marshal: marshal, Retries: retries, FlushInterval: DefaultFlushInterval}
+}
+
+// Send buffers the message, and waits until the buffer is flushed. It returns the error of the message, or ctx.Err() if ctx is done before that, but the message is still sent with the buffer.
+// The buffer is sent with context.Background(), so that a caller which gives up does not fail the messages of the other callers.
+func (s *BatchSender[T]) Send(ctx context.Context, data []byte, attributes map[string]string) error {
+	entry, err := s.entry(ctx, 0, data, attributes)
+	if err != nil {
+		return err
+	}
+	n := EntrySize(entry)
+	if n > MaxBatchBytes {
+		return tooLarge(n)
+	}
+	done := make(chan error, 1)
+	var batches [][]bufferedEntry
+	s.mu.Lock()
+	if len(s.buffer) > 0 && s.size+n > MaxBatchBytes {
+		batches = append(batches, s.take())
+	}
+	s.buffer = append(s.buffer, bufferedEntry{entry: entry, done: done})
+	s.size = s.size + n
+	if len(s.buffer) >= MaxBatchEntries {
+		batches = append(batches, s.take())
+	} else if s.timer == nil {
+		s.timer = time.AfterFunc(s.flushInterval(), func() {
+			s.Flush(context.Background())
+		})
+	}
+	s.mu.Unlock()
+	for _, batch := range batches {
+		s.flush(context.Background(), batch)
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush sends the buffered messages of Send now, such as before the application stops
+func (s *BatchSender[T]) Flush(ctx context.Context) {
+	s.mu.Lock()
+	batch := s.take()
+	s.mu.Unlock()
+	s.flush(ctx, batch)
+}
+
+// take returns the buffer and empties it. It must be called with the lock.
+func (s *BatchSender[T]) take() []bufferedEntry {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	batch := s.buffer
+	s.buffer = nil
+	s.size = 0
+	return batch
+}
+
+// flush sends the entries of the buffer, and passes the result of each entry to its caller
+func (s *BatchSender[T]) flush(ctx context.Context, batch []bufferedEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	entries := make([]*types.SendMessageBatchRequestEntry, len(batch))
+	for i, b := range batch {
+		b.entry.Id = aws.String(strconv.Itoa(i))
+		entries[i] = b.entry
+	}
+	fails, err := s.write(ctx, entries, len(entries))
+	failed := make(map[int]bool, len(fails))
+	for _, i := range fails {
+		failed[i] = true
+	}
+	for i, b := range batch {
+		if failed[i] {
+			b.done <- err
+		} else {
+			b.done <- nil
+		}
+	}
+}
+func (s *BatchSender[T]) flushInterval() time.Duration {
+	if s.FlushInterval <= 0 {
+		return DefaultFlushInterval
+	}
+	return s.FlushInterval
+}
+
+func (s *BatchSender[T]) Write(ctx context.Context, models []T) ([]int, error) {
+	if len(models) == 0 {
+		return nil, nil
+	}
+	var fails []int
+	var err error
+	entries := make([]*types.SendMessageBatchRequestEntry, 0, len(models))
+	for i, model := range models {
+		data, attrs, er1 := s.Marshal(model)
+		if er1 != nil {
+			fails = append(fails, i)
+			err = er1
+			continue
+		}
+		entry, er1 := s.entry(ctx, i, data, attrs)
+		if er1 != nil {
+			fails = append(fails, i)
+			err = er1
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if len(fails) > 0 && s.LogError != nil {
+		s.LogError(ctx, fmt.Sprintf("Failed to build %d of %d messages. Error: %s", len(fails), len(models), err.Error()))
+	}
+	notSent, er2 := s.write(ctx, entries, len(models))
+	if er2 != nil {
+		err = er2
+	}
+	fails = append(fails, notSent...)
+	if len(fails) == 0 {
+		return nil, nil
+	}
+	return sortInts(fails), err
+}
+
+// write sends the entries, whose ids are the indices of the caller, and retries the failed entries. It returns the indices of the entries which are not sent.
+func (s *BatchSender[T]) write(ctx context.Context, entries []*types.SendMessageBatchRequestEntry, total int) ([]int, error) {
+	var fails []int
+	var err error
+	valid := make([]*types.SendMessageBatchRequestEntry, 0, len(entries))
+	for _, entry := range entries {
+		if n := EntrySize(entry); n > MaxBatchBytes {
+			fails = append(fails, indices([]*types.SendMessageBatchRequestEntry{entry})...)
+			err = tooLarge(n)
+		} else {
+			valid = append(valid, entry)
+		}
+	}
+	retryable, permanent, er2 := s.send(ctx, valid)
+	if er2 != nil {
+		err = er2
+	}
+	fails = append(fails, indices(permanent)...)
+	for i := 0; i < len(s.Retries) && len(retryable) > 0; i++ {
+		if er3 := mq.Sleep(ctx, s.Retries[i]); er3 != nil {
+			return sortInts(append(fails, indices(retryable)...)), er3
+		}
+		if s.LogError != nil {
+			s.LogError(ctx, fmt.Sprintf("Retry %d to send %d failed entries", i+1, len(retryable)))
+		}
+		retryable, permanent, er2 = s.send(ctx, retryable)
+		if er2 != nil {
+			err = er2
+		}
+		fails = append(fails, indices(permanent)...)
+	}
+	fails = append(fails, indices(retryable)...)
+	if len(fails) == 0 {
+		return nil, nil
+	}
+	if s.LogError != nil {
+		s.LogError(ctx, fmt.Sprintf("Failed to send %d of %d messages. Error: %s", len(fails), total, err.Error()))
+	}
+	return sortInts(fails), err
+}
+
+// send sends the entries by chunks, and returns the failed entries which can be retried, and the entries which fail by the fault of the sender
+func (s *BatchSender[T]) send(ctx context.Context, entries []*types.SendMessageBatchRequestEntry) ([]*types.SendMessageBatchRequestEntry, []*types.SendMessageBatchRequestEntry, error) {
+	var retryable, permanent []*types.SendMessageBatchRequestEntry
+	var err error
+	for _, chunk := range Chunk(entries) {
+		batch := make([]types.SendMessageBatchRequestEntry, len(chunk))
+		for i, entry := range chunk {
+			batch[i] = *entry
+		}
+		result, er1 := s.Client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			Entries:  batch,
+			QueueUrl: s.QueueURL,
+		})
+		if er1 != nil {
+			retryable = append(retryable, chunk...)
+			err = er1
+			continue
+		}
+		byId := make(map[string]*types.SendMessageBatchRequestEntry, len(chunk))
+		for _, entry := range chunk {
+			byId[aws.ToString(entry.Id)] = entry
+		}
+		for _, f := range result.Failed {
+			entry, ok := byId[aws.ToString(f.Id)]
+			if !ok {
+				continue
+			}
+			err = fmt.Errorf("cannot send message: %s %s", aws.ToString(f.Code), aws.ToString(f.Message))
+			if f.SenderFault {
+				permanent = append(permanent, entry)
+			} else {
+				retryable = append(retryable, entry)
+			}
+		}
+	}
+	return retryable, permanent, err
+}
+func (s *BatchSender[T]) entry(ctx context.Context, i int, data []byte, attrs map[string]string) (*types.SendMessageBatchRequestEntry, error) {
+	input := &sqs.SendMessageInput{
+		DelaySeconds:      int32(aws.ToInt64(s.DelaySeconds)),
+		MessageAttributes: MapToAttributes(attrs),
+		MessageBody:       aws.String(string(data)),
+	}
+	if err := setFIFO(ctx, input, data, attrs, s.GroupId, s.DeduplicationId); err != nil {
+		return nil, err
+	}
+	return &types.SendMessageBatchRequestEntry{
+		Id:                     aws.String(strconv.Itoa(i)),
+		DelaySeconds:           input.DelaySeconds,
+		MessageAttributes:      input.MessageAttributes,
+		MessageBody:            input.MessageBody,
+		MessageDeduplicationId: input.MessageDeduplicationId,
+		MessageGroupId:         input.MessageGroupId,
+	}, nil
+}
+
+// Chunk splits the entries into the chunks of SendMessageBatch, which have up to MaxBatchEntries entries and MaxBatchBytes bytes
+func Chunk(entries []*types.SendMessageBatchRequestEntry) [][]*types.SendMessageBatchRequestEntry {
+	var chunks [][]*types.SendMessageBatchRequestEntry
+	var chunk []*types.SendMessageBatchRequestEntry
+	size := 0
+	for _, entry := range entries {
+		n := EntrySize(entry)
+		if len(chunk) > 0 && (len(chunk) >= MaxBatchEntries || size+n > MaxBatchBytes) {
+			chunks = append(chunks, chunk)
+			chunk = nil
+			size = 0
+		}
+		chunk = append(chunk, entry)
+		size = size + n
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// EntrySize is the size of the body and the attributes, which is counted for the limit of MaxBatchBytes
+func EntrySize(entry *types.SendMessageBatchRequestEntry) int {
+	size := len(aws.ToString(entry.MessageBody))
+	for k, v := range entry.MessageAttributes {
+		size = size + len(k) + len(aws.ToString(v.DataType)) + len(aws.ToString(v.StringValue)) + len(v.BinaryValue)
+	}
+	return size
+}
+func tooLarge(size int) error {
+	return fmt.Errorf("message of %d bytes is larger than the limit of %d bytes", size, MaxBatchBytes)
+}
+func sortInts(a []int) []int {
+	sort.Ints(a)
+	return a
+}
+func indices(entries []*types.SendMessageBatchRequestEntry) []int {
+	var fails []int
+	for _, entry := range entries {
+		if i, err := strconv.Atoi(aws.ToString(entry.Id)); err == nil {
+			fails = append(fails, i)
+		}
+	}
+	return fails
+}
@@ -0,0 +1,132 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// failBodies fails the entries whose bodies are in fails, and sends the others. A failure is by the fault of the sender if it is true in fails.
+func failBodies(client *testClient, fails map[string]bool) func(*sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	return func(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+		output := &sqs.SendMessageBatchOutput{}
+		for _, entry := range input.Entries {
+			body := aws.ToString(entry.MessageBody)
+			senderFault, ok := fails[body]
+			if !ok {
+				client.sent = append(client.sent, body)
+				continue
+			}
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InvalidMessageContents"), SenderFault: senderFault})
+		}
+		return output, nil
+	}
+}
+
+// TestBatchSenderSend checks that the failed entries of a flush are passed back to their callers only
+func TestBatchSenderSend(t *testing.T) {
+	client := &testClient{}
+	client.sendBatch = failBodies(client, map[string]bool{"bad": true})
+	s := NewBatchSender[string](client, "orders", 0, nil)
+	s.FlushInterval = 50 * time.Millisecond
+	bodies := []string{"a", "bad", "c", "d"}
+	errs := make([]error, len(bodies))
+	var wg sync.WaitGroup
+	for i, body := range bodies {
+		wg.Add(1)
+		go func(i int, body string) {
+			defer wg.Done()
+			errs[i] = s.Send(context.Background(), []byte(body), nil)
+		}(i, body)
+	}
+	wg.Wait()
+	for i, body := range bodies {
+		if (body == "bad") != (errs[i] != nil) {
+			t.Errorf("Send(%s) = %v", body, errs[i])
+		}
+	}
+	if client.batches != 1 || len(client.sent) != 3 {
+		t.Errorf("batches = %d, sent = %v, want the 3 other messages in 1 batch", client.batches, client.sent)
+	}
+}
+
+func TestBatchSenderWrite(t *testing.T) {
+	huge := strings.Repeat("x", MaxBatchBytes+1)
+	chunks := make([]string, MaxBatchEntries+2)
+	for i := range chunks {
+		chunks[i] = fmt.Sprint(i)
+	}
+	chunks[MaxBatchEntries+1] = "bad"
+	tests := []struct {
+		name    string
+		models  []string
+		fails   map[string]bool
+		retries []time.Duration
+		indices []int
+		batches int
+		sent    int
+	}{
+		{name: "all sent", models: []string{"a", "b", "c"}, indices: nil, batches: 1, sent: 3},
+		{name: "sender fault", models: []string{"a", "bad", "c", "bad"}, fails: map[string]bool{"bad": true}, retries: []time.Duration{time.Millisecond}, indices: []int{1, 3}, batches: 1, sent: 2},
+		{name: "retryable fails after retries", models: []string{"a", "busy", "c"}, fails: map[string]bool{"busy": false}, retries: []time.Duration{time.Millisecond, time.Millisecond}, indices: []int{1}, batches: 3, sent: 2},
+		{name: "fails in the second chunk", models: chunks, fails: map[string]bool{"bad": true}, indices: []int{MaxBatchEntries + 1}, batches: 2, sent: MaxBatchEntries + 1},
+		{name: "oversized", models: []string{"a", huge, "c"}, indices: []int{1}, batches: 1, sent: 2},
+		{name: "only oversized", models: []string{huge}, indices: []int{0}, batches: 0, sent: 0},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			client := &testClient{}
+			client.sendBatch = failBodies(client, c.fails)
+			s := NewBatchSender[string](client, "orders", 0, c.retries, func(model string) ([]byte, map[string]string, error) {
+				return []byte(model), nil, nil
+			})
+			indices, err := s.Write(context.Background(), c.models)
+			if fmt.Sprint(indices) != fmt.Sprint(c.indices) {
+				t.Errorf("Write() = %v, want %v", indices, c.indices)
+			}
+			if (len(c.indices) > 0) != (err != nil) {
+				t.Errorf("Write() error = %v", err)
+			}
+			if client.batches != c.batches || len(client.sent) != c.sent {
+				t.Errorf("batches = %d, sent = %d, want %d, %d", client.batches, len(client.sent), c.batches, c.sent)
+			}
+		})
+	}
+}
+
+// TestBatchSenderRetry checks that a retryable failed entry is sent by the retry, and its caller gets nil
+func TestBatchSenderRetry(t *testing.T) {
+	client := &testClient{}
+	fails := map[string]bool{"busy": false}
+	send := failBodies(client, fails)
+	client.sendBatch = func(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+		output, err := send(input)
+		delete(fails, "busy")
+		return output, err
+	}
+	s := NewBatchSender[string](client, "orders", 0, []time.Duration{time.Millisecond})
+	if err := s.Send(context.Background(), []byte("busy"), nil); err != nil {
+		t.Errorf("Send() = %v, want nil after the retry", err)
+	}
+	if client.batches != 2 || fmt.Sprint(client.sent) != "[busy]" {
+		t.Errorf("batches = %d, sent = %v, want the message sent by the second batch", client.batches, client.sent)
+	}
+}
+
+func TestBatchSenderSendOversized(t *testing.T) {
+	client := &testClient{}
+	s := NewBatchSender[string](client, "orders", 0, nil)
+	if err := s.Send(context.Background(), []byte(strings.Repeat("x", MaxBatchBytes+1)), nil); err == nil {
+		t.Error("Send() = nil, want the error of the oversized message")
+	}
+	if client.batches != 0 {
+		t.Errorf("batches = %d, want 0, the oversized message is not buffered", client.batches)
+	}
+}
@@ -0,0 +1,178 @@
+package sqs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/core-go/mq"
+)
+
+const MaxVisibilityTimeout = 43200 // 12 hours
+
+// Delivery deletes the message on Ack. Nack makes the message visible again immediately, and Requeue makes it visible after delay.
+// If the message is received in a batch, Ack adds the message to the Acks of the batch, to be deleted by DeleteMessageBatch.
+type Delivery struct {
+	Client        Client
+	QueueURL      *string
+	ReceiptHandle *string
+	acks          *Acks
+	settled       chan struct{}
+	once          sync.Once
+}
+
+func NewDelivery(client Client, queueURL *string, receiptHandle *string) *Delivery {
+	return &Delivery{Client: client, QueueURL: queueURL, ReceiptHandle: receiptHandle, settled: make(chan struct{})}
+}
+func (d *Delivery) Ack(ctx context.Context) error {
+	defer d.settle()
+	if d.acks != nil {
+		return d.acks.Add(ctx, d.ReceiptHandle)
+	}
+	_, err := d.Client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      d.QueueURL,
+		ReceiptHandle: d.ReceiptHandle,
+	})
+	return err
+}
+func (d *Delivery) Nack(ctx context.Context) error {
+	return d.Requeue(ctx, 0)
+}
+func (d *Delivery) Requeue(ctx context.Context, delay time.Duration) error {
+	defer d.settle()
+	return d.changeVisibility(ctx, delay)
+}
+
+// Settled returns the channel, which is closed when the message is acked, nacked or requeued, such as by an async handler
+func (d *Delivery) Settled() <-chan struct{} {
+	return d.settled
+}
+func (d *Delivery) settle() {
+	if d.settled != nil {
+		d.once.Do(func() {
+			close(d.settled)
+		})
+	}
+}
+func (d *Delivery) changeVisibility(ctx context.Context, delay time.Duration) error {
+	seconds := int64(delay / time.Second)
+	if seconds > MaxVisibilityTimeout {
+		seconds = MaxVisibilityTimeout
+	}
+	_, err := d.Client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          d.QueueURL,
+		ReceiptHandle:     d.ReceiptHandle,
+		VisibilityTimeout: int32(seconds),
+	})
+	return err
+}
+
+// Extend changes the visibility timeout of the message, so that it is not received again while it is handled
+func (d *Delivery) Extend(ctx context.Context, visibilityTimeout time.Duration) error {
+	return d.changeVisibility(ctx, visibilityTimeout)
+}
+
+// ExtendVisibility extends the visibility timeout of the message in ctx by visibilityTimeout, every half of visibilityTimeout, until the returned function is called.
+// It is used by the long-running handlers:
+//
+//	defer sqs.ExtendVisibility(ctx, 30*time.Second)()
+func ExtendVisibility(ctx context.Context, visibilityTimeout time.Duration, logError ...func(context.Context, string)) func() {
+	d, ok := mq.GetDelivery(ctx).(*Delivery)
+	if !ok || visibilityTimeout < 2*time.Second {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	var once sync.Once
+	go func() {
+		ticker := time.NewTicker(visibilityTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.Extend(ctx, visibilityTimeout); err != nil && len(logError) > 0 && logError[0] != nil {
+					logError[0](ctx, "Error when extend visibility timeout: "+err.Error())
+				}
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() {
+			close(stop)
+		})
+	}
+}
+
+// KeepVisible is the middleware of ExtendVisibility, which extends the visibility timeout of the message while it is handled
+func KeepVisible(visibilityTimeout time.Duration, logError func(context.Context, string)) mq.Middleware {
+	return func(handle func(context.Context, []byte, map[string]string)) func(context.Context, []byte, map[string]string) {
+		return func(ctx context.Context, data []byte, attrs map[string]string) {
+			defer ExtendVisibility(ctx, visibilityTimeout, logError)()
+			handle(ctx, data, attrs)
+		}
+	}
+}
+
+// Acks collects the receipt handles of the acked messages of a batch, so that they are deleted by one DeleteMessageBatch call when Flush is called.
+// The messages which are acked after Flush are deleted one by one.
+type Acks struct {
+	Client         Client
+	QueueURL       *string
+	mu             sync.Mutex
+	receiptHandles []*string
+	flushed        bool
+}
+
+func NewAcks(client Client, queueURL *string) *Acks {
+	return &Acks{Client: client, QueueURL: queueURL}
+}
+
+// Delivery returns the delivery of a message of the batch
+func (a *Acks) Delivery(receiptHandle *string) *Delivery {
+	return &Delivery{Client: a.Client, QueueURL: a.QueueURL, ReceiptHandle: receiptHandle, acks: a, settled: make(chan struct{})}
+}
+func (a *Acks) Add(ctx context.Context, receiptHandle *string) error {
+	a.mu.Lock()
+	if !a.flushed {
+		a.receiptHandles = append(a.receiptHandles, receiptHandle)
+		a.mu.Unlock()
+		return nil
+	}
+	a.mu.Unlock()
+	_, err := a.Client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      a.QueueURL,
+		ReceiptHandle: receiptHandle,
+	})
+	return err
+}
+
+// Acked returns true if the message is acked before Flush
+func (a *Acks) Acked(receiptHandle *string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, h := range a.receiptHandles {
+		if h == receiptHandle || aws.ToString(h) == aws.ToString(receiptHandle) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush deletes the acked messages by DeleteMessageBatch
+func (a *Acks) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	receiptHandles := a.receiptHandles
+	a.receiptHandles = nil
+	a.flushed = true
+	a.mu.Unlock()
+	if len(receiptHandles) == 0 {
+		return nil
+	}
+	_, err := DeleteMessageBatch(ctx, a.Client, a.QueueURL, receiptHandles)
+	return err
+}
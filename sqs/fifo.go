@@ -0,0 +1,123 @@
+package sqs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/core-go/mq"
+)
+
+const (
+	FIFOSuffix     = ".fifo"
+	DefaultGroupId = "default"
+)
+
+func IsFIFO(queue string) bool {
+	return strings.HasSuffix(queue, FIFOSuffix)
+}
+
+// GroupIdFromAttribute returns the group id of a message from the attribute, or defaultGroupId if the attribute is empty
+func GroupIdFromAttribute(name string, defaultGroupId string) func(context.Context, []byte, map[string]string) string {
+	return func(ctx context.Context, data []byte, attrs map[string]string) string {
+		if groupId := attrs[name]; len(groupId) > 0 {
+			return groupId
+		}
+		return defaultGroupId
+	}
+}
+
+// GroupIdFromKey returns the group id of a message from the context value of key, such as the key of mq.Handler, or defaultGroupId if it is empty
+func GroupIdFromKey(key string, defaultGroupId string) func(context.Context, []byte, map[string]string) string {
+	return func(ctx context.Context, data []byte, attrs map[string]string) string {
+		if groupId := mq.GetString(ctx, key); len(groupId) > 0 {
+			return groupId
+		}
+		return defaultGroupId
+	}
+}
+
+// DeduplicationIdFromAttribute returns the explicit deduplication id of a message from the attribute
+func DeduplicationIdFromAttribute(name string) func(context.Context, []byte, map[string]string) string {
+	return func(ctx context.Context, data []byte, attrs map[string]string) string {
+		return attrs[name]
+	}
+}
+
+// ContentDeduplicationId returns the SHA-256 hash of the body and the attributes.
+// Unlike the content-based deduplication of SQS, which hashes the body only, a retry of the same body is not dropped as a duplicate, because it has another retry count in the attributes.
+func ContentDeduplicationId(ctx context.Context, data []byte, attrs map[string]string) string {
+	h := sha256.New()
+	h.Write(data)
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Write([]byte{0})
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(attrs[k]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NewFIFO returns the group id and deduplication id functions of the config.
+// The deduplication id is always set, even if ContentBasedDeduplication is enabled on the queue, because the content-based deduplication drops the retries of the same body.
+func NewFIFO(c Config) (func(context.Context, []byte, map[string]string) string, func(context.Context, []byte, map[string]string) string) {
+	defaultGroupId := c.MessageGroupId
+	if len(defaultGroupId) == 0 {
+		defaultGroupId = DefaultGroupId
+	}
+	groupId := GroupIdFromAttribute(c.MessageGroupIdName, defaultGroupId)
+	if len(c.DeduplicationIdName) > 0 {
+		return groupId, DeduplicationIdFromAttribute(c.DeduplicationIdName)
+	}
+	return groupId, ContentDeduplicationId
+}
+
+// ErrFIFODelay is returned when a message of a FIFO queue is sent with a delay. FIFO queues support the delay of the queue only, so mq.DelayedRetry cannot be used with them.
+var ErrFIFODelay = errors.New("the messages of a FIFO queue cannot be sent with a delay")
+
+// setFIFO sets the group id and deduplication id of the message. It returns ErrFIFODelay if the message has DelaySeconds, instead of sending it without the delay.
+func setFIFO(ctx context.Context, input *sqs.SendMessageInput, data []byte, attrs map[string]string,
+	groupId func(context.Context, []byte, map[string]string) string,
+	deduplicationId func(context.Context, []byte, map[string]string) string) error {
+	if groupId == nil {
+		return nil
+	}
+	if input.DelaySeconds > 0 {
+		return ErrFIFODelay
+	}
+	input.MessageGroupId = aws.String(groupId(ctx, data, attrs))
+	if deduplicationId != nil {
+		if id := deduplicationId(ctx, data, attrs); len(id) > 0 {
+			input.MessageDeduplicationId = aws.String(id)
+		}
+	}
+	return nil
+}
+
+// GroupMessages groups the messages by MessageGroupId, in the order of the messages
+func GroupMessages(messages []*types.Message) [][]*types.Message {
+	var groups [][]*types.Message
+	index := make(map[string]int)
+	for _, m := range messages {
+		groupId := m.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]
+		i, ok := index[groupId]
+		if !ok {
+			i = len(groups)
+			index[groupId] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], m)
+	}
+	return groups
+}
@@ -1,12 +1,16 @@
 package sqs
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/sqs"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"strconv"
 )
 
-func GetQueueUrl(client *sqs.SQS, queueName string) (string, error) {
-	result, err := client.GetQueueUrl(&sqs.GetQueueUrlInput{
+func GetQueueUrl(client Client, queueName string) (string, error) {
+	result, err := client.GetQueueUrl(context.Background(), &sqs.GetQueueUrlInput{
 		QueueName: &queueName,
 	})
 	if err != nil {
@@ -15,16 +19,56 @@ func GetQueueUrl(client *sqs.SQS, queueName string) (string, error) {
 	return *result.QueueUrl, err
 }
 
-func MapToAttributes(attributes map[string]string) map[string]*sqs.MessageAttributeValue {
-	attrs := make(map[string]*sqs.MessageAttributeValue)
+func MapToAttributes(attributes map[string]string) map[string]types.MessageAttributeValue {
+	attrs := make(map[string]types.MessageAttributeValue)
 	if attributes != nil {
 		for k, v := range attributes {
-			x := sqs.MessageAttributeValue{
+			attrs[k] = types.MessageAttributeValue{
 				DataType:    aws.String("String"),
 				StringValue: aws.String(v),
 			}
-			attrs[k] = &x
 		}
 	}
 	return attrs
 }
+
+// DeleteMessageBatch deletes the messages by DeleteMessageBatch, up to 10 messages a call, and returns the indices of the messages which are not deleted
+func DeleteMessageBatch(ctx context.Context, client Client, queueURL *string, receiptHandles []*string) ([]int, error) {
+	var fails []int
+	var err error
+	for start := 0; start < len(receiptHandles); start += MaxBatchEntries {
+		end := start + MaxBatchEntries
+		if end > len(receiptHandles) {
+			end = len(receiptHandles)
+		}
+		entries := make([]types.DeleteMessageBatchRequestEntry, 0, end-start)
+		for i := start; i < end; i++ {
+			entries = append(entries, types.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: receiptHandles[i],
+			})
+		}
+		result, er1 := client.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+			Entries:  entries,
+			QueueUrl: queueURL,
+		})
+		if er1 != nil {
+			for i := start; i < end; i++ {
+				fails = append(fails, i)
+			}
+			err = er1
+			continue
+		}
+		for _, f := range result.Failed {
+			i, er2 := strconv.Atoi(aws.ToString(f.Id))
+			if er2 != nil {
+				continue
+			}
+			fails = append(fails, i)
+			if err == nil {
+				err = fmt.Errorf("cannot delete message: %s %s", aws.ToString(f.Code), aws.ToString(f.Message))
+			}
+		}
+	}
+	return fails, err
+}
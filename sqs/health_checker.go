@@ -2,18 +2,18 @@ package sqs
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"time"
 )
 
 type HealthChecker struct {
-	Client    *sqs.SQS
+	Client    Client
 	QueueName *string
 	Service   string
 	Timeout   time.Duration
 }
 
-func NewHealthChecker(client *sqs.SQS, queueName string, options ...string) *HealthChecker {
+func NewHealthChecker(client Client, queueName string, options ...string) *HealthChecker {
 	var name string
 	if len(options) > 0 && len(options[0]) > 0 {
 		name = options[0]
@@ -22,7 +22,7 @@ func NewHealthChecker(client *sqs.SQS, queueName string, options ...string) *Hea
 	}
 	return NewSQSHealthChecker(client, name, queueName)
 }
-func NewSQSHealthChecker(client *sqs.SQS, name string, queueName string, options ...time.Duration) *HealthChecker {
+func NewSQSHealthChecker(client Client, name string, queueName string, options ...time.Duration) *HealthChecker {
 	var timeout time.Duration
 	if len(options) >= 1 && options[0] > 0 {
 		timeout = options[0]
@@ -38,8 +38,9 @@ func (h *HealthChecker) Name() string {
 
 func (h *HealthChecker) Check(ctx context.Context) (map[string]interface{}, error) {
 	res := make(map[string]interface{})
-	h.Client.Config.HTTPClient.Timeout = h.Timeout
-	_, err := h.Client.GetQueueUrl(&sqs.GetQueueUrlInput{
+	ctx2, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+	_, err := h.Client.GetQueueUrl(ctx2, &sqs.GetQueueUrlInput{
 		QueueName: h.QueueName,
 	})
 	return res, err
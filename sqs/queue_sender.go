@@ -2,18 +2,25 @@ package sqs
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
 
+// GroupId and DeduplicationId are used for the FIFO queues, which have the suffix ".fifo". The messages of the FIFO queues cannot be sent with DelaySeconds, see ErrFIFODelay.
 type QueueSender struct {
-	Client       *sqs.SQS
-	DelaySeconds *int64 //could be 10
+	Client          Client
+	DelaySeconds    *int64 //could be 10
+	GroupId         func(context.Context, []byte, map[string]string) string
+	DeduplicationId func(context.Context, []byte, map[string]string) string
 }
 
-func NewQueueSender(client *sqs.SQS, delaySeconds int64) *QueueSender {
+func NewQueueSender(client Client, delaySeconds int64) *QueueSender {
 	return &QueueSender{Client: client, DelaySeconds: &delaySeconds}
 }
+func NewQueueSenderByConfig(client Client, c Config, delaySeconds int64) *QueueSender {
+	groupId, deduplicationId := NewFIFO(c)
+	return &QueueSender{Client: client, DelaySeconds: &delaySeconds, GroupId: groupId, DeduplicationId: deduplicationId}
+}
 func (p *QueueSender) Send(ctx context.Context, queueName string, data []byte, attributes map[string]string) error {
 	queueUrl, er0 := GetQueueUrl(p.Client, queueName)
 	if er0 != nil {
@@ -21,12 +28,18 @@ func (p *QueueSender) Send(ctx context.Context, queueName string, data []byte, a
 	}
 	attrs := MapToAttributes(attributes)
 	s := string(data)
-	_, err := p.Client.SendMessage(&sqs.SendMessageInput{
-		DelaySeconds:      p.DelaySeconds,
+	input := &sqs.SendMessageInput{
+		DelaySeconds:      int32(aws.ToInt64(p.DelaySeconds)),
 		MessageAttributes: attrs,
 		MessageBody:       aws.String(s),
 		QueueUrl:          &queueUrl,
-	})
+	}
+	if IsFIFO(queueName) {
+		if err := setFIFO(ctx, input, data, attributes, p.groupId(), p.deduplicationId()); err != nil {
+			return err
+		}
+	}
+	_, err := p.Client.SendMessage(ctx, input)
 	return err
 }
 func (p *QueueSender) SendBody(ctx context.Context, queueName string, data []byte) error {
@@ -35,10 +48,32 @@ func (p *QueueSender) SendBody(ctx context.Context, queueName string, data []byt
 		return er0
 	}
 	s := string(data)
-	_, err := p.Client.SendMessage(&sqs.SendMessageInput{
-		DelaySeconds: p.DelaySeconds,
+	input := &sqs.SendMessageInput{
+		DelaySeconds: int32(aws.ToInt64(p.DelaySeconds)),
 		MessageBody:  aws.String(s),
 		QueueUrl:     &queueUrl,
-	})
+	}
+	if IsFIFO(queueName) {
+		if err := setFIFO(ctx, input, data, nil, p.groupId(), p.deduplicationId()); err != nil {
+			return err
+		}
+	}
+	_, err := p.Client.SendMessage(ctx, input)
 	return err
 }
+
+// groupId returns GroupId, or the function of DefaultGroupId if GroupId is nil
+func (p *QueueSender) groupId() func(context.Context, []byte, map[string]string) string {
+	if p.GroupId != nil {
+		return p.GroupId
+	}
+	return GroupIdFromAttribute("", DefaultGroupId)
+}
+
+// deduplicationId returns DeduplicationId, or ContentDeduplicationId, which hashes the attributes with the body, so that a retry is not dropped as a duplicate
+func (p *QueueSender) deduplicationId() func(context.Context, []byte, map[string]string) string {
+	if p.DeduplicationId != nil {
+		return p.DeduplicationId
+	}
+	return ContentDeduplicationId
+}
@@ -2,147 +2,311 @@ package sqs
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/core-go/mq"
+	"sync"
+	"time"
 )
 
+const (
+	// MaxReceiveMessages is the limit of the messages, which are received by one ReceiveMessage call
+	MaxReceiveMessages = 10
+	// DefaultVisibilityTimeout is the default visibility timeout of the queues, in seconds
+	DefaultVisibilityTimeout = 30
+)
+
+// DefaultReceiveRetryPolicy waits from 100 milliseconds up to 20 seconds after an error of ReceiveMessage, so that the receiver does not call SQS in a tight loop while it is unavailable
+var DefaultReceiveRetryPolicy mq.RetryPolicy = mq.NewDecorrelatedJitterBackoff(100*time.Millisecond, 20*time.Second, 0)
+
+// If MaxNumberOfMessages > 1, the messages of a receive call are handled in parallel, and the acked messages are deleted by DeleteMessageBatch after all of them are handled.
+// If Ordered is true, such as for a FIFO queue, the messages of the same MessageGroupId are handled serially: the next message of a group is handled after the message is acked, nacked or requeued,
+// so that an async handler, such as mq.Handler with Goroutines, keeps the order. If a message of a group is not acked within the visibility timeout, the next messages of the group are nacked, so that they are received again after it.
 type Receiver struct {
-	Client            *sqs.SQS
-	QueueURL          *string
-	AckOnConsume      bool
-	VisibilityTimeout int64 // should be 20 (seconds)
-	WaitTimeSeconds   int64 // should be 0
-	LogError          func(ctx context.Context, msg string)
+	Client              Client
+	QueueURL            *string
+	AckOnConsume        bool
+	VisibilityTimeout   int64 // should be 20 (seconds)
+	WaitTimeSeconds     int64 // should be 0
+	MaxNumberOfMessages int64
+	Ordered             bool
+	LogError            func(ctx context.Context, msg string)
+	HandleError         func(context.Context, []byte, map[string]string)
+	RetryPolicy         mq.RetryPolicy // the delay after the consecutive errors of ReceiveMessage, DefaultReceiveRetryPolicy if nil
+	canceler            mq.Canceler
+	gate                mq.Gate
 }
 
-func NewReceiverByQueueName(client *sqs.SQS, queueName string, ackOnConsume bool, visibilityTimeout int64, waitTimeSeconds int64) (*Receiver, error) {
+func NewReceiverByQueueName(client Client, queueName string, ackOnConsume bool, visibilityTimeout int64, waitTimeSeconds int64, maxNumberOfMessages ...int64) (*Receiver, error) {
 	queueUrl, err := GetQueueUrl(client, queueName)
 	if err != nil {
 		return nil, err
 	}
-	return NewReceiver(client, queueUrl, ackOnConsume, visibilityTimeout, waitTimeSeconds), nil
+	return NewReceiver(client, queueUrl, ackOnConsume, visibilityTimeout, waitTimeSeconds, maxNumberOfMessages...), nil
 }
 
-func NewReceiver(client *sqs.SQS, queueURL string, ackOnConsume bool, visibilityTimeout int64, waitTimeSeconds int64) *Receiver {
-	return &Receiver{Client: client, QueueURL: &queueURL, AckOnConsume: ackOnConsume, VisibilityTimeout: visibilityTimeout, WaitTimeSeconds: waitTimeSeconds}
+func NewReceiver(client Client, queueURL string, ackOnConsume bool, visibilityTimeout int64, waitTimeSeconds int64, maxNumberOfMessages ...int64) *Receiver {
+	var max int64 = 1
+	if len(maxNumberOfMessages) > 0 && maxNumberOfMessages[0] > 1 {
+		max = maxNumberOfMessages[0]
+	}
+	return &Receiver{Client: client, QueueURL: &queueURL, AckOnConsume: ackOnConsume, VisibilityTimeout: visibilityTimeout, WaitTimeSeconds: waitTimeSeconds, MaxNumberOfMessages: max}
 }
 
+func NewFIFOReceiver(client Client, queueURL string, ackOnConsume bool, visibilityTimeout int64, waitTimeSeconds int64, maxNumberOfMessages int64) *Receiver {
+	receiver := NewReceiver(client, queueURL, ackOnConsume, visibilityTimeout, waitTimeSeconds, maxNumberOfMessages)
+	receiver.Ordered = true
+	return receiver
+}
+
+// Receive reads the messages until ctx is done or Close is called. The attributes are the system attributes and the message attributes, see GetAttributes.
+// The receipt handle and the message id are in ctx, see GetReceiptHandle and GetMessageId.
 func (c *Receiver) Receive(ctx context.Context, handle func(context.Context, []byte, map[string]string)) {
-	var result *sqs.ReceiveMessageOutput
-	var er1 error
-loop:
-	result, er1 = c.Client.ReceiveMessage(&sqs.ReceiveMessageInput{
-		AttributeNames: []*string{
-			aws.String(sqs.MessageSystemAttributeNameSentTimestamp),
-		},
-		MessageAttributeNames: []*string{
-			aws.String(sqs.QueueAttributeNameAll),
-		},
-		QueueUrl:            c.QueueURL,
-		MaxNumberOfMessages: aws.Int64(1),
-		VisibilityTimeout:   aws.Int64(c.VisibilityTimeout), // 20 seconds
-		WaitTimeSeconds:     aws.Int64(c.WaitTimeSeconds),
+	handle = mq.RecoverWithMap(handle, c.LogError, c.HandleError)
+	c.receive(ctx, func(ctx context.Context, m *types.Message) {
+		handle(ctx, []byte(*m.Body), GetAttributes(m))
 	})
-	if er1 != nil {
-		c.LogError(ctx, "Error when subscribe: "+er1.Error())
-	} else {
-		if len(result.Messages) > 0 {
-			m := result.Messages[0]
-			data := []byte(*m.Body)
-			attributes := PtrToMap(m.Attributes)
-			if c.AckOnConsume {
-				_, er2 := c.Client.DeleteMessage(&sqs.DeleteMessageInput{
-					QueueUrl:      c.QueueURL,
-					ReceiptHandle: result.Messages[0].ReceiptHandle,
-				})
-				if er2 != nil {
-					c.LogError(ctx, "Error when delete message: "+er2.Error())
-				} else {
-					handle(ctx, data, attributes)
-				}
-			} else {
-				handle(ctx, data, attributes)
-			}
-		}
-	}
-	goto loop
 }
 func (c *Receiver) ReceiveBody(ctx context.Context, handle func(context.Context, []byte)) {
-	var result *sqs.ReceiveMessageOutput
-	var er1 error
-loop:
-	result, er1 = c.Client.ReceiveMessage(&sqs.ReceiveMessageInput{
-		AttributeNames: []*string{
-			aws.String(sqs.MessageSystemAttributeNameSentTimestamp),
-		},
-		MessageAttributeNames: []*string{
-			aws.String(sqs.QueueAttributeNameAll),
-		},
-		QueueUrl:            c.QueueURL,
-		MaxNumberOfMessages: aws.Int64(1),
-		VisibilityTimeout:   aws.Int64(c.VisibilityTimeout), // 20 seconds
-		WaitTimeSeconds:     aws.Int64(c.WaitTimeSeconds),
+	handle = mq.Recover(handle, c.LogError)
+	c.receive(ctx, func(ctx context.Context, m *types.Message) {
+		handle(ctx, []byte(*m.Body))
 	})
-	if er1 != nil {
-		c.LogError(ctx, "Error when subscribe: "+er1.Error())
-	} else {
+}
+func (c *Receiver) ReceiveMessage(ctx context.Context, handle func(context.Context, *types.Message)) {
+	handle = mq.Recover(handle, c.LogError)
+	c.receive(ctx, handle)
+}
+func (c *Receiver) receive(ctx context.Context, handle func(context.Context, *types.Message)) {
+	c.loop(ctx, func(ctx context.Context, messages []*types.Message) {
+		c.handleMessages(ctx, messages, func(ctx context.Context, m *types.Message) {
+			handle(WithMessage(ctx, m), m)
+		})
+	})
+}
+
+// loop receives the messages until ctx is done or Close is called, and passes the messages of each receive call to handle
+func (c *Receiver) loop(ctx context.Context, handle func(context.Context, []*types.Message)) {
+	ctx2, done := c.canceler.WithCancel(ctx)
+	defer done()
+	ctx = mq.WithPauser(ctx, c)
+	policy := c.RetryPolicy
+	if policy == nil {
+		policy = DefaultReceiveRetryPolicy
+	}
+	errs := 0
+	var delay time.Duration
+	for ctx2.Err() == nil {
+		if c.gate.Wait(ctx2) != nil {
+			return
+		}
+		result, er1 := c.Client.ReceiveMessage(ctx2, &sqs.ReceiveMessageInput{
+			MessageSystemAttributeNames: c.attributeNames(),
+			MessageAttributeNames:       []string{"All"},
+			QueueUrl:                    c.QueueURL,
+			MaxNumberOfMessages:         int32(c.maxNumberOfMessages()),
+			VisibilityTimeout:           int32(c.VisibilityTimeout), // 20 seconds
+			WaitTimeSeconds:             int32(c.WaitTimeSeconds),
+		})
+		if er1 != nil {
+			if ctx2.Err() != nil {
+				return
+			}
+			c.LogError(ctx, "Error when subscribe: "+er1.Error())
+			errs++
+			d, ok := policy.Delay(errs, delay)
+			if !ok {
+				// the receiver does not stop when the policy stops, so it keeps waiting by the default policy
+				d, _ = DefaultReceiveRetryPolicy.Delay(errs, delay)
+			}
+			delay = d
+			if mq.Sleep(ctx2, delay) != nil {
+				return
+			}
+			continue
+		}
+		errs = 0
+		delay = 0
 		if len(result.Messages) > 0 {
-			m := result.Messages[0]
-			data := []byte(*m.Body)
-			if c.AckOnConsume {
-				_, er2 := c.Client.DeleteMessage(&sqs.DeleteMessageInput{
-					QueueUrl:      c.QueueURL,
-					ReceiptHandle: result.Messages[0].ReceiptHandle,
-				})
-				if er2 != nil {
-					c.LogError(ctx, "Error when delete message: "+er2.Error())
-				} else {
-					handle(ctx, data)
-				}
-			} else {
-				handle(ctx, data)
+			messages := make([]*types.Message, len(result.Messages))
+			for i := range result.Messages {
+				messages[i] = &result.Messages[i]
 			}
+			handle(ctx, messages)
 		}
 	}
-	goto loop
-}
-func (c *Receiver) ReceiveMessage(ctx context.Context, handle func(context.Context, *sqs.Message)) {
-	var result *sqs.ReceiveMessageOutput
-	var er1 error
-loop:
-	result, er1 = c.Client.ReceiveMessage(&sqs.ReceiveMessageInput{
-		AttributeNames: []*string{
-			aws.String(sqs.MessageSystemAttributeNameSentTimestamp),
-		},
-		MessageAttributeNames: []*string{
-			aws.String(sqs.QueueAttributeNameAll),
-		},
-		QueueUrl:            c.QueueURL,
-		MaxNumberOfMessages: aws.Int64(1),
-		VisibilityTimeout:   aws.Int64(c.VisibilityTimeout), // 20 seconds
-		WaitTimeSeconds:     aws.Int64(c.WaitTimeSeconds),
+}
+
+// handleMessages handles the messages in parallel, and waits until all of them are handled
+func (c *Receiver) handleMessages(ctx context.Context, messages []*types.Message, handle func(context.Context, *types.Message)) {
+	if c.AckOnConsume {
+		messages = c.deleteMessages(ctx, messages)
+		c.parallel(ctx, messages, func(m *types.Message) bool {
+			handle(ctx, m)
+			return true
+		})
+		return
+	}
+	if len(messages) == 1 {
+		handle(mq.WithDelivery(ctx, NewDelivery(c.Client, c.QueueURL, messages[0].ReceiptHandle)), messages[0])
+		return
+	}
+	acks := NewAcks(c.Client, c.QueueURL)
+	c.parallel(ctx, messages, func(m *types.Message) bool {
+		delivery := acks.Delivery(m.ReceiptHandle)
+		handle(mq.WithDelivery(ctx, delivery), m)
+		if c.Ordered {
+			c.wait(ctx, delivery)
+		}
+		return acks.Acked(m.ReceiptHandle)
 	})
-	if er1 != nil {
-		c.LogError(ctx, "Error when subscribe: "+er1.Error())
+	if err := acks.Flush(ctx); err != nil {
+		c.LogError(ctx, "Error when delete messages: "+err.Error())
+	}
+}
+
+// parallel calls handle for each message in a goroutine, or for each group of messages in a goroutine if Ordered is true.
+// In a group, if handle returns false, the next messages of the group are nacked instead of being handled.
+func (c *Receiver) parallel(ctx context.Context, messages []*types.Message, handle func(*types.Message) bool) {
+	var groups [][]*types.Message
+	if c.Ordered {
+		groups = GroupMessages(messages)
 	} else {
-		if len(result.Messages) > 0 {
-			m := result.Messages[0]
-			if c.AckOnConsume {
-				_, er2 := c.Client.DeleteMessage(&sqs.DeleteMessageInput{
-					QueueUrl:      c.QueueURL,
-					ReceiptHandle: result.Messages[0].ReceiptHandle,
-				})
-				if er2 != nil {
-					c.LogError(ctx, "Error when delete message: "+er2.Error())
-				} else {
-					handle(ctx, m)
+		groups = make([][]*types.Message, len(messages))
+		for i, m := range messages {
+			groups[i] = []*types.Message{m}
+		}
+	}
+	if len(groups) == 1 {
+		c.handleGroup(ctx, groups[0], handle)
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(groups))
+	for _, group := range groups {
+		go func(group []*types.Message) {
+			defer wg.Done()
+			c.handleGroup(ctx, group, handle)
+		}(group)
+	}
+	wg.Wait()
+}
+func (c *Receiver) handleGroup(ctx context.Context, group []*types.Message, handle func(*types.Message) bool) {
+	for i, m := range group {
+		if !handle(m) {
+			for _, next := range group[i+1:] {
+				if err := NewDelivery(c.Client, c.QueueURL, next.ReceiptHandle).Nack(ctx); err != nil {
+					c.LogError(ctx, "Error when nack message: "+err.Error())
 				}
-			} else {
-				handle(ctx, m)
 			}
+			return
+		}
+	}
+}
+
+// wait waits until the message is acked, nacked or requeued, ctx is done, or the visibility timeout expires, when the message is received again anyway
+func (c *Receiver) wait(ctx context.Context, delivery *Delivery) {
+	visibilityTimeout := c.VisibilityTimeout
+	if visibilityTimeout <= 0 {
+		visibilityTimeout = DefaultVisibilityTimeout
+	}
+	timer := time.NewTimer(time.Duration(visibilityTimeout) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-delivery.Settled():
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+func (c *Receiver) attributeNames() []types.MessageSystemAttributeName {
+	if c.Ordered {
+		return []types.MessageSystemAttributeName{
+			types.MessageSystemAttributeNameSentTimestamp,
+			types.MessageSystemAttributeNameMessageGroupId,
+			types.MessageSystemAttributeNameSequenceNumber,
+		}
+	}
+	return []types.MessageSystemAttributeName{
+		types.MessageSystemAttributeNameSentTimestamp,
+	}
+}
+
+// deleteMessages deletes the messages before they are handled, and returns the deleted messages
+func (c *Receiver) deleteMessages(ctx context.Context, messages []*types.Message) []*types.Message {
+	if len(messages) == 1 {
+		_, err := c.Client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      c.QueueURL,
+			ReceiptHandle: messages[0].ReceiptHandle,
+		})
+		if err != nil {
+			c.LogError(ctx, "Error when delete message: "+err.Error())
+			return nil
+		}
+		return messages
+	}
+	receiptHandles := make([]*string, len(messages))
+	for i, m := range messages {
+		receiptHandles[i] = m.ReceiptHandle
+	}
+	fails, err := DeleteMessageBatch(ctx, c.Client, c.QueueURL, receiptHandles)
+	if err != nil {
+		c.LogError(ctx, "Error when delete message: "+err.Error())
+	}
+	if len(fails) == 0 {
+		return messages
+	}
+	failed := make(map[int]bool, len(fails))
+	for _, i := range fails {
+		failed[i] = true
+	}
+	deleted := make([]*types.Message, 0, len(messages)-len(fails))
+	for i, m := range messages {
+		if !failed[i] {
+			deleted = append(deleted, m)
 		}
 	}
-	goto loop
+	return deleted
+}
+func (c *Receiver) maxNumberOfMessages() int64 {
+	if c.MaxNumberOfMessages <= 1 {
+		return 1
+	}
+	if c.MaxNumberOfMessages > MaxReceiveMessages {
+		return MaxReceiveMessages
+	}
+	return c.MaxNumberOfMessages
+}
+
+// Delete deletes the message of ctx, by the receipt handle in ctx
+func (c *Receiver) Delete(ctx context.Context) error {
+	_, err := c.Client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      c.QueueURL,
+		ReceiptHandle: aws.String(GetReceiptHandle(ctx)),
+	})
+	return err
+}
+
+// ChangeVisibility changes the visibility timeout of the message of ctx, by the receipt handle in ctx
+func (c *Receiver) ChangeVisibility(ctx context.Context, visibilityTimeout time.Duration) error {
+	return NewDelivery(c.Client, c.QueueURL, aws.String(GetReceiptHandle(ctx))).Requeue(ctx, visibilityTimeout)
+}
+
+// Pause stops receiving the messages, after the message which is being handled
+func (c *Receiver) Pause() error {
+	return c.gate.Pause()
+}
+func (c *Receiver) Resume() error {
+	return c.gate.Resume()
+}
+func (c *Receiver) Paused() bool {
+	return c.gate.Paused()
+}
+
+// Close stops the receiving loops. The sqs client has no connection to close.
+func (c *Receiver) Close() error {
+	c.canceler.Cancel()
+	return nil
 }
 func PtrToMap(m map[string]*string) map[string]string {
 	attributes := make(map[string]string)
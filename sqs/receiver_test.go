@@ -0,0 +1,88 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/core-go/mq"
+)
+
+// newGroupMessages returns the messages of the groups, whose bodies and receipt handles are the group id and the index in the group, such as a1
+func newGroupMessages(groups ...string) []*types.Message {
+	var messages []*types.Message
+	count := make(map[string]int)
+	for _, g := range groups {
+		count[g]++
+		id := fmt.Sprintf("%s%d", g, count[g])
+		messages = append(messages, &types.Message{
+			Body:          aws.String(id),
+			ReceiptHandle: aws.String(id),
+			Attributes:    map[string]string{string(types.MessageSystemAttributeNameMessageGroupId): g},
+		})
+	}
+	return messages
+}
+
+// TestReceiverOrderedAsync checks that the next message of a group is handled after the message is acked by an async handler
+func TestReceiverOrderedAsync(t *testing.T) {
+	client := &testClient{}
+	c := NewFIFOReceiver(client, "orders.fifo", false, 20, 0, 10)
+	c.LogError = func(ctx context.Context, msg string) {
+		t.Error(msg)
+	}
+	var mu sync.Mutex
+	var handled []string
+	acked := make(map[string]bool)
+	c.handleMessages(context.Background(), newGroupMessages("a", "a", "b", "a"), func(ctx context.Context, m *types.Message) {
+		id := aws.ToString(m.Body)
+		mu.Lock()
+		if id == "a2" && !acked["a1"] || id == "a3" && !acked["a2"] {
+			t.Errorf("%s is handled before the previous message of the group is acked", id)
+		}
+		handled = append(handled, id)
+		mu.Unlock()
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			acked[id] = true
+			mu.Unlock()
+			mq.Ack(ctx, nil)
+		}()
+	})
+	if len(handled) != 4 {
+		t.Errorf("handled = %v, want all messages", handled)
+	}
+	sort.Strings(client.deleted)
+	if fmt.Sprint(client.deleted) != "[a1 a2 a3 b1]" || len(client.nacked) != 0 {
+		t.Errorf("deleted = %v, nacked = %v, want all messages deleted", client.deleted, client.nacked)
+	}
+}
+
+func TestReceiverOrderedNack(t *testing.T) {
+	client := &testClient{}
+	c := NewFIFOReceiver(client, "orders.fifo", false, 20, 0, 10)
+	c.LogError = func(ctx context.Context, msg string) {
+		t.Error(msg)
+	}
+	var handled []string
+	c.handleMessages(context.Background(), newGroupMessages("a", "a", "a"), func(ctx context.Context, m *types.Message) {
+		handled = append(handled, aws.ToString(m.Body))
+		if aws.ToString(m.Body) == "a2" {
+			mq.Nack(ctx, nil)
+		} else {
+			mq.Ack(ctx, nil)
+		}
+	})
+	if fmt.Sprint(handled) != "[a1 a2]" {
+		t.Errorf("handled = %v, want the messages before the nacked message and the nacked message", handled)
+	}
+	if fmt.Sprint(client.deleted) != "[a1]" || fmt.Sprint(client.nacked) != "[a2 a3]" {
+		t.Errorf("deleted = %v, nacked = %v, want [a1], [a2 a3]", client.deleted, client.nacked)
+	}
+}
@@ -2,17 +2,23 @@ package sqs
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"time"
 )
 
+const MaxDelaySeconds = 900 // 15 minutes
+
+// If GroupId is not nil, the queue is a FIFO queue: the messages are sent with the group id and the deduplication id. FIFO queues have no delay of a message, so DelaySeconds must be 0.
 type Sender struct {
-	Client       *sqs.SQS
-	QueueURL     *string
-	DelaySeconds *int64 //could be 10
+	Client          Client
+	QueueURL        *string
+	DelaySeconds    *int64 //could be 10
+	GroupId         func(context.Context, []byte, map[string]string) string
+	DeduplicationId func(context.Context, []byte, map[string]string) string
 }
 
-func NewSenderByQueueName(client *sqs.SQS, queueName string, delaySeconds int64) (*Sender, error) {
+func NewSenderByQueueName(client Client, queueName string, delaySeconds int64) (*Sender, error) {
 	queueUrl, err := GetQueueUrl(client, queueName)
 	if err != nil {
 		return nil, err
@@ -20,34 +26,79 @@ func NewSenderByQueueName(client *sqs.SQS, queueName string, delaySeconds int64)
 	return NewSender(client, queueUrl, delaySeconds), nil
 }
 
-func NewSender(client *sqs.SQS, queueURL string, delaySeconds int64) *Sender {
+func NewSender(client Client, queueURL string, delaySeconds int64) *Sender {
 	return &Sender{Client: client, QueueURL: &queueURL, DelaySeconds: &delaySeconds}
 }
+func NewFIFOSender(client Client, queueURL string, groupId func(context.Context, []byte, map[string]string) string, deduplicationId func(context.Context, []byte, map[string]string) string) *Sender {
+	return &Sender{Client: client, QueueURL: &queueURL, GroupId: groupId, DeduplicationId: deduplicationId}
+}
+func NewSenderByConfig(client Client, c Config, delaySeconds int64) (*Sender, error) {
+	sender, err := NewSenderByQueueName(client, c.QueueName, delaySeconds)
+	if err != nil {
+		return nil, err
+	}
+	if c.FIFO || IsFIFO(c.QueueName) {
+		if delaySeconds > 0 {
+			return nil, ErrFIFODelay
+		}
+		sender.GroupId, sender.DeduplicationId = NewFIFO(c)
+	}
+	return sender, nil
+}
 func (p *Sender) Send(ctx context.Context, data []byte, attributes map[string]string) error {
 	attrs := MapToAttributes(attributes)
 	s := string(data)
-	_, err := p.Client.SendMessage(&sqs.SendMessageInput{
-		DelaySeconds:      p.DelaySeconds,
+	input := &sqs.SendMessageInput{
+		DelaySeconds:      int32(aws.ToInt64(p.DelaySeconds)),
+		MessageAttributes: attrs,
+		MessageBody:       aws.String(s),
+		QueueUrl:          p.QueueURL,
+	}
+	if err := setFIFO(ctx, input, data, attributes, p.GroupId, p.DeduplicationId); err != nil {
+		return err
+	}
+	_, err := p.Client.SendMessage(ctx, input)
+	return err
+}
+
+// SendWithDelay sends the message with the delay, which is limited to MaxDelaySeconds. It is the Send of mq.DelayedRetry. FIFO queues do not support the delay of a message, so it returns ErrFIFODelay for them.
+func (p *Sender) SendWithDelay(ctx context.Context, data []byte, attributes map[string]string, delay time.Duration) error {
+	seconds := int64(delay / time.Second)
+	if seconds > MaxDelaySeconds {
+		seconds = MaxDelaySeconds
+	}
+	attrs := MapToAttributes(attributes)
+	s := string(data)
+	input := &sqs.SendMessageInput{
+		DelaySeconds:      int32(seconds),
 		MessageAttributes: attrs,
 		MessageBody:       aws.String(s),
 		QueueUrl:          p.QueueURL,
-	})
+	}
+	if err := setFIFO(ctx, input, data, attributes, p.GroupId, p.DeduplicationId); err != nil {
+		return err
+	}
+	_, err := p.Client.SendMessage(ctx, input)
 	return err
 }
 func (p *Sender) SendBody(ctx context.Context, data []byte) error {
 	s := string(data)
-	_, err := p.Client.SendMessage(&sqs.SendMessageInput{
-		DelaySeconds: p.DelaySeconds,
+	input := &sqs.SendMessageInput{
+		DelaySeconds: int32(aws.ToInt64(p.DelaySeconds)),
 		MessageBody:  aws.String(s),
 		QueueUrl:     p.QueueURL,
-	})
+	}
+	if err := setFIFO(ctx, input, data, nil, p.GroupId, p.DeduplicationId); err != nil {
+		return err
+	}
+	_, err := p.Client.SendMessage(ctx, input)
 	return err
 }
 func (p *Sender) SendMessage(msg *sqs.SendMessageInput) (string, error) {
 	if msg == nil {
 		return "", nil
 	}
-	result, err := p.Client.SendMessage(msg)
+	result, err := p.Client.SendMessage(context.Background(), msg)
 	if result != nil && result.MessageId != nil {
 		return *result.MessageId, err
 	} else {
@@ -0,0 +1,27 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFIFOSenderDelay(t *testing.T) {
+	ctx := context.Background()
+	client := &testClient{}
+	groupId, deduplicationId := NewFIFO(Config{})
+	s := NewFIFOSender(client, "orders.fifo", groupId, deduplicationId)
+	if err := s.SendWithDelay(ctx, []byte("1"), nil, 10*time.Second); !errors.Is(err, ErrFIFODelay) {
+		t.Errorf("SendWithDelay() = %v, want %v", err, ErrFIFODelay)
+	}
+	if err := s.SendWithDelay(ctx, []byte("2"), nil, 0); err != nil {
+		t.Errorf("SendWithDelay() = %v, want nil without delay", err)
+	}
+	if len(client.sent) != 1 || client.sent[0] != "2" {
+		t.Errorf("sent = %v, want only the message without delay", client.sent)
+	}
+	if _, err := NewSenderByConfig(client, Config{QueueName: "orders.fifo"}, 10); !errors.Is(err, ErrFIFODelay) {
+		t.Errorf("NewSenderByConfig() = %v, want %v for a FIFO queue with delay", err, ErrFIFODelay)
+	}
+}
@@ -1,38 +1,79 @@
 package sqs
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sqs"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
 
 type (
+	// If FIFO is true, the group id of a message is the attribute MessageGroupIdName, or MessageGroupId. The deduplication id is the attribute DeduplicationIdName, or the hash of the body and the attributes (see ContentDeduplicationId).
+	// ContentBasedDeduplication is deprecated: the deduplication id is set even if it is enabled on the queue, so that the retries of the same body are not dropped.
+	// If AccessKeyID is empty, the credentials are loaded by the default provider chain: the environment variables, the shared config and credentials files (of Profile), and the roles of ECS and EC2.
+	// Endpoint overrides the endpoint of SQS, such as http://localhost:9324 for ElasticMQ or http://localhost:4566 for LocalStack.
 	Config struct {
-		Region          string `yaml:"region" mapstructure:"region" json:"region,omitempty" gorm:"column:region" bson:"region,omitempty" dynamodbav:"region,omitempty" firestore:"region,omitempty"`
-		AccessKeyID     string `yaml:"access_key_id" mapstructure:"access_key_id" json:"accessKeyID,omitempty" gorm:"column:accessKeyID" bson:"accessKeyID,omitempty" dynamodbav:"accessKeyID,omitempty" firestore:"accessKeyID,omitempty"`
-		SecretAccessKey string `yaml:"secret_access_key" mapstructure:"secret_access_key" json:"secretAccessKey,omitempty" gorm:"column:secretaccesskey" bson:"secretAccessKey,omitempty" dynamodbav:"secretAccessKey,omitempty" firestore:"secretAccessKey,omitempty"`
-		QueueName       string `yaml:"a" mapstructure:"queue_name" json:"queueName,omitempty" gorm:"column:token" bson:"queueName,omitempty" dynamodbav:"queueName,omitempty" firestore:"queueName,omitempty"`
+		Region                    string `yaml:"region" mapstructure:"region" json:"region,omitempty" gorm:"column:region" bson:"region,omitempty" dynamodbav:"region,omitempty" firestore:"region,omitempty"`
+		AccessKeyID               string `yaml:"access_key_id" mapstructure:"access_key_id" json:"accessKeyID,omitempty" gorm:"column:accessKeyID" bson:"accessKeyID,omitempty" dynamodbav:"accessKeyID,omitempty" firestore:"accessKeyID,omitempty"`
+		SecretAccessKey           string `yaml:"secret_access_key" mapstructure:"secret_access_key" json:"secretAccessKey,omitempty" gorm:"column:secretaccesskey" bson:"secretAccessKey,omitempty" dynamodbav:"secretAccessKey,omitempty" firestore:"secretAccessKey,omitempty"`
+		SessionToken              string `yaml:"session_token" mapstructure:"session_token" json:"sessionToken,omitempty" gorm:"column:sessiontoken" bson:"sessionToken,omitempty" dynamodbav:"sessionToken,omitempty" firestore:"sessionToken,omitempty"`
+		Profile                   string `yaml:"profile" mapstructure:"profile" json:"profile,omitempty" gorm:"column:profile" bson:"profile,omitempty" dynamodbav:"profile,omitempty" firestore:"profile,omitempty"`
+		Endpoint                  string `yaml:"endpoint" mapstructure:"endpoint" json:"endpoint,omitempty" gorm:"column:endpoint" bson:"endpoint,omitempty" dynamodbav:"endpoint,omitempty" firestore:"endpoint,omitempty"`
+		QueueName                 string `yaml:"a" mapstructure:"queue_name" json:"queueName,omitempty" gorm:"column:token" bson:"queueName,omitempty" dynamodbav:"queueName,omitempty" firestore:"queueName,omitempty"`
+		FIFO                      bool   `yaml:"fifo" mapstructure:"fifo" json:"fifo,omitempty" gorm:"column:fifo" bson:"fifo,omitempty" dynamodbav:"fifo,omitempty" firestore:"fifo,omitempty"`
+		MessageGroupId            string `yaml:"message_group_id" mapstructure:"message_group_id" json:"messageGroupId,omitempty" gorm:"column:messagegroupid" bson:"messageGroupId,omitempty" dynamodbav:"messageGroupId,omitempty" firestore:"messageGroupId,omitempty"`
+		MessageGroupIdName        string `yaml:"message_group_id_name" mapstructure:"message_group_id_name" json:"messageGroupIdName,omitempty" gorm:"column:messagegroupidname" bson:"messageGroupIdName,omitempty" dynamodbav:"messageGroupIdName,omitempty" firestore:"messageGroupIdName,omitempty"`
+		DeduplicationIdName       string `yaml:"deduplication_id_name" mapstructure:"deduplication_id_name" json:"deduplicationIdName,omitempty" gorm:"column:deduplicationidname" bson:"deduplicationIdName,omitempty" dynamodbav:"deduplicationIdName,omitempty" firestore:"deduplicationIdName,omitempty"`
+		ContentBasedDeduplication bool   `yaml:"content_based_deduplication" mapstructure:"content_based_deduplication" json:"contentBasedDeduplication,omitempty" gorm:"column:contentbaseddeduplication" bson:"contentBasedDeduplication,omitempty" dynamodbav:"contentBasedDeduplication,omitempty" firestore:"contentBasedDeduplication,omitempty"`
 	}
 )
 
-func NewSession(config Config) (*session.Session, error) {
-	c := &aws.Config{
-		Region:      aws.String(config.Region),
-		Credentials: credentials.NewStaticCredentials(config.AccessKeyID, config.SecretAccessKey, ""),
+// LoadConfig loads the aws config of the region and the credentials. The static credentials of AccessKeyID are used before the default provider chain.
+func LoadConfig(ctx context.Context, c Config, options ...func(*config.LoadOptions) error) (aws.Config, error) {
+	var opts []func(*config.LoadOptions) error
+	if len(c.Region) > 0 {
+		opts = append(opts, config.WithRegion(c.Region))
 	}
-	return session.NewSession(c)
+	if len(c.Profile) > 0 {
+		opts = append(opts, config.WithSharedConfigProfile(c.Profile))
+	}
+	if len(c.AccessKeyID) > 0 {
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, c.SessionToken)))
+	}
+	opts = append(opts, options...)
+	return config.LoadDefaultConfig(ctx, opts...)
 }
 
-func Connect(config Config) (*sqs.SQS, error) {
-	sess, err := NewSession(config)
+func Connect(c Config, options ...func(*config.LoadOptions) error) (*sqs.Client, error) {
+	cfg, err := LoadConfig(context.Background(), c, options...)
 	if err != nil {
 		return nil, err
 	}
-	mySQS := sqs.New(sess)
-	return mySQS, nil
+	return ConnectWithConfig(cfg, c.Endpoint), nil
+}
+
+// ConnectWithConfig creates the client of the aws config. If endpoint is not empty, it overrides the endpoint of SQS.
+func ConnectWithConfig(cfg aws.Config, endpoint ...string) *sqs.Client {
+	return sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		if len(endpoint) > 0 && len(endpoint[0]) > 0 {
+			o.BaseEndpoint = aws.String(endpoint[0])
+		}
+	})
 }
 
-func ConnectWithSession(session *session.Session) *sqs.SQS {
-	return sqs.New(session)
+// CredentialsChain returns the credentials of the first provider which succeeds, or the error of the last provider. It is passed to LoadConfig by config.WithCredentialsProvider, wrapped by aws.NewCredentialsCache.
+type CredentialsChain []aws.CredentialsProvider
+
+func (c CredentialsChain) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	err := errors.New("no credentials provider")
+	for _, provider := range c {
+		credentials, er1 := provider.Retrieve(ctx)
+		if er1 == nil {
+			return credentials, nil
+		}
+		err = er1
+	}
+	return aws.Credentials{}, err
 }
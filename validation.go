@@ -0,0 +1,91 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// The outcomes of an invalid message, used by Handler, RetryHandler and BatchWorker
+const (
+	ValidationSkip       = "skip"       // reject the message, and do not write it. This is the default.
+	ValidationContinue   = "continue"   // reject the message, then still write it
+	ValidationQuarantine = "quarantine" // send the message with the validation errors to Quarantine, and do not write it
+)
+
+// CheckValidation returns an error if the validation mode is unknown, or the mode is quarantine and quarantine is nil.
+// The constructors which return an error call it, so that an invalid config fails at start, instead of handling the invalid messages by another mode.
+func CheckValidation(mode string, quarantine func(context.Context, []byte, map[string]string) error) error {
+	switch mode {
+	case "", ValidationSkip, ValidationContinue:
+		return nil
+	case ValidationQuarantine:
+		if quarantine == nil {
+			return errors.New("validation mode quarantine requires a quarantine function")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown validation mode %q, it must be %s, %s or %s", mode, ValidationSkip, ValidationContinue, ValidationQuarantine)
+	}
+}
+
+// Validate runs the validation stage, and returns true if the message should be written.
+// When the message is not written, it is acked after Reject or Quarantine, or nacked if validate or quarantine returns an error, or reject nacks it.
+// If the mode is invalid by CheckValidation, the invalid message is nacked, so it is not lost.
+func Validate[T any](ctx context.Context, v *T, data []byte, attrs map[string]string,
+	validate func(context.Context, *T) ([]ErrorMessage, error),
+	reject func(context.Context, *T, []ErrorMessage, []byte, map[string]string),
+	quarantine func(context.Context, []byte, map[string]string) error,
+	mode string,
+	logError func(context.Context, string)) bool {
+	if validate == nil {
+		return true
+	}
+	errs, err := validate(ctx, v)
+	if err != nil {
+		if logError != nil {
+			logError(ctx, "Error when validate data: "+err.Error())
+		}
+		Nack(ctx, logError)
+		return false
+	}
+	if len(errs) == 0 {
+		return true
+	}
+	if er1 := CheckValidation(mode, quarantine); er1 != nil {
+		if logError != nil {
+			logError(ctx, fmt.Sprintf("Cannot validate message: %s Error: %s", GetLog(data, attrs), er1.Error()))
+		}
+		Nack(ctx, logError)
+		return false
+	}
+	switch mode {
+	case ValidationContinue:
+		if reject != nil {
+			// the message is written, even if reject nacks it
+			Handled(ctx, func(ctx context.Context) {
+				reject(ctx, v, errs, data, attrs)
+			})
+		}
+		return true
+	case ValidationQuarantine:
+		headers := BuildDLQAttributes(attrs, ReasonValidation, "", "", errs)
+		if er2 := quarantine(ctx, data, headers); er2 != nil {
+			if logError != nil {
+				logError(ctx, fmt.Sprintf("Cannot quarantine message: %s Error: %s", GetLog(data, attrs), er2.Error()))
+			}
+			Nack(ctx, logError)
+		} else {
+			Ack(ctx, logError)
+		}
+		return false
+	}
+	if reject != nil && !Handled(ctx, func(ctx context.Context) {
+		reject(ctx, v, errs, data, attrs)
+	}) {
+		Nack(ctx, logError)
+		return false
+	}
+	Ack(ctx, logError)
+	return false
+}
@@ -0,0 +1,217 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testUser struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type testDelivery struct {
+	acks  int
+	nacks int
+}
+
+func (d *testDelivery) Ack(ctx context.Context) error {
+	d.acks++
+	return nil
+}
+func (d *testDelivery) Nack(ctx context.Context) error {
+	d.nacks++
+	return nil
+}
+func (d *testDelivery) Requeue(ctx context.Context, delay time.Duration) error {
+	d.nacks++
+	return nil
+}
+
+func validateUser(ctx context.Context, u *testUser) ([]ErrorMessage, error) {
+	if len(u.Name) == 0 {
+		return []ErrorMessage{{Field: "name", Code: "required"}}, nil
+	}
+	return nil, nil
+}
+
+type validationCase struct {
+	name          string
+	data          string
+	mode          string
+	quarantineErr error
+	noQuarantine  bool
+	validateErr   error
+	written       int
+	rejected      int
+	quarantined   int
+	acks          int
+	nacks         int
+}
+
+var validationCases = []validationCase{
+	{name: "valid", data: `{"id":"1","name":"a"}`, mode: ValidationSkip, written: 1, acks: 1},
+	{name: "skip", data: `{"id":"1"}`, mode: ValidationSkip, rejected: 1, acks: 1},
+	{name: "default mode is skip", data: `{"id":"1"}`, mode: "", rejected: 1, acks: 1},
+	{name: "continue", data: `{"id":"1"}`, mode: ValidationContinue, written: 1, rejected: 1, acks: 1},
+	{name: "quarantine", data: `{"id":"1"}`, mode: ValidationQuarantine, quarantined: 1, acks: 1},
+	{name: "quarantine fails", data: `{"id":"1"}`, mode: ValidationQuarantine, quarantineErr: errors.New("quarantine is down"), quarantined: 1, nacks: 1},
+	{name: "validate fails", data: `{"id":"1","name":"a"}`, mode: ValidationSkip, validateErr: errors.New("validator is down"), nacks: 1},
+	{name: "unknown mode", data: `{"id":"1"}`, mode: "drop", nacks: 1},
+	{name: "quarantine without function", data: `{"id":"1"}`, mode: ValidationQuarantine, noQuarantine: true, nacks: 1},
+}
+
+type validationRecorder struct {
+	c           validationCase
+	written     int
+	rejected    int
+	quarantined int
+}
+
+func (r *validationRecorder) write(ctx context.Context, u *testUser) error {
+	r.written++
+	return nil
+}
+func (r *validationRecorder) validate(ctx context.Context, u *testUser) ([]ErrorMessage, error) {
+	if r.c.validateErr != nil {
+		return nil, r.c.validateErr
+	}
+	return validateUser(ctx, u)
+}
+func (r *validationRecorder) reject(ctx context.Context, u *testUser, errs []ErrorMessage, data []byte, attrs map[string]string) {
+	r.rejected++
+}
+func (r *validationRecorder) quarantine(ctx context.Context, data []byte, attrs map[string]string) error {
+	r.quarantined++
+	if len(attrs[DLQErrorsName]) == 0 {
+		return errors.New("no validation errors in attributes")
+	}
+	return r.c.quarantineErr
+}
+func (r *validationRecorder) check(t *testing.T, d *testDelivery) {
+	t.Helper()
+	if r.written != r.c.written {
+		t.Errorf("written = %d, want %d", r.written, r.c.written)
+	}
+	if r.rejected != r.c.rejected {
+		t.Errorf("rejected = %d, want %d", r.rejected, r.c.rejected)
+	}
+	if r.quarantined != r.c.quarantined {
+		t.Errorf("quarantined = %d, want %d", r.quarantined, r.c.quarantined)
+	}
+	if d.acks != r.c.acks || d.nacks != r.c.nacks {
+		t.Errorf("acks = %d, nacks = %d, want %d, %d", d.acks, d.nacks, r.c.acks, r.c.nacks)
+	}
+}
+
+func TestHandlerValidation(t *testing.T) {
+	for _, c := range validationCases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &validationRecorder{c: c}
+			h := NewHandlerWithKey[testUser](nil, r.write, r.validate, func(ctx context.Context, u *testUser, errs []ErrorMessage, data []byte) {
+				r.reject(ctx, u, errs, data, nil)
+			}, nil, nil, false, "")
+			h.ValidationMode = c.mode
+			if !c.noQuarantine {
+				h.Quarantine = r.quarantine
+			}
+			d := &testDelivery{}
+			h.Handle(WithDelivery(context.Background(), d), []byte(c.data))
+			r.check(t, d)
+		})
+	}
+}
+
+func TestRetryHandlerValidation(t *testing.T) {
+	for _, c := range validationCases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &validationRecorder{c: c}
+			h := NewRetryHandler[testUser](nil, r.write, r.validate, r.reject, nil, nil, 0, "", false, "")
+			h.ValidationMode = c.mode
+			if !c.noQuarantine {
+				h.Quarantine = r.quarantine
+			}
+			d := &testDelivery{}
+			h.Handle(WithDelivery(context.Background(), d), []byte(c.data), nil)
+			r.check(t, d)
+		})
+	}
+}
+
+func TestBatchWorkerValidation(t *testing.T) {
+	for _, c := range validationCases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &validationRecorder{c: c}
+			handle := func(ctx context.Context, messages []Message[testUser]) ([]Message[testUser], error) {
+				for i := range messages {
+					if err := r.write(ctx, &messages[i].Value); err != nil {
+						return nil, err
+					}
+				}
+				return nil, nil
+			}
+			w := NewBatchWorker[testUser](10, 1000, nil, handle, r.validate, r.reject, nil, nil, 0, "", false, "")
+			w.ValidationMode = c.mode
+			if !c.noQuarantine {
+				w.Quarantine = r.quarantine
+			}
+			d := &testDelivery{}
+			w.HandleBatch(context.Background(), []RawMessage{{Data: []byte(c.data), Delivery: d}})
+			r.check(t, d)
+		})
+	}
+}
+
+func TestValidationConfig(t *testing.T) {
+	quarantine := func(ctx context.Context, data []byte, attrs map[string]string) error {
+		return nil
+	}
+	tests := []struct {
+		name       string
+		mode       string
+		quarantine func(context.Context, []byte, map[string]string) error
+		err        bool
+	}{
+		{name: "default"},
+		{name: "skip", mode: ValidationSkip},
+		{name: "continue", mode: ValidationContinue},
+		{name: "quarantine", mode: ValidationQuarantine, quarantine: quarantine},
+		{name: "quarantine without function", mode: ValidationQuarantine, err: true},
+		{name: "unknown", mode: "drop", quarantine: quarantine, err: true},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			var errs [3]error
+			_, errs[0] = NewHandlerByConfigE[testUser](HandlerConfig{Validation: c.mode}, nil, validateUser, nil, nil, c.quarantine)
+			_, errs[1] = NewRetryHandlerByConfigE[testUser](RetryHandlerConfig{Validation: c.mode}, nil, validateUser, nil, nil, nil, c.quarantine)
+			_, errs[2] = NewBatchWorkerByConfigE[testUser](BatchConfig{Validation: c.mode}, nil, validateUser, nil, nil, nil, c.quarantine)
+			for i, err := range errs {
+				if (err != nil) != c.err {
+					t.Errorf("constructor %d: err = %v, want an error: %v", i, err, c.err)
+				}
+			}
+		})
+	}
+}
+
+// TestRejectFails checks that the message is nacked, and not written, when it cannot be rejected to the DLQ
+func TestRejectFails(t *testing.T) {
+	var written int
+	dlq := NewDLQ[*testUser](func(ctx context.Context, data []byte, attrs map[string]string) error {
+		return errors.New("dlq is down")
+	}, "users", "")
+	h := NewHandlerWithKey[testUser](nil, func(ctx context.Context, u *testUser) error {
+		written++
+		return nil
+	}, validateUser, dlq.Reject, nil, nil, false, "")
+	d := &testDelivery{}
+	h.Handle(WithDelivery(context.Background(), d), []byte(`{"id":"1"}`))
+	if written != 0 {
+		t.Errorf("written = %d, want 0", written)
+	}
+	if d.acks != 0 || d.nacks != 1 {
+		t.Errorf("acks = %d, nacks = %d, want 0, 1", d.acks, d.nacks)
+	}
+}
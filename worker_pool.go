@@ -0,0 +1,122 @@
+package mq
+
+import (
+	"context"
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const DefaultQueueSize = 16
+
+// WorkerPool runs the tasks with at most size goroutines. Submit blocks when all workers are busy, which is the backpressure to the consumer.
+// If ordered is true, the tasks of the same key run serially on the same worker, the tasks of different keys run in parallel.
+type WorkerPool struct {
+	size    int
+	ordered bool
+	slots   chan struct{}
+	queues  []chan func()
+	next    uint32
+	once    sync.Once
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+}
+
+func NewWorkerPool(size int, ordered bool) *WorkerPool {
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	return &WorkerPool{size: size, ordered: ordered, slots: make(chan struct{}, size)}
+}
+
+// NewWorkerPoolByConfig returns nil if goroutines is false or there is no limit, then the handler starts a goroutine for each message, as before
+func NewWorkerPoolByConfig(goroutines bool, concurrency int, ordered bool) *WorkerPool {
+	if !goroutines || (concurrency <= 0 && !ordered) {
+		return nil
+	}
+	return NewWorkerPool(concurrency, ordered)
+}
+
+// Submit runs the task by a worker. After Close, the task runs in the goroutine of the caller.
+func (p *WorkerPool) Submit(key string, task func()) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		task()
+		return
+	}
+	p.wg.Add(1)
+	if !p.ordered {
+		p.slots <- struct{}{}
+		go func() {
+			defer func() {
+				<-p.slots
+				p.wg.Done()
+			}()
+			task()
+		}()
+		return
+	}
+	p.once.Do(p.start)
+	var i uint32
+	if len(key) > 0 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		i = h.Sum32() % uint32(p.size)
+	} else {
+		i = atomic.AddUint32(&p.next, 1) % uint32(p.size)
+	}
+	p.queues[i] <- task
+}
+
+// Wait waits until all submitted tasks are done
+func (p *WorkerPool) Wait() {
+	p.wg.Wait()
+}
+
+// Close waits until all submitted tasks are done, then stops the workers. It waits again if it is called after Stop returns on ctx done.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	closed := p.closed
+	p.closed = true
+	p.mu.Unlock()
+	p.wg.Wait()
+	if closed {
+		return
+	}
+	for _, queue := range p.queues {
+		close(queue)
+	}
+}
+
+// Stop closes the pool, and returns ctx.Err() if ctx is done before the submitted tasks are done
+func (p *WorkerPool) Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Close()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+func (p *WorkerPool) start() {
+	p.queues = make([]chan func(), p.size)
+	for i := 0; i < p.size; i++ {
+		queue := make(chan func(), DefaultQueueSize)
+		p.queues[i] = queue
+		go func() {
+			for task := range queue {
+				func() {
+					defer p.wg.Done()
+					task()
+				}()
+			}
+		}()
+	}
+}
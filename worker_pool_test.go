@@ -0,0 +1,151 @@
+package mq
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolConcurrency(t *testing.T) {
+	tests := []struct {
+		name    string
+		ordered bool
+	}{
+		{name: "unordered"},
+		{name: "ordered", ordered: true},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewWorkerPool(2, c.ordered)
+			var running, max, done int32
+			for i := 0; i < 20; i++ {
+				p.Submit(strconv.Itoa(i), func() {
+					n := atomic.AddInt32(&running, 1)
+					for {
+						m := atomic.LoadInt32(&max)
+						if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+							break
+						}
+					}
+					time.Sleep(2 * time.Millisecond)
+					atomic.AddInt32(&running, -1)
+					atomic.AddInt32(&done, 1)
+				})
+			}
+			p.Close()
+			if done != 20 {
+				t.Errorf("done = %d, want 20", done)
+			}
+			if max > 2 {
+				t.Errorf("max running = %d, want at most 2", max)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolOrdered(t *testing.T) {
+	p := NewWorkerPool(4, true)
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	running := make(map[string]bool)
+	keys := []string{"a", "b", "c", "d", "e"}
+	for i := 0; i < 50; i++ {
+		for _, key := range keys {
+			key, i := key, i
+			p.Submit(key, func() {
+				mu.Lock()
+				if running[key] {
+					t.Errorf("tasks of key %s run in parallel", key)
+				}
+				running[key] = true
+				mu.Unlock()
+				time.Sleep(50 * time.Microsecond)
+				mu.Lock()
+				running[key] = false
+				got[key] = append(got[key], i)
+				mu.Unlock()
+			})
+		}
+	}
+	p.Close()
+	for _, key := range keys {
+		seq := got[key]
+		if len(seq) != 50 {
+			t.Fatalf("key %s has %d tasks, want 50", key, len(seq))
+		}
+		for i, v := range seq {
+			if v != i {
+				t.Fatalf("key %s runs task %d at position %d, want in order", key, v, i)
+			}
+		}
+	}
+}
+
+func TestWorkerPoolStop(t *testing.T) {
+	p := NewWorkerPool(2, false)
+	release := make(chan struct{})
+	var done int32
+	for i := 0; i < 2; i++ {
+		p.Submit("", func() {
+			<-release
+			atomic.AddInt32(&done, 1)
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Stop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Stop() = %v, want %v while the tasks are running", err, context.DeadlineExceeded)
+	}
+	close(release)
+	if err := p.Stop(context.Background()); err != nil {
+		t.Errorf("Stop() = %v, want nil", err)
+	}
+	if n := atomic.LoadInt32(&done); n != 2 {
+		t.Errorf("done = %d, want 2, Stop must drain the submitted tasks", n)
+	}
+}
+
+func TestWorkerPoolSubmitAfterClose(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		p := NewWorkerPool(1, ordered)
+		p.Submit("a", func() {})
+		p.Close()
+		ran := false
+		p.Submit("a", func() {
+			ran = true
+		})
+		if !ran {
+			t.Errorf("ordered %v: the task is not run by Submit after Close", ordered)
+		}
+		p.Close()
+	}
+}
+
+func TestNewWorkerPoolByConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		goroutines  bool
+		concurrency int
+		ordered     bool
+		pool        bool
+	}{
+		{name: "no goroutines", concurrency: 4},
+		{name: "no limit", goroutines: true},
+		{name: "limit", goroutines: true, concurrency: 4, pool: true},
+		{name: "ordered", goroutines: true, ordered: true, pool: true},
+	}
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewWorkerPoolByConfig(c.goroutines, c.concurrency, c.ordered)
+			if (p != nil) != c.pool {
+				t.Errorf("NewWorkerPoolByConfig() = %v, want a pool: %v", p, c.pool)
+			}
+			if p != nil {
+				p.Close()
+			}
+		})
+	}
+}